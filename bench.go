@@ -0,0 +1,99 @@
+//go:build bench
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/event"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+	"github.com/promotedai/promoted-go-delivery-client/promotedtest"
+)
+
+// runBench drives the client against the in-process fake server and prints latency, allocation, goroutine,
+// fallback and Metrics API numbers.
+func runBench(args []string, env *cliEnv) int {
+	fs := newFlagSet("bench", env)
+	qps := fs.Float64("qps", 100, "target Deliver calls per second, or 0 for as fast as possible")
+	concurrency := fs.Int("concurrency", 16, "number of goroutines calling Deliver")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run")
+	requests := fs.Int("requests", 0, "stop after this many requests instead of the duration")
+	insertions := fs.Int("insertions", 1000, "request insertions per request")
+	pageSize := fs.Int("page-size", 0, "paging size of each request, or 0 for every insertion")
+	latency := fs.Duration("latency", 0, "latency added by the fake Delivery and Metrics APIs")
+	timeoutMillis := fs.Int64("timeout", 250, "Delivery API timeout in milliseconds")
+	arm := fs.String("arm", "", `experiment arm set on every request: "control", "treatment" or "" for none`)
+	acceptGzip := fs.Bool("gzip", true, "accept gzip-compressed Delivery API responses")
+	compression := fs.String("compression", "none", `request body compression: "none", "gzip" or "zstd"`)
+	verbose := fs.Bool("v", false, "print the client's log output")
+	if _, code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(env.stderr, "bench does not take a file")
+		return exitUsage
+	}
+
+	config := promotedtest.LoadConfig{
+		QPS:         *qps,
+		Concurrency: *concurrency,
+		Insertions:  *insertions,
+		PageSize:    *pageSize,
+	}
+	if *requests > 0 {
+		config.Requests = *requests
+	} else {
+		config.Duration = *duration
+	}
+	switch *arm {
+	case "":
+	case "control":
+		config.Experiment = &event.CohortMembership{CohortId: "BENCH", Arm: event.CohortArm_CONTROL}
+	case "treatment":
+		config.Experiment = &event.CohortMembership{CohortId: "BENCH", Arm: event.CohortArm_TREATMENT}
+	default:
+		return fail(env, fmt.Errorf("unknown arm %q", *arm))
+	}
+	encoding, err := promoted.ParseCompression(*compression)
+	if err != nil {
+		return fail(env, err)
+	}
+
+	if !*verbose {
+		// Fallbacks log every failed call, which would drown the report.
+		defer log.SetOutput(log.Writer())
+		log.SetOutput(io.Discard)
+	}
+
+	server := promotedtest.NewUnstartedServer().WithRecording(false).WithLatency(*latency)
+	server.Start()
+	defer server.Close()
+	client, err := server.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithDeliveryTimeoutMillis(*timeoutMillis).
+		WithMaxRequestInsertions(max(*insertions, 1)).
+		WithAcceptsGzip(*acceptGzip).
+		WithRequestCompression(promoted.RequestCompression{Encoding: encoding}).
+		Build()
+	if err != nil {
+		return fail(env, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	report, err := promotedtest.RunLoad(ctx, client, server, config)
+	if err != nil {
+		return fail(env, err)
+	}
+	fmt.Fprintf(env.stdout, "%d insertions, %d workers, target %.0f qps, %s server latency\n", config.Insertions, config.Concurrency, config.QPS, *latency)
+	if err := report.Write(env.stdout); err != nil {
+		return fail(env, err)
+	}
+	return exitOK
+}
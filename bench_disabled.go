@@ -0,0 +1,12 @@
+//go:build !bench
+
+package main
+
+import "fmt"
+
+// runBench reports that the bench command is not built in. It needs the promotedtest fake server, which links the
+// testing package, so it is only built with the bench build tag.
+func runBench(args []string, env *cliEnv) int {
+	fmt.Fprintln(env.stderr, `bench is not built into this binary; build it with "go build -tags bench"`)
+	return exitFailure
+}
@@ -0,0 +1,15 @@
+//go:build !bench
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBenchCommandNotBuilt(t *testing.T) {
+	code, _, stderr := runCLI([]string{"bench"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "bench is not built into this binary; build it with \"go build -tags bench\"\n", stderr)
+}
@@ -0,0 +1,34 @@
+//go:build bench
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBenchCommand(t *testing.T) {
+	code, stdout, stderr := runCLI([]string{"bench", "-requests", "20", "-concurrency", "2", "-insertions", "10", "-qps", "0", "-arm", "control"}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "10 insertions, 2 workers, target 0 qps, 0s server latency\n"), stdout)
+	assert.Contains(t, stdout, "requests        20\n")
+	assert.Contains(t, stdout, "fallbacks       0 (0.00%)\n")
+	assert.Contains(t, stdout, "metrics logs    20 (")
+
+	code, _, stderr = runCLI([]string{"bench", "-requests", "1", "-arm", "holdout"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: unknown arm \"holdout\"\n", stderr)
+
+	code, stdout, stderr = runCLI([]string{"bench", "-requests", "5", "-insertions", "100", "-qps", "0", "-compression", "zstd"}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "fallbacks       0 (0.00%)\n")
+
+	code, _, stderr = runCLI([]string{"bench", "-requests", "1", "-compression", "brotli"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: unknown compression \"brotli\", expected none, gzip or zstd\n", stderr)
+
+	code, _, _ = runCLI([]string{"bench", "request.json"}, nil, "")
+	assert.Equal(t, exitUsage, code)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+const defaultTimeoutMillis = 3000
+
+// apiFlags are the endpoint, key and timeout flags shared by the commands that call an API.
+type apiFlags struct {
+	endpoint      string
+	apiKey        string
+	timeoutMillis int64
+}
+
+// registerDeliveryFlags adds the Delivery API flags, defaulting to the environment.
+func (f *apiFlags) registerDeliveryFlags(fs *flag.FlagSet, env *cliEnv) {
+	fs.StringVar(&f.endpoint, "endpoint", env.getenv("PROMOTED_DELIVERY_ENDPOINT"), "Delivery API endpoint (env PROMOTED_DELIVERY_ENDPOINT)")
+	fs.StringVar(&f.apiKey, "api-key", env.getenv("PROMOTED_DELIVERY_API_KEY"), "Delivery API key (env PROMOTED_DELIVERY_API_KEY)")
+	fs.Int64Var(&f.timeoutMillis, "timeout", defaultTimeoutMillis, "request timeout in milliseconds")
+}
+
+// newDeliveryAPI creates a Delivery API client from the flags, returning an error for an invalid endpoint.
+func (f *apiFlags) newDeliveryAPI(maxRequestInsertions int, acceptGzip bool) (*promoted.PromotedDeliveryAPI, error) {
+	timeout := time.Duration(f.timeoutMillis) * time.Millisecond
+	httpClient, err := promoted.HTTPOptions{}.NewClient(timeout)
+	if err != nil {
+		return nil, err
+	}
+	return promoted.NewCheckedPromotedDeliveryAPI(httpClient, f.endpoint, f.apiKey, f.timeoutMillis, maxRequestInsertions, acceptGzip, false)
+}
+
+// registerMetricsFlags adds the Metrics API flags, defaulting to the environment.
+func (f *apiFlags) registerMetricsFlags(fs *flag.FlagSet, env *cliEnv) {
+	fs.StringVar(&f.endpoint, "endpoint", env.getenv("PROMOTED_METRICS_ENDPOINT"), "Metrics API endpoint (env PROMOTED_METRICS_ENDPOINT)")
+	fs.StringVar(&f.apiKey, "api-key", env.getenv("PROMOTED_METRICS_API_KEY"), "Metrics API key (env PROMOTED_METRICS_API_KEY)")
+	fs.Int64Var(&f.timeoutMillis, "timeout", defaultTimeoutMillis, "request timeout in milliseconds")
+}
+
+// check returns an error if the endpoint or timeout is missing.
+func (f *apiFlags) check() error {
+	if f.endpoint == "" {
+		return errors.New("endpoint must be set with -endpoint or the environment")
+	}
+	if f.timeoutMillis <= 0 {
+		return errors.New("timeout must be positive")
+	}
+	return nil
+}
+
+// newFlagSet creates a flag set that reports errors instead of exiting.
+func newFlagSet(name string, env *cliEnv) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(env.stderr)
+	return fs
+}
+
+// parseFlags parses the flags and returns the optional file argument. ok is false with the exit code when the
+// command should stop.
+func parseFlags(fs *flag.FlagSet, args []string) (file string, code int, ok bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return "", exitOK, false
+		}
+		return "", exitUsage, false
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintf(fs.Output(), "expected at most one file, got %d arguments\n", fs.NArg())
+		return "", exitUsage, false
+	}
+	return fs.Arg(0), exitOK, true
+}
+
+// readJSONFile reads a protojson message from the file, or from stdin for "-" or "".
+func readJSONFile(file string, stdin io.Reader, msg proto.Message) error {
+	var data []byte
+	var err error
+	if file == "" || file == "-" {
+		data, err = io.ReadAll(stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return fmt.Errorf("error reading %s: %v", displayName(file), err)
+	}
+	if err := protojson.Unmarshal(data, msg); err != nil {
+		return fmt.Errorf("error parsing %s: %v", displayName(file), err)
+	}
+	return nil
+}
+
+func displayName(file string) string {
+	if file == "" || file == "-" {
+		return "stdin"
+	}
+	return file
+}
+
+// fail prints the error and returns the failure exit code.
+func fail(env *cliEnv, err error) int {
+	fmt.Fprintf(env.stderr, "error: %v\n", err)
+	return exitFailure
+}
+
+// runDeliver sends a request to the Delivery API, or runs SDK delivery locally, and prints the response.
+func runDeliver(args []string, env *cliEnv) int {
+	fs := newFlagSet("deliver", env)
+	var api apiFlags
+	api.registerDeliveryFlags(fs, env)
+	sdkOnly := fs.Bool("sdk-only", false, "run SDK delivery locally instead of calling the Delivery API")
+	retrievalInsertionOffset := fs.Int("retrieval-insertion-offset", 0, "start index of the request insertions in the list of ALL insertions")
+	maxRequestInsertions := fs.Int("max-request-insertions", 1000, "maximum number of request insertions sent to the Delivery API")
+	asJSON := fs.Bool("json", false, "print the response as JSON instead of a table")
+	file, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+
+	req := &delivery.Request{}
+	if err := readJSONFile(file, env.stdin, req); err != nil {
+		return fail(env, err)
+	}
+	deliveryRequest := promoted.NewDeliveryRequest(req, nil, false, *retrievalInsertionOffset, nil)
+
+	var deliveryAPI promoted.DeliveryAPI
+	if *sdkOnly {
+		deliveryAPI = promoted.NewSDKDelivery()
+	} else {
+		if err := api.check(); err != nil {
+			return fail(env, err)
+		}
+		var err error
+		deliveryAPI, err = api.newDeliveryAPI(*maxRequestInsertions, true)
+		if err != nil {
+			return fail(env, err)
+		}
+	}
+
+	resp, err := deliveryAPI.RunDelivery(deliveryRequest)
+	if err != nil {
+		return fail(env, err)
+	}
+	if *asJSON {
+		out, err := protojson.MarshalOptions{Multiline: true}.Marshal(resp)
+		if err != nil {
+			return fail(env, err)
+		}
+		fmt.Fprintln(env.stdout, string(out))
+		return exitOK
+	}
+	printResponse(env.stdout, resp)
+	return exitOK
+}
+
+// printResponse prints the ranked insertions as a table.
+func printResponse(w io.Writer, resp *delivery.Response) {
+	fmt.Fprintf(w, "requestId: %s\n", resp.GetRequestId())
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POSITION\tCONTENT ID\tINSERTION ID")
+	for _, ins := range resp.GetInsertion() {
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", ins.GetPosition(), ins.GetContentId(), ins.GetInsertionId())
+	}
+	tw.Flush()
+}
+
+// runValidate runs the default request validators and prints each issue.
+func runValidate(args []string, env *cliEnv) int {
+	fs := newFlagSet("validate", env)
+	retrievalInsertionOffset := fs.Int("retrieval-insertion-offset", 0, "start index of the request insertions in the list of ALL insertions")
+	file, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+
+	req := &delivery.Request{}
+	if err := readJSONFile(file, env.stdin, req); err != nil {
+		return fail(env, err)
+	}
+	issues := promoted.NewDeliveryRequest(req, nil, false, *retrievalInsertionOffset, nil).ValidateIssues()
+	if len(issues) == 0 {
+		fmt.Fprintln(env.stdout, "ok")
+		return exitOK
+	}
+	for _, issue := range issues {
+		fmt.Fprintf(env.stdout, "%s: %s [%s]\n", issue.Field, issue.Message, issue.Rule)
+	}
+	fmt.Fprintf(env.stderr, "%d validation issue(s)\n", len(issues))
+	return exitFailure
+}
+
+// runLog sends a log request to the Metrics API.
+func runLog(args []string, env *cliEnv) int {
+	fs := newFlagSet("log", env)
+	var api apiFlags
+	api.registerMetricsFlags(fs, env)
+	file, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if err := api.check(); err != nil {
+		return fail(env, err)
+	}
+
+	logRequest := &event.LogRequest{}
+	if err := readJSONFile(file, env.stdin, logRequest); err != nil {
+		return fail(env, err)
+	}
+	metricsAPI := promoted.NewPromotedMetricsAPI(api.endpoint, api.apiKey, api.timeoutMillis)
+	if err := metricsAPI.RunMetricsLogging(logRequest); err != nil {
+		return fail(env, err)
+	}
+	fmt.Fprintln(env.stdout, "ok")
+	return exitOK
+}
+
+// runHealth calls the Delivery API health check.
+func runHealth(args []string, env *cliEnv) int {
+	fs := newFlagSet("health", env)
+	var api apiFlags
+	api.registerDeliveryFlags(fs, env)
+	if _, code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if err := api.check(); err != nil {
+		return fail(env, err)
+	}
+
+	deliveryAPI, err := api.newDeliveryAPI(0, false)
+	if err != nil {
+		return fail(env, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(api.timeoutMillis)*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := deliveryAPI.HealthCheck(ctx); err != nil {
+		return fail(env, err)
+	}
+	fmt.Fprintf(env.stdout, "ok (%s)\n", time.Since(start).Round(time.Millisecond))
+	return exitOK
+}
+
+// experimentFlags configure a TwoArmExperiment.
+type experimentFlags struct {
+	cohortID                  string
+	numActiveControlBuckets   int
+	numControlBuckets         int
+	numActiveTreatmentBuckets int
+	numTreatmentBuckets       int
+}
+
+// register adds the experiment flags, with names starting with the prefix.
+func (f *experimentFlags) register(fs *flag.FlagSet, prefix, description string) {
+	fs.StringVar(&f.cohortID, prefix+"cohort-id", "", "cohort ID of the "+description)
+	fs.IntVar(&f.numActiveControlBuckets, prefix+"active-control", 50, "number of active control buckets of the "+description)
+	fs.IntVar(&f.numControlBuckets, prefix+"control", 50, "number of control buckets of the "+description)
+	fs.IntVar(&f.numActiveTreatmentBuckets, prefix+"active-treatment", 50, "number of active treatment buckets of the "+description)
+	fs.IntVar(&f.numTreatmentBuckets, prefix+"treatment", 50, "number of treatment buckets of the "+description)
+}
+
+// experiment creates the TwoArmExperiment.
+func (f *experimentFlags) experiment() (*promoted.TwoArmExperiment, error) {
+	return promoted.NewTwoArmExperiment(f.cohortID, f.numActiveControlBuckets, f.numControlBuckets, f.numActiveTreatmentBuckets, f.numTreatmentBuckets)
+}
+
+// runSimulate assigns users to a TwoArmExperiment and reports the split, the sample ratio mismatch test and the
+// overlap with another experiment. It fails when there is a sample ratio mismatch.
+func runSimulate(args []string, env *cliEnv) int {
+	fs := newFlagSet("simulate", env)
+	var exp, other experimentFlags
+	exp.register(fs, "", "experiment")
+	other.register(fs, "other-", "other experiment to report the overlap with")
+	synthetic := fs.Int("synthetic", 0, "number of random user IDs to simulate instead of reading them from the file")
+	seed := fs.Int64("seed", 1, "random seed for the synthetic user IDs")
+	file, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if *synthetic > 0 && file != "" {
+		fmt.Fprintln(env.stderr, "-synthetic cannot be used with a user ID file")
+		return exitUsage
+	}
+
+	experiment, err := exp.experiment()
+	if err != nil {
+		return fail(env, err)
+	}
+	var otherExperiment *promoted.TwoArmExperiment
+	if other.cohortID != "" {
+		if otherExperiment, err = other.experiment(); err != nil {
+			return fail(env, fmt.Errorf("other experiment: %v", err))
+		}
+	}
+
+	var userIDs []string
+	if *synthetic > 0 {
+		userIDs = promoted.SyntheticUserIDs(*synthetic, *seed)
+	} else if userIDs, err = readUserIDs(file, env.stdin); err != nil {
+		return fail(env, err)
+	}
+
+	sim, err := promoted.SimulateExperiment(experiment, userIDs, otherExperiment)
+	if err != nil {
+		return fail(env, err)
+	}
+	printSimulation(env.stdout, sim)
+	if sim.SampleRatioMismatch() {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// readUserIDs reads one user ID per line from the file, or from stdin for "-" or "", skipping blank lines.
+func readUserIDs(file string, stdin io.Reader) ([]string, error) {
+	r := stdin
+	if file != "" && file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %v", displayName(file), err)
+		}
+		defer f.Close()
+		r = f
+	}
+	var userIDs []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if userID := strings.TrimSpace(scanner.Text()); userID != "" {
+			userIDs = append(userIDs, userID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", displayName(file), err)
+	}
+	return userIDs, nil
+}
+
+// printSimulation prints the arm counts, the sample ratio mismatch test and the overlap table.
+func printSimulation(w io.Writer, sim *promoted.ExperimentSimulation) {
+	fmt.Fprintf(w, "cohort %s: %d users\n", sim.CohortID, sim.Users)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ARM\tUSERS\tEXPECTED\tACTUAL %\tEXPECTED %")
+	for _, arm := range promoted.SimulatedArms {
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.2f%%\t%.2f%%\n", arm, sim.Counts[arm], sim.Expected(arm), 100*sim.Share(arm), 100*sim.ExpectedShares[arm])
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "chi-square %.3f, df %d, p-value %.4g\n", sim.ChiSquare, sim.DegreesOfFreedom, sim.PValue)
+	if sim.SampleRatioMismatch() {
+		fmt.Fprintf(w, "SAMPLE RATIO MISMATCH (p < %g)\n", promoted.SampleRatioMismatchPValue)
+	} else {
+		fmt.Fprintln(w, "no sample ratio mismatch")
+	}
+
+	if sim.Overlap == nil {
+		return
+	}
+	fmt.Fprintf(w, "\noverlap with cohort %s (rows %s, columns %s)\n", sim.Overlap.CohortID, sim.CohortID, sim.Overlap.CohortID)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "ARM")
+	for _, arm := range promoted.SimulatedArms {
+		fmt.Fprintf(tw, "\t%s", strings.ToUpper(arm.String()))
+	}
+	fmt.Fprintln(tw)
+	for _, arm := range promoted.SimulatedArms {
+		fmt.Fprint(tw, arm)
+		for _, otherArm := range promoted.SimulatedArms {
+			fmt.Fprintf(tw, "\t%d", sim.Overlap.Counts[arm][otherArm])
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "chi-square %.3f, df %d, p-value %.4g\n", sim.Overlap.ChiSquare, sim.Overlap.DegreesOfFreedom, sim.Overlap.PValue)
+	if sim.Overlap.Independent() {
+		fmt.Fprintln(w, "assignments are independent")
+	} else {
+		fmt.Fprintf(w, "ASSIGNMENTS ARE NOT INDEPENDENT (p < %g)\n", promoted.SampleRatioMismatchPValue)
+	}
+}
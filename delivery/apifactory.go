@@ -1,5 +1,7 @@
 package delivery
 
+import "net/http"
+
 // APIFactory is a factory interface for creating API clients.
 type APIFactory interface {
 	CreateSDKDelivery() DeliveryAPI
@@ -7,7 +9,7 @@ type APIFactory interface {
 	CreateMetricsAPI(endpoint, apiKey string, timeoutMillis int64) MetricsAPI
 }
 
-// DefaultAPIFactory is the default implementation of ApiFactory.
+// DefaultAPIFactory is the default implementation of ApiFactory. It is also an HTTPAPIFactory.
 type DefaultAPIFactory struct{}
 
 // CreateSDKDelivery creates an SDK delivery instance.
@@ -30,3 +32,20 @@ func (f *DefaultAPIFactory) CreateDeliveryAPI(
 func (f *DefaultAPIFactory) CreateMetricsAPI(endpoint, apiKey string, timeoutMillis int64) MetricsAPI {
 	return NewPromotedMetricsAPI(endpoint, apiKey, timeoutMillis)
 }
+
+// CreateDeliveryAPIWithHTTPClient creates an API delivery instance that sends requests with httpClient.
+func (f *DefaultAPIFactory) CreateDeliveryAPIWithHTTPClient(
+	httpClient *http.Client,
+	endpoint,
+	apiKey string,
+	timeoutMillis int64,
+	maxRequestInsertions int,
+	acceptGzip,
+	warmup bool) DeliveryAPI {
+	return NewPromotedDeliveryAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+}
+
+// CreateMetricsAPIWithHTTPClient creates an API metrics instance that sends requests with httpClient.
+func (f *DefaultAPIFactory) CreateMetricsAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64) MetricsAPI {
+	return NewPromotedMetricsAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis)
+}
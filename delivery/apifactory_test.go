@@ -12,3 +12,8 @@ func TestDefaultAPIFactory_IsAPIFactory(t *testing.T) {
 	apiFactory = &DefaultAPIFactory{}
 	assert.NotNil(t, apiFactory)
 }
+
+func TestDefaultAPIFactory_IsHTTPAPIFactory(t *testing.T) {
+	var apiFactory HTTPAPIFactory = &DefaultAPIFactory{}
+	assert.NotNil(t, apiFactory)
+}
@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"errors"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+)
+
+// RankedItem is a caller's item placed by delivery.
+type RankedItem[T any] struct {
+	// Item is the caller's item.
+	Item T
+
+	// ContentID is the content ID of the item.
+	ContentID string
+
+	// InsertionID is the ID of the response insertion for the item, needed to log impressions and actions.
+	InsertionID string
+
+	// Position is the zero-based position of the item in ALL insertions.
+	Position uint64
+}
+
+// AppliedResponse is the result of applying a DeliveryResponse to a caller's items.
+type AppliedResponse[T any] struct {
+	// Items are the caller's items in ranked order.
+	Items []RankedItem[T]
+
+	// Missing are the caller's items that are not in the response, in their original order. These are usually
+	// items outside of the requested page.
+	Missing []T
+
+	// UnknownContentIDs are response content IDs that do not match any of the caller's items, in response order.
+	// These are skipped in Items.
+	UnknownContentIDs []string
+
+	// Response is the delivery response that was applied.
+	Response *DeliveryResponse
+}
+
+// Ranked returns the caller's items in ranked order.
+func (r *AppliedResponse[T]) Ranked() []T {
+	ranked := make([]T, 0, len(r.Items))
+	for _, item := range r.Items {
+		ranked = append(ranked, item.Item)
+	}
+	return ranked
+}
+
+// ApplyResponse orders the caller's items by the response insertions, matching them by content ID. When several
+// items share a content ID they are matched to response insertions with that ID in their original order.
+func ApplyResponse[T any](items []T, contentID func(T) string, resp *DeliveryResponse) (*AppliedResponse[T], error) {
+	if resp == nil || resp.Response == nil {
+		return nil, errors.New("delivery response must be set")
+	}
+
+	byContentID := make(map[string][]int, len(items))
+	for i, item := range items {
+		id := contentID(item)
+		byContentID[id] = append(byContentID[id], i)
+	}
+
+	applied := &AppliedResponse[T]{
+		Items:    make([]RankedItem[T], 0, len(resp.Response.Insertion)),
+		Response: resp,
+	}
+	used := make([]bool, len(items))
+	for _, ins := range resp.Response.Insertion {
+		indexes := byContentID[ins.GetContentId()]
+		if len(indexes) == 0 {
+			applied.UnknownContentIDs = append(applied.UnknownContentIDs, ins.GetContentId())
+			continue
+		}
+		index := indexes[0]
+		byContentID[ins.GetContentId()] = indexes[1:]
+		used[index] = true
+		applied.Items = append(applied.Items, RankedItem[T]{
+			Item:        items[index],
+			ContentID:   ins.GetContentId(),
+			InsertionID: ins.GetInsertionId(),
+			Position:    ins.GetPosition(),
+		})
+	}
+	for i, item := range items {
+		if !used[i] {
+			applied.Missing = append(applied.Missing, item)
+		}
+	}
+	return applied, nil
+}
+
+// Deliver calls client.Deliver and applies the response to the caller's items. If the request has no insertions,
+// one is added for each item.
+func Deliver[T any](client *PromotedDeliveryClient, deliveryRequest *DeliveryRequest, items []T, contentID func(T) string) (*AppliedResponse[T], error) {
+	if deliveryRequest == nil || deliveryRequest.Request == nil {
+		return nil, errors.New("delivery request must be set")
+	}
+	if len(deliveryRequest.Request.Insertion) == 0 {
+		insertions := make([]*delivery.Insertion, 0, len(items))
+		for _, item := range items {
+			insertions = append(insertions, &delivery.Insertion{ContentId: contentID(item)})
+		}
+		deliveryRequest.Request.Insertion = insertions
+	}
+
+	resp, err := client.Deliver(deliveryRequest)
+	if err != nil {
+		return nil, err
+	}
+	return ApplyResponse(items, contentID, resp)
+}
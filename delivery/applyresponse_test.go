@@ -0,0 +1,97 @@
+package delivery
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func productContentID(p Product) string {
+	return strconv.Itoa(p.ID)
+}
+
+func TestApplyResponseOrdersItems(t *testing.T) {
+	products := []Product{{ID: 1}, {ID: 2}, {ID: 3}}
+	resp := &DeliveryResponse{Response: &delivery.Response{
+		Insertion: []*delivery.Insertion{
+			{ContentId: "3", InsertionId: "i3", Position: uint64Pointer(0)},
+			{ContentId: "1", InsertionId: "i1", Position: uint64Pointer(1)},
+			{ContentId: "2", InsertionId: "i2", Position: uint64Pointer(2)},
+		},
+	}}
+
+	applied, err := ApplyResponse(products, productContentID, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, []Product{{ID: 3}, {ID: 1}, {ID: 2}}, applied.Ranked())
+	assert.Equal(t, RankedItem[Product]{Item: Product{ID: 1}, ContentID: "1", InsertionID: "i1", Position: 1}, applied.Items[1])
+	assert.Empty(t, applied.Missing)
+	assert.Empty(t, applied.UnknownContentIDs)
+	assert.Equal(t, resp, applied.Response)
+}
+
+func TestApplyResponseReportsMissingAndUnknown(t *testing.T) {
+	products := []Product{{ID: 1}, {ID: 2}, {ID: 3}, {ID: 4}}
+	resp := &DeliveryResponse{Response: &delivery.Response{
+		Insertion: []*delivery.Insertion{
+			{ContentId: "4", Position: uint64Pointer(0)},
+			{ContentId: "9", Position: uint64Pointer(1)},
+			{ContentId: "2", Position: uint64Pointer(2)},
+		},
+	}}
+
+	applied, err := ApplyResponse(products, productContentID, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, []Product{{ID: 4}, {ID: 2}}, applied.Ranked())
+	assert.Equal(t, []Product{{ID: 1}, {ID: 3}}, applied.Missing)
+	assert.Equal(t, []string{"9"}, applied.UnknownContentIDs)
+}
+
+func TestApplyResponseMatchesDuplicateContentIDsInOrder(t *testing.T) {
+	products := []Product{{ID: 1, Name: "first"}, {ID: 1, Name: "second"}, {ID: 1, Name: "third"}}
+	resp := &DeliveryResponse{Response: &delivery.Response{
+		Insertion: []*delivery.Insertion{{ContentId: "1", InsertionId: "a"}, {ContentId: "1", InsertionId: "b"}},
+	}}
+
+	applied, err := ApplyResponse(products, productContentID, resp)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", applied.Items[0].Item.Name)
+	assert.Equal(t, "a", applied.Items[0].InsertionID)
+	assert.Equal(t, "second", applied.Items[1].Item.Name)
+	assert.Equal(t, []Product{{ID: 1, Name: "third"}}, applied.Missing)
+}
+
+func TestApplyResponseRequiresResponse(t *testing.T) {
+	_, err := ApplyResponse([]Product{{ID: 1}}, productContentID, nil)
+	assert.EqualError(t, err, "delivery response must be set")
+	_, err = ApplyResponse([]Product{{ID: 1}}, productContentID, &DeliveryResponse{})
+	assert.EqualError(t, err, "delivery response must be set")
+}
+
+func TestDeliverAddsInsertionsAndAppliesResponse(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	client, _ := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: mockApiDelivery, metricsAPI: new(MockMetrics)}).
+		Build()
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{
+		RequestId: "r",
+		Insertion: []*delivery.Insertion{
+			{ContentId: "2", InsertionId: "i2", Position: uint64Pointer(0)},
+			{ContentId: "1", InsertionId: "i1", Position: uint64Pointer(1)},
+		},
+	}, nil)
+
+	req := &DeliveryRequest{Request: &delivery.Request{UserInfo: &common.UserInfo{AnonUserId: "a"}}}
+	applied, err := Deliver(client, req, getProducts(), productContentID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(req.Request.Insertion))
+	assert.Equal(t, "1", req.Request.Insertion[0].ContentId)
+	assert.Equal(t, "2", req.Request.Insertion[1].ContentId)
+	assert.Equal(t, "Product 2", applied.Items[0].Item.Name)
+	assert.Equal(t, "i2", applied.Items[0].InsertionID)
+	assert.Equal(t, "Product 1", applied.Items[1].Item.Name)
+	assert.Equal(t, delivery.ExecutionServer_API, applied.Response.ExecutionServer)
+}
@@ -0,0 +1,177 @@
+package delivery_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+	"github.com/promotedai/promoted-go-delivery-client/promotedtest"
+)
+
+// Most benchmarks drive Deliver against the in-process fake server, so allocations include the server's work.
+// Run them with: go test -run '^$' -bench . ./delivery
+
+var benchmarkInsertions = []int{10, 100, 1000}
+
+func newBenchmarkClient(b *testing.B, s *promotedtest.Server) *promoted.PromotedDeliveryClient {
+	client, err := s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithAcceptsGzip(true).
+		WithDeliveryTimeoutMillis(1000).
+		Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return client
+}
+
+func BenchmarkDeliver(b *testing.B) {
+	for _, n := range benchmarkInsertions {
+		b.Run(fmt.Sprintf("insertions=%d", n), func(b *testing.B) {
+			s := promotedtest.NewServer(b).WithRecording(false)
+			client := newBenchmarkClient(b, s)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := promoted.NewDeliveryRequest(promotedtest.NewLoadRequest(i, n, 0), nil, false, 0, nil)
+				if _, err := client.Deliver(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkDeliverParallel(b *testing.B) {
+	for _, n := range benchmarkInsertions {
+		b.Run(fmt.Sprintf("insertions=%d", n), func(b *testing.B) {
+			s := promotedtest.NewServer(b).WithRecording(false)
+			client := newBenchmarkClient(b, s)
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for i := 0; pb.Next(); i++ {
+					req := promoted.NewDeliveryRequest(promotedtest.NewLoadRequest(i, n, 0), nil, false, 0, nil)
+					if _, err := client.Deliver(req); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// newCannedDeliveryServer returns a server that answers every Delivery API request with the same response of n
+// insertions, so the benchmark measures the client's encode and decode path rather than the fake server's.
+func newCannedDeliveryServer(b *testing.B, n int, gzipped bool) *httptest.Server {
+	resp := &delivery.Response{RequestId: "canned-request"}
+	for i := 0; i < n; i++ {
+		resp.Insertion = append(resp.Insertion, &delivery.Insertion{
+			ContentId:   "content-" + strconv.Itoa(i),
+			InsertionId: "canned-request-" + strconv.Itoa(i),
+			Position:    proto.Uint64(uint64(i)),
+		})
+	}
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if gzipped {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(body)
+		_ = zw.Close()
+		body = buf.Bytes()
+	}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		if gzipped {
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		_, _ = w.Write(body)
+	}))
+	b.Cleanup(s.Close)
+	return s
+}
+
+// BenchmarkRunDelivery measures one Delivery API call: encoding the request, sending it and decoding the response.
+func BenchmarkRunDelivery(b *testing.B) {
+	for _, gzipped := range []bool{false, true} {
+		for _, n := range benchmarkInsertions {
+			b.Run(fmt.Sprintf("gzip=%t/insertions=%d", gzipped, n), func(b *testing.B) {
+				s := newCannedDeliveryServer(b, n, gzipped)
+				api := promoted.NewPromotedDeliveryAPI(s.URL, "key", 1000, 1000, gzipped, false)
+				req := promoted.NewDeliveryRequest(promotedtest.NewLoadRequest(0, n, 0), nil, false, 0, nil)
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := api.RunDelivery(req); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkDeliverSDK measures SDK delivery and the Metrics API log, as for the control arm of an experiment.
+func BenchmarkDeliverSDK(b *testing.B) {
+	experiment := &event.CohortMembership{CohortId: "HOLD_OUT", Arm: event.CohortArm_CONTROL}
+	for _, n := range benchmarkInsertions {
+		b.Run(fmt.Sprintf("insertions=%d", n), func(b *testing.B) {
+			s := promotedtest.NewServer(b).WithRecording(false)
+			client := newBenchmarkClient(b, s)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := promoted.NewDeliveryRequest(promotedtest.NewLoadRequest(i, n, 0), experiment, false, 0, nil)
+				if _, err := client.Deliver(req); err != nil {
+					b.Fatal(err)
+				}
+			}
+			// Wait for the background logs so they do not outlive the server.
+			b.StopTimer()
+			if !s.WaitForRequestCount(promotedtest.MetricsPath, b.N, 5*time.Second) {
+				b.Fatalf("got %d of %d Metrics API logs", s.RequestCount(promotedtest.MetricsPath), b.N)
+			}
+		})
+	}
+}
+
+// BenchmarkDeliverLoad runs the load harness with b.N requests and reports its latency percentiles, fallback rate
+// and goroutine peak alongside the usual benchmark numbers.
+func BenchmarkDeliverLoad(b *testing.B) {
+	s := promotedtest.NewServer(b).WithRecording(false)
+	client := newBenchmarkClient(b, s)
+	b.ResetTimer()
+	report, err := promotedtest.RunLoad(context.Background(), client, s, promotedtest.LoadConfig{
+		Requests:    b.N,
+		Concurrency: 32,
+		Insertions:  1000,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(report.Latency.P50.Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(report.Latency.P99.Nanoseconds()), "p99-ns")
+	b.ReportMetric(report.FallbackRate(), "fallback-rate")
+	b.ReportMetric(float64(report.GoroutinesPeak), "peak-goroutines")
+	b.ReportMetric(report.AllocsPerRequest, "allocs/req")
+	b.ReportMetric(report.BytesPerRequest, "B/req")
+}
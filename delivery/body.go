@@ -0,0 +1,158 @@
+package delivery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxResponseBytes limits the size of a decompressed response body, so a misbehaving server can't exhaust memory.
+// A response with 1000 insertions is usually well under 1MB.
+const maxResponseBytes = 32 << 20
+
+// maxPooledBufferBytes keeps unusually large buffers out of the pool, so one huge request doesn't pin its memory.
+const maxPooledBufferBytes = 4 << 20
+
+// maxDrainBytes is how much of an unread response body is discarded before closing it, so the connection can be
+// reused. Connections with more left to read are closed instead.
+const maxDrainBytes = 64 << 10
+
+var (
+	buffers     = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	gzipReaders sync.Pool
+)
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferBytes {
+		return
+	}
+	buf.Reset()
+	buffers.Put(buf)
+}
+
+// drainAndClose discards up to maxDrainBytes of the response body and closes it, so the keep-alive connection
+// returns to the pool.
+func drainAndClose(body io.ReadCloser) {
+	_, _ = io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+// encodeJSON encodes v with encoding/json into a pooled buffer. The caller releases it.
+func encodeJSON(v any) (*sharedBuffer, error) {
+	body := newSharedBuffer()
+	if err := json.NewEncoder(body.buf).Encode(v); err != nil {
+		body.release()
+		return nil, err
+	}
+	return body, nil
+}
+
+// readProtoJSON reads the response body once into a pooled buffer, decompressing it if gzipped, and unmarshals it
+// into m. Bodies that are larger than limit once decompressed are rejected. The time spent unmarshaling is added
+// to stats, if set.
+func readProtoJSON(resp *http.Response, gzipped bool, limit int64, m proto.Message, stats *callStats) error {
+	buf := buffers.Get().(*bytes.Buffer)
+	defer putBuffer(buf)
+
+	var body io.Reader = resp.Body
+	if gzipped {
+		zr, err := getGzipReader(resp.Body)
+		if err != nil {
+			return fmt.Errorf("error creating gzip reader: %v", err)
+		}
+		defer gzipReaders.Put(zr)
+		body = zr
+	} else if resp.ContentLength > 0 && resp.ContentLength <= limit {
+		buf.Grow(int(resp.ContentLength))
+	}
+
+	n, err := buf.ReadFrom(io.LimitReader(body, limit+1))
+	if err != nil {
+		return fmt.Errorf("error reading response body: %w", err)
+	}
+	if n > limit {
+		return fmt.Errorf("response body is larger than %d bytes", limit)
+	}
+
+	// protojson copies the strings it decodes, so the buffer can be reused afterwards.
+	start := time.Now()
+	err = protojson.Unmarshal(buf.Bytes(), m)
+	if stats != nil {
+		stats.decoding.Add(int64(time.Since(start)))
+	}
+	if err != nil {
+		return fmt.Errorf("error unmarshaling JSON response: %v", err)
+	}
+	return nil
+}
+
+// getGzipReader returns a pooled gzip reader of r. Return it to gzipReaders when done.
+func getGzipReader(r io.Reader) (*gzip.Reader, error) {
+	if zr, ok := gzipReaders.Get().(*gzip.Reader); ok {
+		if err := zr.Reset(r); err != nil {
+			gzipReaders.Put(zr)
+			return nil, err
+		}
+		return zr, nil
+	}
+	return gzip.NewReader(r)
+}
+
+// sharedBuffer is a pooled buffer read by several readers. It goes back to the pool when the last reference is
+// released.
+type sharedBuffer struct {
+	buf  *bytes.Buffer
+	refs atomic.Int32
+}
+
+func newSharedBuffer() *sharedBuffer {
+	b := &sharedBuffer{buf: buffers.Get().(*bytes.Buffer)}
+	b.refs.Store(1)
+	return b
+}
+
+// reader returns a new reader of the buffer that holds a reference until it is closed.
+func (b *sharedBuffer) reader() io.ReadCloser {
+	b.refs.Add(1)
+	return &sharedBufferReader{Reader: bytes.NewReader(b.buf.Bytes()), buffer: b}
+}
+
+// setRequestBody makes the buffer the body of req, including when the transport sends it again with GetBody. Each
+// reader holds a reference until the transport closes it, so the caller can release its own once the response
+// has been read.
+func (b *sharedBuffer) setRequestBody(req *http.Request) {
+	req.ContentLength = int64(b.buf.Len())
+	req.Body = b.reader()
+	req.GetBody = func() (io.ReadCloser, error) {
+		return b.reader(), nil
+	}
+}
+
+func (b *sharedBuffer) release() {
+	if b.refs.Add(-1) == 0 {
+		putBuffer(b.buf)
+		b.buf = nil
+	}
+}
+
+type sharedBufferReader struct {
+	*bytes.Reader
+	buffer *sharedBuffer
+	closed atomic.Bool
+}
+
+func (r *sharedBufferReader) Close() error {
+	if r.closed.CompareAndSwap(false, true) {
+		r.buffer.release()
+	}
+	return nil
+}
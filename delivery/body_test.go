@@ -0,0 +1,85 @@
+package delivery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(data)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func newTestResponse(body []byte) *http.Response {
+	return &http.Response{Body: io.NopCloser(bytes.NewReader(body)), ContentLength: int64(len(body))}
+}
+
+func TestReadProtoJSON(t *testing.T) {
+	body := []byte(`{"requestId":"request-1","insertion":[{"contentId":"a","position":"0"}]}`)
+	for _, gzipped := range []bool{false, true} {
+		data := body
+		if gzipped {
+			data = gzipBytes(t, body)
+		}
+		// Run twice so the second read reuses the pooled buffer and gzip reader.
+		for i := 0; i < 2; i++ {
+			var resp delivery.Response
+			assert.NoError(t, readProtoJSON(newTestResponse(data), gzipped, maxResponseBytes, &resp, nil))
+			assert.Equal(t, "request-1", resp.RequestId)
+			assert.Equal(t, "a", resp.Insertion[0].ContentId)
+		}
+	}
+}
+
+func TestReadProtoJSONErrors(t *testing.T) {
+	var resp delivery.Response
+	err := readProtoJSON(newTestResponse([]byte("not gzip")), true, maxResponseBytes, &resp, nil)
+	assert.ErrorContains(t, err, "error creating gzip reader: ")
+
+	err = readProtoJSON(newTestResponse([]byte("{")), false, maxResponseBytes, &resp, nil)
+	assert.ErrorContains(t, err, "error unmarshaling JSON response: ")
+
+	// The limit applies to the decompressed size, so a small gzipped body can't expand without bound.
+	bomb := gzipBytes(t, bytes.Repeat([]byte(" "), 1<<20))
+	err = readProtoJSON(newTestResponse(bomb), true, 1<<20-1, &resp, nil)
+	assert.EqualError(t, err, "response body is larger than 1048575 bytes")
+	err = readProtoJSON(newTestResponse(bytes.Repeat([]byte(" "), 100)), false, 99, &resp, nil)
+	assert.EqualError(t, err, "response body is larger than 99 bytes")
+}
+
+func TestSharedBufferRelease(t *testing.T) {
+	body, err := encodeJSON(map[string]string{"key": "value"})
+	assert.NoError(t, err)
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	assert.NoError(t, err)
+	body.setRequestBody(req)
+	assert.Equal(t, int64(len(`{"key":"value"}`+"\n")), req.ContentLength)
+
+	retry, err := req.GetBody()
+	assert.NoError(t, err)
+	body.release()
+
+	// The readers keep the buffer until both are closed, even after the owner released it.
+	data, err := io.ReadAll(req.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"key":"value"}`, strings.TrimSpace(string(data)))
+	assert.NoError(t, req.Body.Close())
+	assert.NoError(t, req.Body.Close())
+	assert.NotNil(t, body.buf)
+	data, err = io.ReadAll(retry)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"key":"value"}`, strings.TrimSpace(string(data)))
+	assert.NoError(t, retry.Close())
+	assert.Nil(t, body.buf)
+}
@@ -0,0 +1,169 @@
+package delivery
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultCompressionMinBytes is the smallest request body that is compressed by default. Smaller bodies fit in a
+// few packets, so compressing them costs more CPU than it saves.
+const DefaultCompressionMinBytes = 1024
+
+// Compression is the Content-Encoding of request bodies.
+type Compression int
+
+const (
+	// CompressionNone sends request bodies uncompressed.
+	CompressionNone Compression = iota
+
+	// CompressionGzip compresses request bodies with gzip.
+	CompressionGzip
+
+	// CompressionZstd compresses request bodies with zstd, which is faster than gzip at a similar ratio. The
+	// server must accept zstd request bodies.
+	CompressionZstd
+)
+
+// String returns the Content-Encoding name, or "none".
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionGzip:
+		return "gzip"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", int(c))
+	}
+}
+
+// ParseCompression parses "none", "gzip" or "zstd", ignoring case.
+func ParseCompression(name string) (Compression, error) {
+	for _, c := range []Compression{CompressionNone, CompressionGzip, CompressionZstd} {
+		if strings.EqualFold(name, c.String()) {
+			return c, nil
+		}
+	}
+	return CompressionNone, fmt.Errorf("unknown compression %q, expected none, gzip or zstd", name)
+}
+
+// RequestCompression configures how request bodies sent to the Delivery and Metrics APIs are compressed.
+type RequestCompression struct {
+	// Encoding is the compression. The zero value, CompressionNone, turns compression off.
+	Encoding Compression
+
+	// MinBytes is the smallest body that is compressed. Defaults to DefaultCompressionMinBytes.
+	MinBytes int
+}
+
+// validate returns every problem with the settings.
+func (c RequestCompression) validate() []error {
+	var errs []error
+	if c.Encoding < CompressionNone || c.Encoding > CompressionZstd {
+		errs = append(errs, fmt.Errorf("unknown requestCompression encoding %d", c.Encoding))
+	}
+	if c.MinBytes < 0 {
+		errs = append(errs, errors.New("requestCompression MinBytes must not be negative"))
+	}
+	return errs
+}
+
+// withCompression returns a copy of httpClient that compresses request bodies, or httpClient itself when
+// compression is off.
+func withCompression(httpClient *http.Client, compression RequestCompression) *http.Client {
+	if compression.Encoding == CompressionNone {
+		return httpClient
+	}
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	minBytes := compression.MinBytes
+	if minBytes == 0 {
+		minBytes = DefaultCompressionMinBytes
+	}
+	compressing := *httpClient
+	compressing.Transport = &compressionTransport{base: base, encoding: compression.Encoding, minBytes: minBytes}
+	return &compressing
+}
+
+// compressionTransport compresses request bodies of at least minBytes.
+type compressionTransport struct {
+	base     http.RoundTripper
+	encoding Compression
+	minBytes int
+}
+
+// RoundTrip sends the request with a compressed copy of its body.
+func (t *compressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength < int64(t.minBytes) || req.Header.Get("Content-Encoding") != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	compressed, err := t.compress(req.Body)
+	closeRequestBody(req)
+	if err != nil {
+		return nil, fmt.Errorf("error compressing request body: %v", err)
+	}
+	// The buffer is returned to the pool once the transport has closed every reader of it and RoundTrip has
+	// returned. The transport can read the body again with GetBody when it retries on a new connection.
+	defer compressed.release()
+
+	out := req.Clone(req.Context())
+	out.Header.Set("Content-Encoding", t.encoding.String())
+	compressed.setRequestBody(out)
+	return t.base.RoundTrip(out)
+}
+
+// compress reads body into a pooled buffer with the encoding.
+func (t *compressionTransport) compress(body io.Reader) (*sharedBuffer, error) {
+	compressed := newSharedBuffer()
+	switch t.encoding {
+	case CompressionGzip:
+		zw := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(zw)
+		zw.Reset(compressed.buf)
+		if _, err := io.Copy(zw, body); err != nil {
+			compressed.release()
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			compressed.release()
+			return nil, err
+		}
+	case CompressionZstd:
+		src := buffers.Get().(*bytes.Buffer)
+		defer putBuffer(src)
+		if _, err := src.ReadFrom(body); err != nil {
+			compressed.release()
+			return nil, err
+		}
+		compressed.buf.Write(zstdEncoder().EncodeAll(src.Bytes(), compressed.buf.AvailableBuffer()))
+	}
+	return compressed, nil
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+)
+
+// zstdEncoder returns the shared zstd encoder. EncodeAll is safe for concurrent use, so one encoder is enough.
+func zstdEncoder() *zstd.Encoder {
+	zstdOnce.Do(func() {
+		// The options are valid, so NewWriter can't fail.
+		zstdEnc, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	})
+	return zstdEnc
+}
@@ -0,0 +1,193 @@
+package delivery
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// compressedRequest is a request seen by newDecompressingServer.
+type compressedRequest struct {
+	encoding string
+	size     int64
+	body     string
+}
+
+// newDecompressingServer returns a test server that decompresses and records every request body. It answers
+// 403 to requests without the key, unless key is empty.
+func newDecompressingServer(t *testing.T, key string) (*httptest.Server, func() []compressedRequest) {
+	var mu sync.Mutex
+	var seen []compressedRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case "gzip":
+			zr, err := gzip.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			body = zr
+		case "zstd":
+			zr, err := zstd.NewReader(r.Body)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		data, err := io.ReadAll(body)
+		assert.NoError(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+		seen = append(seen, compressedRequest{encoding: r.Header.Get("Content-Encoding"), size: r.ContentLength, body: string(data)})
+		if key != "" && r.Header.Get("x-api-key") != key {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []compressedRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]compressedRequest(nil), seen...)
+	}
+}
+
+// newTestLogRequest returns a log request whose JSON body is larger than DefaultCompressionMinBytes.
+func newTestLogRequest() *event.LogRequest {
+	req := &event.LogRequest{PlatformId: 7}
+	for i := 0; i < 50; i++ {
+		req.Impression = append(req.Impression, &event.Impression{ContentId: "content-" + strings.Repeat("x", 20)})
+	}
+	return req
+}
+
+func TestRequestCompression(t *testing.T) {
+	for _, encoding := range []Compression{CompressionGzip, CompressionZstd} {
+		t.Run(encoding.String(), func(t *testing.T) {
+			server, seen := newDecompressingServer(t, "")
+			client, err := newTestClientBuilder().
+				WithMetricsEndpoint(server.URL).
+				WithRequestCompression(RequestCompression{Encoding: encoding}).
+				Build()
+			assert.NoError(t, err)
+
+			assert.NoError(t, client.metricsAPI.RunMetricsLogging(newTestLogRequest()))
+			assert.NoError(t, client.metricsAPI.RunMetricsLogging(&event.LogRequest{PlatformId: 8}))
+
+			reqs := seen()
+			assert.Equal(t, 2, len(reqs))
+			assert.Equal(t, encoding.String(), reqs[0].encoding)
+			assert.Contains(t, reqs[0].body, `"platform_id":7`)
+			assert.Less(t, reqs[0].size, int64(len(reqs[0].body)))
+
+			// The second body is below DefaultCompressionMinBytes.
+			assert.Empty(t, reqs[1].encoding)
+			assert.Contains(t, reqs[1].body, `"platform_id":8`)
+			assert.Equal(t, int64(len(reqs[1].body)), reqs[1].size)
+		})
+	}
+}
+
+func TestRequestCompressionRetryResendsCompressedBody(t *testing.T) {
+	server, seen := newDecompressingServer(t, "new-key")
+	var mu sync.Mutex
+	keys := []string{"old-key", "new-key"}
+	credentials := CredentialsFunc(func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := keys[0]
+		if len(keys) > 1 {
+			keys = keys[1:]
+		}
+		return key, nil
+	})
+
+	client, err := newTestClientBuilder().
+		WithMetricsEndpoint(server.URL).
+		WithMetricsAPIKey("").
+		WithMetricsCredentials(credentials).
+		WithRequestCompression(RequestCompression{Encoding: CompressionGzip, MinBytes: 1}).
+		Build()
+	assert.NoError(t, err)
+	assert.NoError(t, client.metricsAPI.RunMetricsLogging(&event.LogRequest{PlatformId: 7}))
+
+	reqs := seen()
+	assert.Equal(t, 2, len(reqs))
+	for _, req := range reqs {
+		assert.Equal(t, "gzip", req.encoding)
+		assert.Contains(t, req.body, `"platform_id":7`)
+	}
+	assert.Equal(t, reqs[0], reqs[1])
+}
+
+func TestCompressionTransportGetBody(t *testing.T) {
+	var first, second []byte
+	transport := &compressionTransport{
+		base: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			first, _ = io.ReadAll(req.Body)
+			req.Body.Close()
+			body, err := req.GetBody()
+			assert.NoError(t, err)
+			second, _ = io.ReadAll(body)
+			body.Close()
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}),
+		encoding: CompressionZstd,
+		minBytes: 1,
+	}
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", strings.NewReader("compress me"))
+	assert.NoError(t, err)
+	_, err = transport.RoundTrip(req)
+	assert.NoError(t, err)
+	assert.Empty(t, req.Header.Get("Content-Encoding"))
+
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, second)
+	decoded, err := zstdDecode(first)
+	assert.NoError(t, err)
+	assert.Equal(t, "compress me", decoded)
+}
+
+func zstdDecode(data []byte) (string, error) {
+	zr, err := zstd.NewReader(nil)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+	decoded, err := zr.DecodeAll(data, nil)
+	return string(decoded), err
+}
+
+func TestParseCompression(t *testing.T) {
+	for name, want := range map[string]Compression{"none": CompressionNone, "GZIP": CompressionGzip, "zstd": CompressionZstd} {
+		got, err := ParseCompression(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseCompression("br")
+	assert.EqualError(t, err, `unknown compression "br", expected none, gzip or zstd`)
+	assert.Equal(t, "Compression(9)", Compression(9).String())
+}
+
+func TestBuildRequestCompressionErrors(t *testing.T) {
+	_, err := newTestClientBuilder().
+		WithRequestCompression(RequestCompression{Encoding: Compression(9), MinBytes: -1}).
+		Build()
+	assert.EqualError(t, err, "unknown requestCompression encoding 9\nrequestCompression MinBytes must not be negative")
+
+	_, err = newTestClientBuilder().
+		WithRequestCompression(RequestCompression{Encoding: CompressionGzip}).
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: new(MockDelivery), metricsAPI: new(MockMetrics)}).
+		Build()
+	assert.EqualError(t, err, "requestCompression requires an HTTPAPIFactory, got *delivery.TestApiFactory")
+}
@@ -0,0 +1,494 @@
+package delivery
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables read by ClientConfigFromEnv. Each one can instead be read from a file named by the same
+// variable with a _FILE suffix, such as PROMOTED_DELIVERY_API_KEY_FILE for secrets mounted as files.
+const (
+	EnvDeliveryEndpoint           = "PROMOTED_DELIVERY_ENDPOINT"
+	EnvDeliveryAPIKey             = "PROMOTED_DELIVERY_API_KEY"
+	EnvDeliveryTimeoutMillis      = "PROMOTED_DELIVERY_TIMEOUT_MILLIS"
+	EnvMetricsEndpoint            = "PROMOTED_METRICS_ENDPOINT"
+	EnvMetricsAPIKey              = "PROMOTED_METRICS_API_KEY"
+	EnvMetricsTimeoutMillis       = "PROMOTED_METRICS_TIMEOUT_MILLIS"
+	EnvMaxRequestInsertions       = "PROMOTED_MAX_REQUEST_INSERTIONS"
+	EnvShadowTrafficDeliveryRate  = "PROMOTED_SHADOW_TRAFFIC_DELIVERY_RATE"
+	EnvBlockingShadowTraffic      = "PROMOTED_BLOCKING_SHADOW_TRAFFIC"
+	EnvAcceptsGzip                = "PROMOTED_ACCEPTS_GZIP"
+	EnvWarmup                     = "PROMOTED_WARMUP"
+	EnvKeepAliveIntervalMillis    = "PROMOTED_KEEP_ALIVE_INTERVAL_MILLIS"
+	EnvDeliverTimeoutMillis       = "PROMOTED_DELIVER_TIMEOUT_MILLIS"
+	EnvFallbackReserveMillis      = "PROMOTED_FALLBACK_RESERVE_MILLIS"
+	EnvRequestCompression         = "PROMOTED_REQUEST_COMPRESSION"
+	EnvRequestCompressionMinBytes = "PROMOTED_REQUEST_COMPRESSION_MIN_BYTES"
+	EnvValidationMode             = "PROMOTED_VALIDATION_MODE"
+	EnvSDKOnly                    = "PROMOTED_SDK_ONLY"
+	EnvPlanLogging                = "PROMOTED_PLAN_LOGGING"
+)
+
+// envNames maps the config keys that check reports to their environment variables.
+var envNames = map[string]string{
+	"deliveryTimeoutMillis":      EnvDeliveryTimeoutMillis,
+	"metricsTimeoutMillis":       EnvMetricsTimeoutMillis,
+	"maxRequestInsertions":       EnvMaxRequestInsertions,
+	"shadowTrafficDeliveryRate":  EnvShadowTrafficDeliveryRate,
+	"validationMode":             EnvValidationMode,
+	"keepAliveIntervalMillis":    EnvKeepAliveIntervalMillis,
+	"deliverTimeoutMillis":       EnvDeliverTimeoutMillis,
+	"fallbackReserveMillis":      EnvFallbackReserveMillis,
+	"requestCompression":         EnvRequestCompression,
+	"requestCompressionMinBytes": EnvRequestCompressionMinBytes,
+}
+
+// fileEnvSuffix marks an environment variable holding the path of a file with the value.
+const fileEnvSuffix = "_FILE"
+
+// ClientConfig holds the PromotedDeliveryClientBuilder settings that can be read from the environment or a
+// config file. Unset fields keep the builder's defaults. The JSON and YAML keys match the field names in the
+// README's Client Configuration Parameters.
+type ClientConfig struct {
+	DeliveryEndpoint string `json:"deliveryEndpoint" yaml:"deliveryEndpoint"`
+	DeliveryAPIKey   string `json:"deliveryAPIKey" yaml:"deliveryAPIKey"`
+
+	// DeliveryAPIKeyFile is a file holding the Delivery API key. Relative paths in a config file are relative to
+	// the config file's directory.
+	DeliveryAPIKeyFile    string `json:"deliveryAPIKeyFile" yaml:"deliveryAPIKeyFile"`
+	DeliveryTimeoutMillis *int64 `json:"deliveryTimeoutMillis" yaml:"deliveryTimeoutMillis"`
+
+	MetricsEndpoint string `json:"metricsEndpoint" yaml:"metricsEndpoint"`
+	MetricsAPIKey   string `json:"metricsAPIKey" yaml:"metricsAPIKey"`
+
+	// MetricsAPIKeyFile is a file holding the Metrics API key, like DeliveryAPIKeyFile.
+	MetricsAPIKeyFile    string `json:"metricsAPIKeyFile" yaml:"metricsAPIKeyFile"`
+	MetricsTimeoutMillis *int64 `json:"metricsTimeoutMillis" yaml:"metricsTimeoutMillis"`
+
+	MaxRequestInsertions      *int     `json:"maxRequestInsertions" yaml:"maxRequestInsertions"`
+	ShadowTrafficDeliveryRate *float32 `json:"shadowTrafficDeliveryRate" yaml:"shadowTrafficDeliveryRate"`
+	BlockingShadowTraffic     *bool    `json:"blockingShadowTraffic" yaml:"blockingShadowTraffic"`
+	AcceptsGzip               *bool    `json:"acceptsGzip" yaml:"acceptsGzip"`
+	Warmup                    *bool    `json:"warmup" yaml:"warmup"`
+	KeepAliveIntervalMillis   *int64   `json:"keepAliveIntervalMillis" yaml:"keepAliveIntervalMillis"`
+	DeliverTimeoutMillis      *int64   `json:"deliverTimeoutMillis" yaml:"deliverTimeoutMillis"`
+	FallbackReserveMillis     *int64   `json:"fallbackReserveMillis" yaml:"fallbackReserveMillis"`
+
+	// RequestCompression is "none", "gzip" or "zstd".
+	RequestCompression         string `json:"requestCompression" yaml:"requestCompression"`
+	RequestCompressionMinBytes *int   `json:"requestCompressionMinBytes" yaml:"requestCompressionMinBytes"`
+
+	// ValidationMode is "off", "log" or "strict".
+	ValidationMode string `json:"validationMode" yaml:"validationMode"`
+
+	SDKOnly     *bool `json:"sdkOnly" yaml:"sdkOnly"`
+	PlanLogging *bool `json:"planLogging" yaml:"planLogging"`
+}
+
+// NewPromotedDeliveryClientBuilderFromEnv returns a builder configured from the PROMOTED_* environment variables.
+// Options that are not in the environment, such as the APIFactory, can still be set on the returned builder.
+func NewPromotedDeliveryClientBuilderFromEnv() (*PromotedDeliveryClientBuilder, error) {
+	config, err := ClientConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	b := NewPromotedDeliveryClientBuilder()
+	if err := config.Apply(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// NewPromotedDeliveryClientBuilderFromConfigFile returns a builder configured from a JSON or YAML config file.
+func NewPromotedDeliveryClientBuilderFromConfigFile(path string) (*PromotedDeliveryClientBuilder, error) {
+	config, err := LoadClientConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	b := NewPromotedDeliveryClientBuilder()
+	if err := config.Apply(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ClientConfigFromEnv reads a ClientConfig from the PROMOTED_* environment variables, returning every invalid
+// value in one error.
+func ClientConfigFromEnv() (*ClientConfig, error) {
+	return clientConfigFromEnv(os.LookupEnv)
+}
+
+func clientConfigFromEnv(lookup func(string) (string, bool)) (*ClientConfig, error) {
+	env := envReader{lookup: lookup}
+	deliveryAPIKey, deliveryAPIKeyFile := env.secret(EnvDeliveryAPIKey)
+	metricsAPIKey, metricsAPIKeyFile := env.secret(EnvMetricsAPIKey)
+	config := &ClientConfig{
+		DeliveryEndpoint:           env.string(EnvDeliveryEndpoint),
+		DeliveryAPIKey:             deliveryAPIKey,
+		DeliveryAPIKeyFile:         deliveryAPIKeyFile,
+		DeliveryTimeoutMillis:      env.int64(EnvDeliveryTimeoutMillis),
+		MetricsEndpoint:            env.string(EnvMetricsEndpoint),
+		MetricsAPIKey:              metricsAPIKey,
+		MetricsAPIKeyFile:          metricsAPIKeyFile,
+		MetricsTimeoutMillis:       env.int64(EnvMetricsTimeoutMillis),
+		MaxRequestInsertions:       env.int(EnvMaxRequestInsertions),
+		ShadowTrafficDeliveryRate:  env.float32(EnvShadowTrafficDeliveryRate),
+		BlockingShadowTraffic:      env.bool(EnvBlockingShadowTraffic),
+		AcceptsGzip:                env.bool(EnvAcceptsGzip),
+		Warmup:                     env.bool(EnvWarmup),
+		KeepAliveIntervalMillis:    env.int64(EnvKeepAliveIntervalMillis),
+		DeliverTimeoutMillis:       env.int64(EnvDeliverTimeoutMillis),
+		FallbackReserveMillis:      env.int64(EnvFallbackReserveMillis),
+		RequestCompression:         env.string(EnvRequestCompression),
+		RequestCompressionMinBytes: env.int(EnvRequestCompressionMinBytes),
+		ValidationMode:             env.string(EnvValidationMode),
+		SDKOnly:                    env.bool(EnvSDKOnly),
+		PlanLogging:                env.bool(EnvPlanLogging),
+	}
+	if err := errors.Join(env.errs...); err != nil {
+		return nil, err
+	}
+	if err := config.check(func(key string) string { return envNames[key] }); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadClientConfig reads a ClientConfig from a .json, .yaml or .yml file. Unknown keys are errors, so misspelled
+// settings are not silently ignored. Relative key file paths are resolved against the file's directory.
+func LoadClientConfig(path string) (*ClientConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %v", err)
+	}
+	config := &ClientConfig{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = decodeJSONConfig(data, config)
+	case ".yaml", ".yml":
+		err = decodeYAMLConfig(data, config)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config file extension %q, expected .json, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	for _, keyFile := range []*string{&config.DeliveryAPIKeyFile, &config.MetricsAPIKeyFile} {
+		if *keyFile != "" && !filepath.IsAbs(*keyFile) {
+			*keyFile = filepath.Join(dir, *keyFile)
+		}
+	}
+	if err := config.check(configKey); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Apply sets the configured values on the builder. API key files are read with FileCredentials, so rotated keys
+// are picked up without rebuilding the client.
+func (c *ClientConfig) Apply(b *PromotedDeliveryClientBuilder) error {
+	if err := c.check(configKey); err != nil {
+		return err
+	}
+	deliveryCredentials, err := fileCredentials(c.DeliveryAPIKeyFile, "deliveryAPIKeyFile")
+	if err != nil {
+		return err
+	}
+	metricsCredentials, err := fileCredentials(c.MetricsAPIKeyFile, "metricsAPIKeyFile")
+	if err != nil {
+		return err
+	}
+
+	if c.DeliveryEndpoint != "" {
+		b.WithDeliveryEndpoint(c.DeliveryEndpoint)
+	}
+	if c.DeliveryAPIKey != "" {
+		b.WithDeliveryAPIKey(c.DeliveryAPIKey)
+	}
+	if deliveryCredentials != nil {
+		b.WithDeliveryCredentials(deliveryCredentials)
+	}
+	if c.DeliveryTimeoutMillis != nil {
+		b.WithDeliveryTimeoutMillis(*c.DeliveryTimeoutMillis)
+	}
+	if c.MetricsEndpoint != "" {
+		b.WithMetricsEndpoint(c.MetricsEndpoint)
+	}
+	if c.MetricsAPIKey != "" {
+		b.WithMetricsAPIKey(c.MetricsAPIKey)
+	}
+	if metricsCredentials != nil {
+		b.WithMetricsCredentials(metricsCredentials)
+	}
+	if c.MetricsTimeoutMillis != nil {
+		b.WithMetricsTimeoutMillis(*c.MetricsTimeoutMillis)
+	}
+	if c.MaxRequestInsertions != nil {
+		b.WithMaxRequestInsertions(*c.MaxRequestInsertions)
+	}
+	if c.ShadowTrafficDeliveryRate != nil {
+		b.WithShadowTrafficDeliveryRate(*c.ShadowTrafficDeliveryRate)
+	}
+	if c.BlockingShadowTraffic != nil {
+		b.WithBlockingShadowTraffic(*c.BlockingShadowTraffic)
+	}
+	if c.AcceptsGzip != nil {
+		b.WithAcceptsGzip(*c.AcceptsGzip)
+	}
+	if c.Warmup != nil {
+		b.WithWarmup(*c.Warmup)
+	}
+	if c.KeepAliveIntervalMillis != nil {
+		b.WithKeepAliveInterval(time.Duration(*c.KeepAliveIntervalMillis) * time.Millisecond)
+	}
+	if c.DeliverTimeoutMillis != nil {
+		b.WithDeliverTimeout(time.Duration(*c.DeliverTimeoutMillis) * time.Millisecond)
+	}
+	if c.FallbackReserveMillis != nil {
+		b.WithFallbackReserve(time.Duration(*c.FallbackReserveMillis) * time.Millisecond)
+	}
+	if c.RequestCompression != "" || c.RequestCompressionMinBytes != nil {
+		compression := b.requestCompression
+		if c.RequestCompression != "" {
+			compression.Encoding, _ = ParseCompression(c.RequestCompression)
+		}
+		if c.RequestCompressionMinBytes != nil {
+			compression.MinBytes = *c.RequestCompressionMinBytes
+		}
+		b.WithRequestCompression(compression)
+	}
+	if c.ValidationMode != "" {
+		mode, _ := ParseValidationMode(c.ValidationMode)
+		b.WithValidationMode(mode)
+	}
+	if c.SDKOnly != nil {
+		b.WithSDKOnly(*c.SDKOnly)
+	}
+	if c.PlanLogging != nil {
+		b.WithPlanLogging(*c.PlanLogging)
+	}
+	return nil
+}
+
+// check returns every value that Build would reject or that cannot be parsed, as one error. name converts config
+// keys to the names the caller set them by.
+func (c *ClientConfig) check(name func(key string) string) error {
+	var errs []error
+	if c.DeliveryAPIKey != "" && c.DeliveryAPIKeyFile != "" {
+		errs = append(errs, fmt.Errorf("%s and %s must not both be set", name("deliveryAPIKey"), name("deliveryAPIKeyFile")))
+	}
+	if c.MetricsAPIKey != "" && c.MetricsAPIKeyFile != "" {
+		errs = append(errs, fmt.Errorf("%s and %s must not both be set", name("metricsAPIKey"), name("metricsAPIKeyFile")))
+	}
+	if c.DeliveryTimeoutMillis != nil && *c.DeliveryTimeoutMillis <= 0 {
+		errs = append(errs, fmt.Errorf("%s must be positive, got %d", name("deliveryTimeoutMillis"), *c.DeliveryTimeoutMillis))
+	}
+	if c.MetricsTimeoutMillis != nil && *c.MetricsTimeoutMillis <= 0 {
+		errs = append(errs, fmt.Errorf("%s must be positive, got %d", name("metricsTimeoutMillis"), *c.MetricsTimeoutMillis))
+	}
+	if c.MaxRequestInsertions != nil && *c.MaxRequestInsertions <= 0 {
+		errs = append(errs, fmt.Errorf("%s must be positive, got %d", name("maxRequestInsertions"), *c.MaxRequestInsertions))
+	}
+	if c.ShadowTrafficDeliveryRate != nil && (*c.ShadowTrafficDeliveryRate < 0 || *c.ShadowTrafficDeliveryRate > 1) {
+		errs = append(errs, fmt.Errorf("%s must be between 0 and 1, got %g", name("shadowTrafficDeliveryRate"), *c.ShadowTrafficDeliveryRate))
+	}
+	if c.KeepAliveIntervalMillis != nil && *c.KeepAliveIntervalMillis < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative, got %d", name("keepAliveIntervalMillis"), *c.KeepAliveIntervalMillis))
+	}
+	if c.DeliverTimeoutMillis != nil && *c.DeliverTimeoutMillis < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative, got %d", name("deliverTimeoutMillis"), *c.DeliverTimeoutMillis))
+	}
+	if c.FallbackReserveMillis != nil && *c.FallbackReserveMillis < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative, got %d", name("fallbackReserveMillis"), *c.FallbackReserveMillis))
+	}
+	if c.RequestCompression != "" {
+		if _, err := ParseCompression(c.RequestCompression); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name("requestCompression"), err))
+		}
+	}
+	if c.RequestCompressionMinBytes != nil && *c.RequestCompressionMinBytes < 0 {
+		errs = append(errs, fmt.Errorf("%s must not be negative, got %d", name("requestCompressionMinBytes"), *c.RequestCompressionMinBytes))
+	}
+	if c.ValidationMode != "" {
+		if _, err := ParseValidationMode(c.ValidationMode); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", name("validationMode"), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// configKey names values by their config file key.
+func configKey(key string) string {
+	return key
+}
+
+// fileCredentials returns FileCredentials for the key file, or nil if there is none.
+func fileCredentials(file, name string) (CredentialsProvider, error) {
+	if file == "" {
+		return nil, nil
+	}
+	credentials, err := NewFileCredentials(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return credentials, nil
+}
+
+// decodeJSONConfig decodes JSON, reporting the position of syntax and type errors.
+func decodeJSONConfig(data []byte, config *ClientConfig) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(config)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		if decoder.More() {
+			return errors.New("unexpected data after the config object")
+		}
+		return nil
+	case errors.As(err, &syntaxErr):
+		// The offset is just past the invalid character.
+		line, column := lineColumn(data, syntaxErr.Offset-1)
+		return fmt.Errorf("line %d, column %d: %v", line, column, syntaxErr)
+	case errors.As(err, &typeErr):
+		// The offset is near the value depending on the Go version, so only the line is reported.
+		line, _ := lineColumn(data, typeErr.Offset-1)
+		return fmt.Errorf("line %d: %s must be %s, got %s", line, typeErr.Field, typeErr.Type, typeErr.Value)
+	default:
+		return err
+	}
+}
+
+// decodeYAMLConfig decodes YAML. The YAML decoder's errors already include line numbers.
+func decodeYAMLConfig(data []byte, config *ClientConfig) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// lineColumn converts a byte offset into a 1-based line and column.
+func lineColumn(data []byte, offset int64) (int, int) {
+	offset = min(max(offset, 0), int64(len(data)))
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// envReader reads typed environment variables, collecting parse errors.
+type envReader struct {
+	lookup func(string) (string, bool)
+	errs   []error
+}
+
+// value returns the variable, or the trimmed contents of the file named by its _FILE variable.
+func (e *envReader) value(name string) (string, bool) {
+	value, ok := e.lookup(name)
+	file, fileOK := e.lookup(name + fileEnvSuffix)
+	switch {
+	case ok && fileOK:
+		e.errs = append(e.errs, fmt.Errorf("%s and %s must not both be set", name, name+fileEnvSuffix))
+		return "", false
+	case fileOK:
+		data, err := os.ReadFile(file)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("%s: %v", name+fileEnvSuffix, err))
+			return "", false
+		}
+		return strings.TrimSpace(string(data)), true
+	case ok:
+		return strings.TrimSpace(value), value != ""
+	default:
+		return "", false
+	}
+}
+
+// secret returns the variable, or the path in its _FILE variable without reading it, so the key can be read again
+// when the file changes. It is an error if the file can't be opened.
+func (e *envReader) secret(name string) (string, string) {
+	file, ok := e.lookup(name + fileEnvSuffix)
+	if !ok {
+		return e.string(name), ""
+	}
+	if _, ok := e.lookup(name); ok {
+		e.errs = append(e.errs, fmt.Errorf("%s and %s must not both be set", name, name+fileEnvSuffix))
+		return "", ""
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: %v", name+fileEnvSuffix, err))
+		return "", ""
+	}
+	f.Close()
+	return "", file
+}
+
+func (e *envReader) string(name string) string {
+	value, _ := e.value(name)
+	return value
+}
+
+func (e *envReader) int64(name string) *int64 {
+	value, ok := e.value(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid integer %q", name, value))
+		return nil
+	}
+	return &n
+}
+
+func (e *envReader) int(name string) *int {
+	n := e.int64(name)
+	if n == nil {
+		return nil
+	}
+	i := int(*n)
+	return &i
+}
+
+func (e *envReader) float32(name string) *float32 {
+	value, ok := e.value(name)
+	if !ok {
+		return nil
+	}
+	f, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid number %q", name, value))
+		return nil
+	}
+	f32 := float32(f)
+	return &f32
+}
+
+func (e *envReader) bool(name string) *bool {
+	value, ok := e.value(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%s: invalid boolean %q", name, value))
+		return nil
+	}
+	return &b
+}
@@ -0,0 +1,226 @@
+package delivery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigTestFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func testLookup(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+// testAPIKey returns the key from credentials.
+func testAPIKey(t *testing.T, credentials CredentialsProvider) string {
+	if !assert.NotNil(t, credentials) {
+		return ""
+	}
+	key, err := credentials.APIKey(context.Background())
+	assert.NoError(t, err)
+	return key
+}
+
+func TestClientConfigFromEnv(t *testing.T) {
+	keyFile := writeConfigTestFile(t, t.TempDir(), "metrics-key", "metrics-secret\n")
+	config, err := clientConfigFromEnv(testLookup(map[string]string{
+		EnvDeliveryEndpoint:              "https://delivery.example.com/deliver",
+		EnvDeliveryAPIKey:                "delivery-secret",
+		EnvDeliveryTimeoutMillis:         "300",
+		EnvMetricsEndpoint:               "https://metrics.example.com/log",
+		EnvMetricsAPIKey + fileEnvSuffix: keyFile,
+		EnvMetricsTimeoutMillis:          "4000",
+		EnvMaxRequestInsertions:          "500",
+		EnvShadowTrafficDeliveryRate:     "0.25",
+		EnvBlockingShadowTraffic:         "true",
+		EnvAcceptsGzip:                   "1",
+		EnvWarmup:                        "false",
+		EnvKeepAliveIntervalMillis:       "30000",
+		EnvDeliverTimeoutMillis:          "200",
+		EnvFallbackReserveMillis:         "15",
+		EnvRequestCompression:            "zstd",
+		EnvValidationMode:                "Strict",
+		EnvSDKOnly:                       "false",
+		EnvPlanLogging:                   "true",
+	}))
+	assert.NoError(t, err)
+
+	b := NewPromotedDeliveryClientBuilder()
+	assert.NoError(t, config.Apply(b))
+	assert.Equal(t, "https://delivery.example.com/deliver", b.deliveryEndpoint)
+	assert.Equal(t, "delivery-secret", b.deliveryAPIKey)
+	assert.Equal(t, int64(300), b.deliveryTimeoutMillis)
+	assert.Equal(t, "https://metrics.example.com/log", b.metricsEndpoint)
+	assert.Empty(t, b.metricsAPIKey)
+	assert.Equal(t, "metrics-secret", testAPIKey(t, b.metricsCredentials))
+	assert.Equal(t, int64(4000), b.metricsTimeoutMillis)
+	assert.Equal(t, 500, b.maxRequestInsertions)
+	assert.Equal(t, float32(0.25), b.shadowTrafficDeliveryRate)
+	assert.True(t, b.blockingShadowTraffic)
+	assert.True(t, b.acceptsGzip)
+	assert.False(t, b.warmup)
+	assert.Equal(t, 30*time.Second, b.keepAliveInterval)
+	assert.Equal(t, 200*time.Millisecond, b.deliverTimeout)
+	assert.Equal(t, 15*time.Millisecond, b.fallbackReserve)
+	assert.Equal(t, RequestCompression{Encoding: CompressionZstd}, b.requestCompression)
+	assert.Equal(t, ValidationModeStrict, b.validationMode)
+	assert.False(t, b.sdkOnly)
+	assert.True(t, b.planLogging)
+}
+
+func TestClientConfigFromEnvKeepsDefaults(t *testing.T) {
+	config, err := clientConfigFromEnv(testLookup(map[string]string{EnvDeliveryEndpoint: "https://delivery.example.com"}))
+	assert.NoError(t, err)
+
+	b := NewPromotedDeliveryClientBuilder()
+	assert.NoError(t, config.Apply(b))
+	assert.Equal(t, int64(defaultDeliveryTimeoutMillis), b.deliveryTimeoutMillis)
+	assert.Equal(t, int64(defaultMetricsTimeoutMillis), b.metricsTimeoutMillis)
+	assert.Equal(t, defaultMaxRequestInsertions, b.maxRequestInsertions)
+	assert.Equal(t, ValidationModeOff, b.validationMode)
+	assert.Equal(t, DefaultFallbackReserve, b.fallbackReserve)
+}
+
+func TestClientConfigFromEnvReportsEveryError(t *testing.T) {
+	_, err := clientConfigFromEnv(testLookup(map[string]string{
+		EnvDeliveryTimeoutMillis:          "fast",
+		EnvAcceptsGzip:                    "yes please",
+		EnvDeliveryAPIKey:                 "a",
+		EnvDeliveryAPIKey + fileEnvSuffix: "/run/secrets/key",
+		EnvMetricsAPIKey + fileEnvSuffix:  "/does/not/exist",
+		EnvShadowTrafficDeliveryRate:      "lots",
+	}))
+	assert.ErrorContains(t, err, `PROMOTED_DELIVERY_TIMEOUT_MILLIS: invalid integer "fast"`)
+	assert.ErrorContains(t, err, `PROMOTED_ACCEPTS_GZIP: invalid boolean "yes please"`)
+	assert.ErrorContains(t, err, "PROMOTED_DELIVERY_API_KEY and PROMOTED_DELIVERY_API_KEY_FILE must not both be set")
+	assert.ErrorContains(t, err, "PROMOTED_METRICS_API_KEY_FILE: open /does/not/exist")
+	assert.ErrorContains(t, err, `PROMOTED_SHADOW_TRAFFIC_DELIVERY_RATE: invalid number "lots"`)
+
+	_, err = clientConfigFromEnv(testLookup(map[string]string{
+		EnvShadowTrafficDeliveryRate:  "2",
+		EnvMetricsTimeoutMillis:       "0",
+		EnvValidationMode:             "loud",
+		EnvKeepAliveIntervalMillis:    "-1",
+		EnvRequestCompression:         "br",
+		EnvRequestCompressionMinBytes: "-5",
+		EnvFallbackReserveMillis:      "-1",
+	}))
+	assert.ErrorContains(t, err, "PROMOTED_SHADOW_TRAFFIC_DELIVERY_RATE must be between 0 and 1, got 2")
+	assert.ErrorContains(t, err, "PROMOTED_METRICS_TIMEOUT_MILLIS must be positive, got 0")
+	assert.ErrorContains(t, err, "PROMOTED_KEEP_ALIVE_INTERVAL_MILLIS must not be negative, got -1")
+	assert.ErrorContains(t, err, `PROMOTED_REQUEST_COMPRESSION: unknown compression "br", expected none, gzip or zstd`)
+	assert.ErrorContains(t, err, "PROMOTED_REQUEST_COMPRESSION_MIN_BYTES must not be negative, got -5")
+	assert.ErrorContains(t, err, "PROMOTED_FALLBACK_RESERVE_MILLIS must not be negative, got -1")
+	assert.ErrorContains(t, err, `PROMOTED_VALIDATION_MODE: unknown validation mode "loud", expected off, log or strict`)
+}
+
+func TestLoadClientConfigJSON(t *testing.T) {
+	dir := t.TempDir()
+	writeConfigTestFile(t, dir, "delivery-key", "delivery-secret\n")
+	path := writeConfigTestFile(t, dir, "promoted.json", `{
+  "deliveryEndpoint": "https://delivery.example.com",
+  "deliveryAPIKeyFile": "delivery-key",
+  "deliveryTimeoutMillis": 200,
+  "metricsEndpoint": "https://metrics.example.com",
+  "metricsAPIKey": "metrics-secret",
+  "acceptsGzip": true,
+  "warmup": true,
+  "validationMode": "log"
+}`)
+
+	b, err := NewPromotedDeliveryClientBuilderFromConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://delivery.example.com", b.deliveryEndpoint)
+	assert.Empty(t, b.deliveryAPIKey)
+	assert.Equal(t, "delivery-secret", testAPIKey(t, b.deliveryCredentials))
+	assert.Equal(t, int64(200), b.deliveryTimeoutMillis)
+	assert.Equal(t, "metrics-secret", b.metricsAPIKey)
+	assert.True(t, b.acceptsGzip)
+	assert.True(t, b.warmup)
+	assert.Equal(t, ValidationModeLog, b.validationMode)
+	assert.Equal(t, defaultMaxRequestInsertions, b.maxRequestInsertions)
+}
+
+func TestLoadClientConfigYAML(t *testing.T) {
+	path := writeConfigTestFile(t, t.TempDir(), "promoted.yaml", `
+deliveryEndpoint: https://delivery.example.com
+deliveryAPIKey: delivery-secret
+maxRequestInsertions: 250
+shadowTrafficDeliveryRate: 0.1
+blockingShadowTraffic: true
+requestCompressionMinBytes: 4096
+sdkOnly: true
+planLogging: true
+`)
+	b, err := NewPromotedDeliveryClientBuilderFromConfigFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "delivery-secret", b.deliveryAPIKey)
+	assert.Equal(t, 250, b.maxRequestInsertions)
+	assert.Equal(t, float32(0.1), b.shadowTrafficDeliveryRate)
+	assert.True(t, b.blockingShadowTraffic)
+	assert.Equal(t, RequestCompression{MinBytes: 4096}, b.requestCompression)
+	assert.True(t, b.sdkOnly)
+	assert.True(t, b.planLogging)
+
+	empty := writeConfigTestFile(t, t.TempDir(), "empty.yml", "")
+	_, err = LoadClientConfig(empty)
+	assert.NoError(t, err)
+}
+
+func TestLoadClientConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name     string
+		file     string
+		content  string
+		expected string
+	}{
+		{"unknown json key", "a.json", `{"deliveryEndpoint": "x", "retries": 3}`, `json: unknown field "retries"`},
+		{"json type", "b.json", "{\n  \"deliveryTimeoutMillis\": \"fast\"\n}", `line 2: deliveryTimeoutMillis must be int64, got string`},
+		{"json syntax", "c.json", "{\n  \"warmup\": tru\n}", "line 2, column 16: invalid character"},
+		{"json trailing data", "d.json", `{} {}`, "unexpected data after the config object"},
+		{"unknown yaml key", "e.yaml", "deliveryEndpoint: x\nretries: 3\n", "line 2: field retries not found"},
+		{"yaml type", "f.yaml", "warmup: sometimes\n", "line 1: cannot unmarshal !!str `sometimes` into bool"},
+		{"invalid values", "g.json", `{"maxRequestInsertions": -1, "validationMode": "loud"}`, "maxRequestInsertions must be positive, got -1"},
+		{"key and key file", "h.yaml", "metricsAPIKey: a\nmetricsAPIKeyFile: b\n", "metricsAPIKey and metricsAPIKeyFile must not both be set"},
+		{"extension", "i.toml", "", `unsupported config file extension ".toml"`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeConfigTestFile(t, dir, tc.file, tc.content)
+			_, err := LoadClientConfig(path)
+			assert.ErrorContains(t, err, path+": ")
+			assert.ErrorContains(t, err, tc.expected)
+		})
+	}
+
+	_, err := LoadClientConfig(filepath.Join(dir, "missing.json"))
+	assert.ErrorContains(t, err, "error reading config file")
+
+	path := writeConfigTestFile(t, dir, "j.json", `{"deliveryAPIKeyFile": "missing-key"}`)
+	_, err = NewPromotedDeliveryClientBuilderFromConfigFile(path)
+	assert.ErrorContains(t, err, "deliveryAPIKeyFile: error reading API key file: stat "+filepath.Join(dir, "missing-key"))
+}
+
+func TestParseValidationMode(t *testing.T) {
+	for _, mode := range []ValidationMode{ValidationModeOff, ValidationModeLog, ValidationModeStrict} {
+		parsed, err := ParseValidationMode(mode.String())
+		assert.NoError(t, err)
+		assert.Equal(t, mode, parsed)
+	}
+	_, err := ParseValidationMode("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,189 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fileCredentialsCheckInterval is how often FileCredentials checks its file for a new key.
+const fileCredentialsCheckInterval = time.Second
+
+// CredentialsProvider supplies the API key for each request, so keys can be rotated without restarting. When the
+// server rejects a key with 401 or 403, Refresh is called and the request is retried once if the key changed.
+// Implementations must be safe for concurrent use, and their errors must not include keys.
+type CredentialsProvider interface {
+	// APIKey returns the key to send in the x-api-key header.
+	APIKey(ctx context.Context) (string, error)
+
+	// Refresh fetches the latest key after the current one was rejected.
+	Refresh(ctx context.Context) error
+}
+
+// StaticCredentials is a CredentialsProvider with a fixed key.
+type StaticCredentials string
+
+// APIKey returns the key.
+func (c StaticCredentials) APIKey(ctx context.Context) (string, error) {
+	return string(c), nil
+}
+
+// Refresh does nothing, since the key never changes.
+func (c StaticCredentials) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// CredentialsFunc is a CredentialsProvider that calls the function for each request, for example to read the key
+// from a secret manager's cache.
+type CredentialsFunc func(ctx context.Context) (string, error)
+
+// APIKey calls the function.
+func (f CredentialsFunc) APIKey(ctx context.Context) (string, error) {
+	return f(ctx)
+}
+
+// Refresh does nothing. A retried request calls the function again.
+func (f CredentialsFunc) Refresh(ctx context.Context) error {
+	return nil
+}
+
+// FileCredentials is a CredentialsProvider that reads the key from a file, such as a mounted Kubernetes secret.
+// The file is checked for changes at most once a second, and again whenever a key is rejected.
+type FileCredentials struct {
+	path string
+
+	mu      sync.Mutex
+	key     string
+	version fileVersion
+	checked time.Time
+}
+
+// NewFileCredentials reads the key from path, returning an error if it can't be read or is empty.
+func NewFileCredentials(path string) (*FileCredentials, error) {
+	c := &FileCredentials{path: path}
+	if err := c.reload(true); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// APIKey returns the key, reading the file again if it changed. If the new contents can't be used, the previous
+// key is kept and the error is logged.
+func (c *FileCredentials) APIKey(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.checked) >= fileCredentialsCheckInterval {
+		if err := c.reload(false); err != nil {
+			log.Printf("Error reloading API key, using the previous one: %v\n", err)
+		}
+	}
+	return c.key, nil
+}
+
+// Refresh reads the file again.
+func (c *FileCredentials) Refresh(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.reload(true)
+}
+
+// reload reads the key if the file changed, or always when force is set. c.mu must be held, except from
+// NewFileCredentials.
+func (c *FileCredentials) reload(force bool) error {
+	c.checked = time.Now()
+	version, err := statFile(c.path)
+	if err != nil {
+		return fmt.Errorf("error reading API key file: %v", err)
+	}
+	if !force && version == c.version {
+		return nil
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return fmt.Errorf("error reading API key file: %v", err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return fmt.Errorf("API key file %s is empty", c.path)
+	}
+	c.key = key
+	c.version = version
+	return nil
+}
+
+// credentialsTransport sets the x-api-key header from a CredentialsProvider and retries once with a refreshed
+// key when the server responds with 401 or 403.
+type credentialsTransport struct {
+	base        http.RoundTripper
+	credentials CredentialsProvider
+}
+
+// withCredentials returns a copy of httpClient that authenticates requests with credentials.
+func withCredentials(httpClient *http.Client, credentials CredentialsProvider) *http.Client {
+	base := httpClient.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	authenticated := *httpClient
+	authenticated.Transport = &credentialsTransport{base: base, credentials: credentials}
+	return &authenticated
+}
+
+// RoundTrip sends the request with the current key.
+func (t *credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	key, err := t.credentials.APIKey(ctx)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("error getting API key: %w", err)
+	}
+	resp, err := t.base.RoundTrip(withAPIKey(req, key))
+	if err != nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden) {
+		return resp, err
+	}
+	if req.Body != nil && req.GetBody == nil {
+		// The body was consumed and can't be sent again.
+		return resp, nil
+	}
+
+	if err := t.credentials.Refresh(ctx); err != nil {
+		log.Printf("Error refreshing API key after statusCode=%d: %v\n", resp.StatusCode, err)
+		return resp, nil
+	}
+	newKey, err := t.credentials.APIKey(ctx)
+	if err != nil || newKey == key {
+		return resp, nil
+	}
+
+	retry := withAPIKey(req, newKey)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	drainAndClose(resp.Body)
+	if stats := callStatsFromContext(ctx); stats != nil {
+		stats.retries.Add(1)
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// withAPIKey returns a copy of req with the x-api-key header set, since a RoundTripper must not modify its request.
+func withAPIKey(req *http.Request, key string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-api-key", key)
+	return req
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
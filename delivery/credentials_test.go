@@ -0,0 +1,212 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyServer is a test server that accepts requests with its current key and records every key and body it saw.
+type keyServer struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	key    string
+	keys   []string
+	bodies []string
+}
+
+func newKeyServer(t *testing.T, key string) *keyServer {
+	s := &keyServer{key: key}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.keys = append(s.keys, r.Header.Get("x-api-key"))
+		s.bodies = append(s.bodies, string(body))
+		if r.Header.Get("x-api-key") != s.key {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *keyServer) seen() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.keys...), append([]string(nil), s.bodies...)
+}
+
+// captureLog sends the standard logger's output to a buffer for the rest of the test.
+func captureLog(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return &buf
+}
+
+func writeKeyFile(t *testing.T, path, key string, modTime time.Time) {
+	assert.NoError(t, os.WriteFile(path, []byte(key+"\n"), 0o600))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestStaticCredentialsDoNotRetry(t *testing.T) {
+	server := newKeyServer(t, "right-key")
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryAPIKey("").
+		WithDeliveryCredentials(StaticCredentials("wrong-key")).
+		Build()
+	assert.NoError(t, err)
+
+	err = client.deliveryAPI.(HealthChecker).HealthCheck(context.Background())
+	assert.EqualError(t, err, "failure calling Delivery API health check; statusCode=403")
+	keys, _ := server.seen()
+	assert.Equal(t, []string{"wrong-key"}, keys)
+}
+
+func TestFileCredentialsRotation(t *testing.T) {
+	logs := captureLog(t)
+	server := newKeyServer(t, "key-1")
+	path := filepath.Join(t.TempDir(), "api-key")
+	modTime := time.Now().Add(-time.Minute)
+	writeKeyFile(t, path, "key-1", modTime)
+	credentials, err := NewFileCredentials(path)
+	assert.NoError(t, err)
+
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryAPIKey("").
+		WithDeliveryCredentials(credentials).
+		Build()
+	assert.NoError(t, err)
+	healthCheck := client.deliveryAPI.(HealthChecker).HealthCheck
+	assert.NoError(t, healthCheck(context.Background()))
+
+	// The key is rotated on disk before the server starts rejecting the old one. The rejection refreshes the
+	// key and the request is retried once.
+	writeKeyFile(t, path, "key-2", modTime.Add(time.Second))
+	server.mu.Lock()
+	server.key = "key-2"
+	server.mu.Unlock()
+	assert.NoError(t, healthCheck(context.Background()))
+	keys, _ := server.seen()
+	assert.Equal(t, []string{"key-1", "key-1", "key-2"}, keys)
+
+	// A rotation that leaves the file empty keeps the previous key.
+	writeKeyFile(t, path, "", modTime.Add(2*time.Second))
+	assert.EqualError(t, credentials.Refresh(context.Background()), "API key file "+path+" is empty")
+	key, err := credentials.APIKey(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "key-2", key)
+	assert.NotContains(t, logs.String(), "key-1")
+	assert.NotContains(t, logs.String(), "key-2")
+}
+
+func TestCredentialsFuncRetriesOnceWithBody(t *testing.T) {
+	server := newKeyServer(t, "new-key")
+	var mu sync.Mutex
+	keys := []string{"old-key", "new-key"}
+	credentials := CredentialsFunc(func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		key := keys[0]
+		if len(keys) > 1 {
+			keys = keys[1:]
+		}
+		return key, nil
+	})
+
+	client, err := newTestClientBuilder().
+		WithMetricsEndpoint(server.URL).
+		WithMetricsAPIKey("").
+		WithMetricsCredentials(credentials).
+		Build()
+	assert.NoError(t, err)
+	assert.NoError(t, client.metricsAPI.RunMetricsLogging(&event.LogRequest{PlatformId: 7}))
+
+	seenKeys, bodies := server.seen()
+	assert.Equal(t, []string{"old-key", "new-key"}, seenKeys)
+	assert.Equal(t, 2, len(bodies))
+	assert.Contains(t, bodies[1], `"platform_id":7`)
+	assert.Equal(t, bodies[0], bodies[1])
+}
+
+func TestCredentialsRetryOnlyOnce(t *testing.T) {
+	logs := captureLog(t)
+	server := newKeyServer(t, "never-matches")
+	var calls int
+	var mu sync.Mutex
+	credentials := CredentialsFunc(func(ctx context.Context) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return "secret-" + string(rune('a'+calls)), nil
+	})
+
+	client, err := newTestClientBuilder().
+		WithMetricsEndpoint(server.URL).
+		WithMetricsAPIKey("").
+		WithMetricsCredentials(credentials).
+		Build()
+	assert.NoError(t, err)
+	err = client.metricsAPI.RunMetricsLogging(&event.LogRequest{})
+	assert.EqualError(t, err, "failure calling Metrics API; statusCode=403")
+	seenKeys, _ := server.seen()
+	assert.Equal(t, 2, len(seenKeys))
+	assert.NotContains(t, logs.String(), "secret-")
+}
+
+func TestCredentialsErrors(t *testing.T) {
+	server := newKeyServer(t, "key")
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryAPIKey("").
+		WithDeliveryCredentials(CredentialsFunc(func(ctx context.Context) (string, error) {
+			return "", errors.New("secret manager unavailable")
+		})).
+		Build()
+	assert.NoError(t, err)
+	err = client.deliveryAPI.(HealthChecker).HealthCheck(context.Background())
+	assert.ErrorContains(t, err, "error getting API key: secret manager unavailable")
+	keys, _ := server.seen()
+	assert.Empty(t, keys)
+
+	dir := t.TempDir()
+	_, err = NewFileCredentials(filepath.Join(dir, "missing"))
+	assert.ErrorContains(t, err, "error reading API key file: stat ")
+	empty := filepath.Join(dir, "empty")
+	writeKeyFile(t, empty, " ", time.Now())
+	_, err = NewFileCredentials(empty)
+	assert.EqualError(t, err, "API key file "+empty+" is empty")
+}
+
+func TestBuildCredentialsErrors(t *testing.T) {
+	_, err := newTestClientBuilder().
+		WithDeliveryCredentials(StaticCredentials("secret-delivery-key")).
+		WithMetricsCredentials(StaticCredentials("secret-metrics-key")).
+		Build()
+	assert.EqualError(t, err, "deliveryAPIKey and deliveryCredentials must not both be set\n"+
+		"metricsAPIKey and metricsCredentials must not both be set")
+	assert.NotContains(t, err.Error(), "secret")
+
+	_, err = newTestClientBuilder().
+		WithDeliveryAPIKey("").
+		WithDeliveryCredentials(StaticCredentials("key")).
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: new(MockDelivery), metricsAPI: new(MockMetrics)}).
+		Build()
+	assert.EqualError(t, err, "credentials providers require an HTTPAPIFactory, got *delivery.TestApiFactory")
+}
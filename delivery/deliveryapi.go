@@ -1,29 +1,41 @@
 package delivery
 
 import (
-	"bytes"
-	"compress/gzip"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/promotedai/schema/generated/go/proto/delivery"
-	"google.golang.org/protobuf/encoding/protojson"
 )
 
 const deliveryEndpointSuffix = "/deliver"
 const healthEndpointSuffix = "/healthz"
 
+// warmupRequests is the number of concurrent health checks sent by warmup, each of which opens a connection.
+const warmupRequests = 20
+
 // DeliveryAPI runs the main delivery workflow.
 type DeliveryAPI interface {
 	RunDelivery(deliveryRequest *DeliveryRequest) (*delivery.Response, error)
 }
 
+// ContextDeliveryAPI is implemented by Delivery APIs whose calls can be bounded by a context. The client uses it to
+// keep the Delivery API call within the Deliver deadline.
+type ContextDeliveryAPI interface {
+	RunDeliveryContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error)
+}
+
+// HealthChecker is implemented by Delivery APIs that can check their connection to the server. The client uses it
+// for keep-alive pings.
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
 // PromotedDeliveryAPI is the API client for Promoted.ai's Delivery API.
 type PromotedDeliveryAPI struct {
 	// deliveryHTTPEndpoint is the Delivery API endpoint.
@@ -38,7 +50,8 @@ type PromotedDeliveryAPI struct {
 	// httpClient for the remote call.
 	httpClient *http.Client
 
-	// timeoutDuration is the timeout set on the HTTP client as well as on the entire delivery process including SDK time.
+	// timeoutDuration is the timeout of each Delivery API call. It does not include SDK delivery or logging, which
+	// are bounded by the client's Deliver timeout instead.
 	timeoutDuration time.Duration
 
 	// maxRequestInsertions is the maximum number of request insertions passed to the delivery API.
@@ -48,7 +61,8 @@ type PromotedDeliveryAPI struct {
 	acceptGzip bool
 }
 
-// NewPromotedDeliveryAPI instantiates a new Delivery API client.
+// NewPromotedDeliveryAPI instantiates a new Delivery API client with the default HTTPOptions. It panics if the
+// endpoint can't be parsed.
 func NewPromotedDeliveryAPI(
 	endpoint,
 	apiKey string,
@@ -56,11 +70,36 @@ func NewPromotedDeliveryAPI(
 	maxRequestInsertions int,
 	acceptGzip,
 	warmup bool) *PromotedDeliveryAPI {
-	timeout := time.Duration(timeoutMillis) * time.Millisecond
-	httpClient := &http.Client{
-		Timeout: timeout,
+	httpClient, _ := HTTPOptions{}.NewClient(time.Duration(timeoutMillis) * time.Millisecond)
+	return NewPromotedDeliveryAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+}
+
+// NewCheckedPromotedDeliveryAPI is like NewPromotedDeliveryAPIWithHTTPClient, but returns an error instead of
+// panicking unless the endpoint is an absolute http or https URL with a host.
+func NewCheckedPromotedDeliveryAPI(
+	httpClient *http.Client,
+	endpoint,
+	apiKey string,
+	timeoutMillis int64,
+	maxRequestInsertions int,
+	acceptGzip,
+	warmup bool) (*PromotedDeliveryAPI, error) {
+	if err := checkEndpoint("endpoint", endpoint); err != nil {
+		return nil, err
 	}
+	return NewPromotedDeliveryAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup), nil
+}
 
+// NewPromotedDeliveryAPIWithHTTPClient instantiates a new Delivery API client that sends requests with httpClient.
+// It panics if the endpoint can't be parsed; use NewCheckedPromotedDeliveryAPI to get an error instead.
+func NewPromotedDeliveryAPIWithHTTPClient(
+	httpClient *http.Client,
+	endpoint,
+	apiKey string,
+	timeoutMillis int64,
+	maxRequestInsertions int,
+	acceptGzip,
+	warmup bool) *PromotedDeliveryAPI {
 	uri, err := url.Parse(endpoint)
 	if err != nil {
 		log.Panic("invalid delivery endpoint")
@@ -87,51 +126,55 @@ func NewPromotedDeliveryAPI(
 
 // RunDelivery performs delivery.
 func (d *PromotedDeliveryAPI) RunDelivery(deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
-	var resp *delivery.Response
+	return d.RunDeliveryContext(context.Background(), deliveryRequest)
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), d.timeoutDuration)
+// RunDeliveryContext performs delivery, giving up at the API's timeout or when ctx is done, whichever is first.
+func (d *PromotedDeliveryAPI) RunDeliveryContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeoutDuration)
 	defer cancel()
 
 	var request *delivery.Request
-	if len(deliveryRequest.Request.Insertion) > d.maxRequestInsertions {
+	if d.maxRequestInsertions != NoMaxRequestInsertions && len(deliveryRequest.Request.Insertion) > d.maxRequestInsertions {
 		// Only clone if we need to trim insertions.
-		request = deliveryRequest.Clone(1000).Request
+		request = deliveryRequest.Clone(d.maxRequestInsertions).Request
 	} else {
 		request = deliveryRequest.Request
 	}
 
-	requestBody, err := json.Marshal(request)
+	body, err := encodeJSON(request)
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling delivery request: %v", err)
 	}
+	defer body.release()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.deliveryHTTPEndpoint, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.deliveryHTTPEndpoint, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error creating HTTP request: %v", err)
 	}
+	body.setRequestBody(req)
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", d.apiKey)
+	if d.apiKey != "" {
+		req.Header.Set("x-api-key", d.apiKey)
+	}
 	if d.acceptGzip {
 		req.Header.Set("Accept-Encoding", "gzip")
 	}
 
 	respHTTP, err := d.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error making HTTP request: %v", err)
+		return nil, fmt.Errorf("error making HTTP request: %w", err)
 	}
-	defer respHTTP.Body.Close()
+	defer drainAndClose(respHTTP.Body)
 
 	if respHTTP.StatusCode < 200 || respHTTP.StatusCode >= 300 {
 		return nil, fmt.Errorf("failure calling Delivery API; statusCode=%d", respHTTP.StatusCode)
 	}
 
-	if d.acceptGzip && respHTTP.Header.Get("Content-Encoding") == "gzip" {
-		resp, err = d.processCompressedResponse(respHTTP.Body)
-	} else {
-		resp, err = d.processUncompressedResponse(respHTTP.Body)
-	}
-	if err != nil {
+	var resp delivery.Response
+	gzipped := d.acceptGzip && respHTTP.Header.Get("Content-Encoding") == "gzip"
+	if err := readProtoJSON(respHTTP, gzipped, maxResponseBytes, &resp, callStatsFromContext(ctx)); err != nil {
 		return nil, err
 	}
 
@@ -139,59 +182,55 @@ func (d *PromotedDeliveryAPI) RunDelivery(deliveryRequest *DeliveryRequest) (*de
 		return nil, fmt.Errorf("delivery response should contain a requestId")
 	}
 
-	return resp, nil
+	return &resp, nil
 }
 
-func (d *PromotedDeliveryAPI) processUncompressedResponse(body io.Reader) (*delivery.Response, error) {
-	var buf bytes.Buffer
-	_, err := io.Copy(&buf, body)
-	if err != nil {
-		return nil, fmt.Errorf("error reading response body: %v", err)
-	}
-
-	var resp delivery.Response
-	err = protojson.Unmarshal(buf.Bytes(), &resp)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling JSON response: %v", err)
+// runDeliveryContext calls api with ctx if it is a ContextDeliveryAPI, and without it otherwise.
+func runDeliveryContext(ctx context.Context, api DeliveryAPI, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	if contextAPI, ok := api.(ContextDeliveryAPI); ok {
+		return contextAPI.RunDeliveryContext(ctx, deliveryRequest)
 	}
-	return &resp, nil
+	return api.RunDelivery(deliveryRequest)
 }
 
-func (d *PromotedDeliveryAPI) processCompressedResponse(body io.Reader) (*delivery.Response, error) {
-	gzipReader, err := gzip.NewReader(body)
+// HealthCheck calls the health check endpoint and returns an error unless it responds with a 2xx status.
+func (d *PromotedDeliveryAPI) HealthCheck(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, d.healthHTTPEndpoint, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error creating gzip reader: %v", err)
+		return fmt.Errorf("error creating HTTP request: %v", err)
+	}
+	if d.apiKey != "" {
+		req.Header.Set("x-api-key", d.apiKey)
 	}
-	defer gzipReader.Close()
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, gzipReader)
+	resp, err := d.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("error reading compressed response body: %v", err)
+		return fmt.Errorf("error making HTTP request: %v", err)
 	}
+	drainAndClose(resp.Body)
 
-	var resp delivery.Response
-	err = protojson.Unmarshal(buf.Bytes(), &resp)
-	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling JSON response: %v", err)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failure calling Delivery API health check; statusCode=%d", resp.StatusCode)
 	}
-	return &resp, nil
+	return nil
 }
 
-// runWarmup performs a warmup by making GET requests to the healthzEndpoint.
+// runWarmup opens connections to the Delivery API with concurrent health checks, so the first deliveries do not
+// pay for connection setup. Each response body is drained and closed so its connection returns to the pool.
 func (d *PromotedDeliveryAPI) runWarmup() {
-	for i := 0; i < 20; i++ {
-		req, err := http.NewRequest("GET", d.healthHTTPEndpoint, nil)
-		if err != nil {
-			log.Print("error during warmup")
-			continue
-		}
-		req.Header.Set("x-api-key", d.apiKey)
-
-		_, err = d.httpClient.Do(req)
-		if err != nil {
-			log.Print("error during warmup")
-			continue
-		}
+	var wg sync.WaitGroup
+	var failures atomic.Int32
+	for i := 0; i < warmupRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.HealthCheck(context.Background()); err != nil {
+				failures.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := failures.Load(); n > 0 {
+		log.Printf("Error during warmup: %d of %d requests failed\n", n, warmupRequests)
 	}
 }
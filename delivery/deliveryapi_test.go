@@ -0,0 +1,107 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromotedDeliveryAPIHealthCheck(t *testing.T) {
+	status := http.StatusOK
+	var apiKey string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, healthEndpointSuffix, r.URL.Path)
+		apiKey = r.Header.Get("x-api-key")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	api := NewPromotedDeliveryAPI(server.URL+"/deliver", "key", 1000, 100, false, false)
+	assert.NoError(t, api.HealthCheck(context.Background()))
+	assert.Equal(t, "key", apiKey)
+
+	status = http.StatusServiceUnavailable
+	assert.EqualError(t, api.HealthCheck(context.Background()), "failure calling Delivery API health check; statusCode=503")
+}
+
+func TestPromotedDeliveryAPIWarmup(t *testing.T) {
+	var healthChecks atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, healthEndpointSuffix, r.URL.Path)
+		healthChecks.Add(1)
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	NewPromotedDeliveryAPI(server.URL+"/deliver", "key", 1000, 100, false, true)
+	assert.Equal(t, int32(warmupRequests), healthChecks.Load())
+}
+
+func TestErrorResponsesReuseConnections(t *testing.T) {
+	var connections atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, strings.Repeat("unavailable ", 1000), http.StatusServiceUnavailable)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			connections.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	httpClient := &http.Client{Transport: &http.Transport{}}
+	deliveryAPI := NewPromotedDeliveryAPIWithHTTPClient(httpClient, server.URL+"/deliver", "key", 1000, 100, false, false)
+	metricsAPI := NewPromotedMetricsAPIWithHTTPClient(httpClient, server.URL+"/log", "key", 1000)
+	for i := 0; i < 3; i++ {
+		_, err := deliveryAPI.RunDelivery(&DeliveryRequest{Request: &delivery.Request{}})
+		assert.EqualError(t, err, "failure calling Delivery API; statusCode=503")
+		assert.EqualError(t, metricsAPI.RunMetricsLogging(&event.LogRequest{}), "failure calling Metrics API; statusCode=503")
+	}
+	assert.Equal(t, int32(1), connections.Load())
+}
+
+func TestPromotedDeliveryAPITruncatesToMaxRequestInsertions(t *testing.T) {
+	var sent int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Insertion []json.RawMessage `json:"insertion"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sent = len(req.Insertion)
+		_, _ = w.Write([]byte(`{"requestId": "r"}`))
+	}))
+	defer server.Close()
+
+	req := &DeliveryRequest{Request: &delivery.Request{Insertion: CreateTestRequestInsertions(1200)}}
+	_, err := NewPromotedDeliveryAPI(server.URL+"/deliver", "key", 1000, 1500, false, false).RunDelivery(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1200, sent)
+
+	_, err = NewPromotedDeliveryAPI(server.URL+"/deliver", "key", 1000, 2, false, false).RunDelivery(req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	assert.Equal(t, 1200, len(req.Request.Insertion))
+}
+
+func TestNewCheckedPromotedDeliveryAPI(t *testing.T) {
+	api, err := NewCheckedPromotedDeliveryAPI(http.DefaultClient, "https://delivery.example.com/deliver", "key", 1000, 100, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://delivery.example.com/deliver", api.deliveryHTTPEndpoint)
+
+	api, err = NewCheckedPromotedDeliveryAPI(http.DefaultClient, "%zz", "key", 1000, 100, false, false)
+	assert.Nil(t, api)
+	assert.ErrorContains(t, err, "endpoint is not a valid URL")
+
+	_, err = NewCheckedPromotedDeliveryAPI(http.DefaultClient, "delivery.example.com", "key", 1000, 100, false, false)
+	assert.EqualError(t, err, `endpoint "delivery.example.com" must use http or https`)
+}
@@ -1,7 +1,12 @@
 package delivery
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -14,8 +19,14 @@ const defaultDeliveryTimeoutMillis = 250
 const defaultMetricsTimeoutMillis = 3000
 const defaultMaxRequestInsertions = 1000
 
+// DefaultFallbackReserve is the part of the Deliver deadline kept for SDK delivery when the Delivery API fails.
+const DefaultFallbackReserve = 10 * time.Millisecond
+
 const serverVersion = "go.1.0.0"
 
+// errNoTimeLeft is returned when the Delivery API is skipped to keep the fallback reserve.
+var errNoTimeLeft = errors.New("no time left for the Delivery API")
+
 // PromotedDeliveryClient is a client for interacting with the Promoted.ai Delivery API.
 type PromotedDeliveryClient struct {
 	deliveryAPI               DeliveryAPI
@@ -31,48 +42,247 @@ type PromotedDeliveryClient struct {
 	applyTreatmentChecker     ApplyTreatmentChecker
 	shadowTrafficDeliveryRate float32
 	sampler                   Sampler
-	performChecks             bool
+	validationMode            ValidationMode
 	blockingShadowTraffic     bool
+	sdkOnly                   bool
+
+	// defaultValidator validates requests that have no validator of their own, with the client's
+	// maxRequestInsertions. It is nil for clients not made by Build, which use DefaultDeliveryRequestValidator.
+	defaultValidator DeliveryRequestValidator
+
+	// deliverTimeout bounds each Deliver call, and fallbackReserve is the part of it kept for SDK delivery.
+	deliverTimeout  time.Duration
+	fallbackReserve time.Duration
+
+	// planLogging logs the plan and outcome of every Deliver call.
+	planLogging bool
+
+	// deliverHandler runs deliver through the Deliver interceptors. It is nil when there are none.
+	deliverHandler DeliverHandler
+
+	// lastDeliveryAPICall is when the Delivery API was last called, in Unix nanoseconds, so keep-alive pings are
+	// only sent when the connections have been idle.
+	lastDeliveryAPICall atomic.Int64
+
+	// done is closed by Close to stop the keep-alive, and keepAliveDone when it has stopped.
+	done          chan struct{}
+	keepAliveDone chan struct{}
+	closeOnce     sync.Once
 }
 
 // Deliver sends a delivery request and returns the response.
 func (client *PromotedDeliveryClient) Deliver(deliveryRequest *DeliveryRequest) (*DeliveryResponse, error) {
+	return client.runDeliver(context.Background(), deliveryRequest)
+}
+
+// DeliverContext is like Deliver, but first fills in the user, device and client info stored in ctx by the HTTP
+// middleware, without overwriting fields already set on the request. The Delivery API call also ends at ctx's
+// deadline, less the fallback reserve.
+func (client *PromotedDeliveryClient) DeliverContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*DeliveryResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if info, ok := HTTPRequestInfoFromContext(ctx); ok && deliveryRequest != nil {
+		info.ApplyTo(deliveryRequest.Request)
+	}
+	return client.runDeliver(ctx, deliveryRequest)
+}
+
+// runDeliver runs deliver through the Deliver interceptors, if any.
+func (client *PromotedDeliveryClient) runDeliver(ctx context.Context, deliveryRequest *DeliveryRequest) (*DeliveryResponse, error) {
+	if client.deliverHandler != nil {
+		return client.deliverHandler(ctx, deliveryRequest)
+	}
+	return client.deliver(ctx, deliveryRequest)
+}
+
+// deliver runs Deliver within the Deliver timeout, if set, and records the time spent in each phase.
+func (client *PromotedDeliveryClient) deliver(ctx context.Context, deliveryRequest *DeliveryRequest) (*DeliveryResponse, error) {
+	start := time.Now()
+	if client.deliverTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.deliverTimeout)
+		defer cancel()
+	}
+
+	// Validate before planning, which reads the request.
+	if err := client.validateRequest(deliveryRequest); err != nil {
+		return nil, err
+	}
 	plan := client.Plan(deliveryRequest.OnlyLog, deliveryRequest.Experiment)
-	client.PrepareRequest(deliveryRequest, plan)
+	client.prepareRequest(deliveryRequest, plan)
+	validation := time.Since(start)
 
 	var apiResponse *delivery.Response
-	var err error
+	var apiDuration time.Duration
+	var stats *callStats
+	fallbackReason := FallbackReasonNone
 	if plan.UseAPIResponse {
-		apiResponse, err = client.CallDeliveryAPI(apiResponse, err, deliveryRequest)
+		apiStart := time.Now()
+		var err error
+		var apiCtx context.Context
+		apiCtx, stats = withCallStats(ctx)
+		apiResponse, err = client.callDeliveryAPI(apiCtx, deliveryRequest)
+		apiDuration = time.Since(apiStart)
 		if err != nil {
-			log.Printf("Error calling Delivery API, falling back: %v\n", err)
+			fallbackReason = fallbackReasonOf(err)
+			if fallbackReason == FallbackReasonNoTimeLeft {
+				apiDuration = 0
+			}
+			log.Printf("Error calling Delivery API, falling back: %s fallback_reason=%s error=%q\n", plan, fallbackReason, err.Error())
 		}
 	}
 
 	// Note this returns a delivery response based on this apiResponse if it's set, and creates
 	// an SDK response otherwise.
-	return client.HandleSDKAndLog(deliveryRequest, plan, apiResponse)
+	resp, err := client.HandleSDKAndLog(deliveryRequest, plan, apiResponse)
+	if err != nil {
+		return nil, err
+	}
+	resp.Timing.Validation = validation
+	resp.Timing.DeliveryAPI = apiDuration
+	if stats != nil {
+		resp.Timing.Decoding = time.Duration(stats.decoding.Load())
+		resp.Retries = int(stats.retries.Load())
+	}
+	resp.FallbackReason = fallbackReason
+	resp.Timing.Total = time.Since(start)
+	if client.planLogging {
+		log.Printf("Delivery plan: %s execution_server=%s fallback_reason=%s\n", plan, resp.ExecutionServer, fallbackReason)
+	}
+	return resp, nil
 }
 
 func (client *PromotedDeliveryClient) CallDeliveryAPI(apiResponse *delivery.Response, err error, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
-	return client.deliveryAPI.RunDelivery(deliveryRequest)
+	return client.callDeliveryAPI(context.Background(), deliveryRequest)
+}
+
+// callDeliveryAPI calls the Delivery API, ending the call early enough to leave the fallback reserve before ctx's
+// deadline.
+func (client *PromotedDeliveryClient) callDeliveryAPI(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	if client.deliveryAPI == nil {
+		return nil, errors.New("the Delivery API is not used by SDK-only clients")
+	}
+	if deadline, ok := ctx.Deadline(); ok && client.fallbackReserve > 0 {
+		apiDeadline := deadline.Add(-client.fallbackReserve)
+		if !time.Now().Before(apiDeadline) {
+			return nil, fmt.Errorf("%w after reserving %s for fallback", errNoTimeLeft, client.fallbackReserve)
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, apiDeadline)
+		defer cancel()
+	}
+	client.lastDeliveryAPICall.Store(time.Now().UnixNano())
+	return runDeliveryContext(ctx, client.deliveryAPI, deliveryRequest)
+}
+
+// Close stops the background keep-alive, if any, and waits for it to finish. The client can still deliver after
+// Close. It is safe to call more than once.
+func (client *PromotedDeliveryClient) Close() error {
+	client.closeOnce.Do(func() {
+		close(client.done)
+		if client.keepAliveDone != nil {
+			<-client.keepAliveDone
+		}
+	})
+	return nil
+}
+
+// startKeepAlive pings the health check whenever interval passes without a Delivery API call, until Close.
+func (client *PromotedDeliveryClient) startKeepAlive(healthChecker HealthChecker, interval time.Duration) {
+	client.keepAliveDone = make(chan struct{})
+	go func() {
+		defer close(client.keepAliveDone)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-client.done:
+				return
+			case now := <-ticker.C:
+				if now.Sub(time.Unix(0, client.lastDeliveryAPICall.Load())) < interval {
+					continue
+				}
+				client.keepAlive(healthChecker)
+			}
+		}
+	}()
+}
+
+// keepAlive sends one keep-alive ping, giving up when the client is closed.
+func (client *PromotedDeliveryClient) keepAlive(healthChecker HealthChecker) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(client.deliveryTimeoutMillis)*time.Millisecond)
+	defer cancel()
+	go func() {
+		select {
+		case <-client.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	client.lastDeliveryAPICall.Store(time.Now().UnixNano())
+	if err := healthChecker.HealthCheck(ctx); err != nil && ctx.Err() == nil {
+		log.Printf("Error sending Delivery API keep-alive: %v\n", err)
+	}
 }
 
 // Plan returns a DeliveryPlan that determines SDK execution, always using SDK if we are
-// only logging, and otherwise checking the experiment to decide.
+// only logging or in SDK-only mode, and otherwise checking the experiment to decide. The plan records the reason
+// and the inputs it was decided from.
 func (client *PromotedDeliveryClient) Plan(onlyLog bool, experiment *event.CohortMembership) *DeliveryPlan {
-	useApiResponse := !onlyLog && client.shouldApplyTreatment(experiment)
-	return NewDeliveryPlan(client.generateClientID(), useApiResponse)
+	plan := &DeliveryPlan{
+		ClientRequestID:        client.generateClientID(),
+		OnlyLog:                onlyLog,
+		SDKOnly:                client.sdkOnly,
+		Experiment:             experiment,
+		CustomTreatmentChecker: client.applyTreatmentChecker != nil,
+	}
+	switch {
+	case onlyLog:
+		plan.Reason = PlanReasonOnlyLog
+	case client.sdkOnly:
+		plan.Reason = PlanReasonSDKOnly
+	default:
+		plan.Reason = client.treatmentReason(experiment)
+	}
+	plan.UseAPIResponse = plan.Reason.UsesAPI()
+	return plan
 }
 
-// PrepareRequest prepares the delivery request using the plan.
-func (client *PromotedDeliveryClient) PrepareRequest(deliveryRequest *DeliveryRequest, plan *DeliveryPlan) {
-	if client.performChecks {
-		validationErrors := deliveryRequest.Validate()
-		for _, validationError := range validationErrors {
-			log.Printf("Delivery Request Validation Error: %s\n", validationError)
+// PrepareRequest validates the delivery request according to the client's validation mode and prepares it
+// using the plan. In strict validation mode an invalid request returns a *ValidationError and is left unmodified.
+func (client *PromotedDeliveryClient) PrepareRequest(deliveryRequest *DeliveryRequest, plan *DeliveryPlan) error {
+	if err := client.validateRequest(deliveryRequest); err != nil {
+		return err
+	}
+	client.prepareRequest(deliveryRequest, plan)
+	return nil
+}
+
+// validateRequest validates the delivery request according to the client's validation mode, and returns an
+// error if the request is not set.
+func (client *PromotedDeliveryClient) validateRequest(deliveryRequest *DeliveryRequest) error {
+	if client.validationMode != ValidationModeOff {
+		defaultValidator := client.defaultValidator
+		if defaultValidator == nil {
+			defaultValidator = &DefaultDeliveryRequestValidator{}
+		}
+		issues := deliveryRequest.validateIssues(defaultValidator)
+		if len(issues) > 0 && client.validationMode == ValidationModeStrict {
+			return &ValidationError{Issues: issues}
+		}
+		for _, issue := range issues {
+			log.Printf("Delivery Request Validation Error: %s\n", issue)
 		}
 	}
+	if deliveryRequest == nil || deliveryRequest.Request == nil {
+		return errors.New("delivery request must be set")
+	}
+	return nil
+}
+
+// prepareRequest fills in the client request ID and the fields the client sets on every request.
+func (client *PromotedDeliveryClient) prepareRequest(deliveryRequest *DeliveryRequest, plan *DeliveryPlan) {
 	client.ensureClientRequestID(deliveryRequest.Request, plan.ClientRequestID)
 	client.fillInRequestFields(deliveryRequest.Request)
 }
@@ -83,17 +293,20 @@ func (client *PromotedDeliveryClient) HandleSDKAndLog(deliveryRequest *DeliveryR
 
 	var response *delivery.Response
 	var execSrv delivery.ExecutionServer
+	var timing DeliveryTiming
 
 	if apiResponse != nil {
 		response = apiResponse
 		execSrv = delivery.ExecutionServer_API
 	} else {
+		sdkStart := time.Now()
 		var err error
 		response, err = client.sdkDelivery.RunDelivery(deliveryRequest)
 		if err != nil {
 			return nil, err
 		}
 		execSrv = delivery.ExecutionServer_SDK
+		timing.SDKDelivery = time.Since(sdkStart)
 	}
 
 	// Log SDK DeliveryLog to Metrics API.
@@ -110,6 +323,8 @@ func (client *PromotedDeliveryClient) HandleSDKAndLog(deliveryRequest *DeliveryR
 		Response:        response,
 		ClientRequestID: plan.ClientRequestID,
 		ExecutionServer: execSrv,
+		PlanReason:      plan.Reason,
+		Timing:          timing,
 	}, nil
 }
 
@@ -134,21 +349,28 @@ func (client *PromotedDeliveryClient) doDeliverShadowTraffic(deliveryRequest *De
 	requestToSend.Request.ClientInfo.ClientType = common.ClientInfo_PLATFORM_SERVER
 	requestToSend.Request.ClientInfo.TrafficType = common.ClientInfo_SHADOW
 
+	client.lastDeliveryAPICall.Store(time.Now().UnixNano())
 	_, err := client.deliveryAPI.RunDelivery(requestToSend)
 	if err != nil {
 		log.Printf("Error calling Delivery API for shadow traffic: %v\n", err)
 	}
 }
 
-// shouldApplyTreatment checks whether treatment should be applied to a cohort membership.
-func (client *PromotedDeliveryClient) shouldApplyTreatment(cohortMembership *event.CohortMembership) bool {
+// treatmentReason checks whether treatment should be applied to a cohort membership.
+func (client *PromotedDeliveryClient) treatmentReason(cohortMembership *event.CohortMembership) PlanReason {
 	if client.applyTreatmentChecker != nil {
-		return client.applyTreatmentChecker.ShouldApplyTreatment(cohortMembership)
+		if client.applyTreatmentChecker.ShouldApplyTreatment(cohortMembership) {
+			return PlanReasonTreatmentCheckerApplied
+		}
+		return PlanReasonTreatmentCheckerDeclined
 	}
 	if cohortMembership == nil {
-		return true
+		return PlanReasonNoExperiment
 	}
-	return cohortMembership.Arm != event.CohortArm_CONTROL
+	if cohortMembership.Arm == event.CohortArm_CONTROL {
+		return PlanReasonControlArm
+	}
+	return PlanReasonTreatmentArm
 }
 
 // shouldSendShadowTraffic checks whether shadow traffic should be sent.
@@ -167,8 +389,11 @@ func (client *PromotedDeliveryClient) cloneCohortMembership(cohortMembership *ev
 	}
 }
 
-// logToMetrics logs to the Metrics API.
+// logToMetrics logs to the Metrics API, unless an SDK-only client has no Metrics API.
 func (client *PromotedDeliveryClient) logToMetrics(deliveryRequest *DeliveryRequest, deliveryResponse *delivery.Response, cohortMembership *event.CohortMembership, execSrv delivery.ExecutionServer) {
+	if client.metricsAPI == nil {
+		return
+	}
 	go func() {
 		logRequest := client.createLogRequest(deliveryRequest, deliveryResponse, cohortMembership, execSrv)
 		err := client.metricsAPI.RunMetricsLogging(logRequest)
@@ -213,7 +438,10 @@ func (client *PromotedDeliveryClient) fillInRequestFields(req *delivery.Request)
 	}
 	clientInfo := req.ClientInfo
 	clientInfo.ClientType = common.ClientInfo_PLATFORM_SERVER
-	clientInfo.TrafficType = common.ClientInfo_PRODUCTION
+	// Replayed traffic keeps its traffic type so it is not counted as production traffic.
+	if clientInfo.TrafficType != common.ClientInfo_REPLAY {
+		clientInfo.TrafficType = common.ClientInfo_PRODUCTION
+	}
 
 	// Fill in client timestamp if not set by the caller.
 	client.ensureClientTimestamp(req)
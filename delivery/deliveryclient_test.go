@@ -0,0 +1,209 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// contextDeliveryFunc is a ContextDeliveryAPI that calls the function.
+type contextDeliveryFunc func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error)
+
+func (f contextDeliveryFunc) RunDelivery(deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return f(context.Background(), deliveryRequest)
+}
+
+func (f contextDeliveryFunc) RunDeliveryContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return f(ctx, deliveryRequest)
+}
+
+func newDeadlineTestClient(t *testing.T, api DeliveryAPI, deliverTimeout, fallbackReserve time.Duration) *PromotedDeliveryClient {
+	metrics := new(MockMetrics)
+	metrics.On("RunMetricsLogging", mock.Anything).Return(nil)
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: api, metricsAPI: metrics}).
+		WithDeliverTimeout(deliverTimeout).
+		WithFallbackReserve(fallbackReserve).
+		Build()
+	assert.NoError(t, err)
+	return client
+}
+
+func newDeadlineTestRequest() *DeliveryRequest {
+	return &DeliveryRequest{Request: &delivery.Request{Insertion: CreateTestRequestInsertions(3)}}
+}
+
+func TestDeliverTimeoutReservesFallback(t *testing.T) {
+	var apiDeadline time.Time
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		apiDeadline, _ = ctx.Deadline()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	client := newDeadlineTestClient(t, api, 100*time.Millisecond, 40*time.Millisecond)
+
+	start := time.Now()
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Equal(t, 3, len(resp.Response.Insertion))
+
+	// The Delivery API gets the Deliver timeout less the fallback reserve.
+	assert.WithinDuration(t, start.Add(60*time.Millisecond), apiDeadline, 10*time.Millisecond)
+	assert.GreaterOrEqual(t, resp.Timing.DeliveryAPI, 50*time.Millisecond)
+	assert.Less(t, resp.Timing.DeliveryAPI, 100*time.Millisecond)
+	assert.Positive(t, resp.Timing.SDKDelivery)
+	assert.GreaterOrEqual(t, resp.Timing.Total, resp.Timing.DeliveryAPI+resp.Timing.SDKDelivery)
+	assert.Less(t, resp.Timing.Total, 100*time.Millisecond)
+	assert.Equal(t, FallbackReasonTimeout, resp.FallbackReason)
+}
+
+func TestDeliverContextDeadlineReservesFallback(t *testing.T) {
+	var hasDeadline bool
+	var apiDeadline time.Time
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		apiDeadline, hasDeadline = ctx.Deadline()
+		return &delivery.Response{RequestId: "request-1", Insertion: deliveryRequest.Request.Insertion}, nil
+	})
+	client := newDeadlineTestClient(t, api, 0, 20*time.Millisecond)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.False(t, hasDeadline)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.Zero(t, resp.Timing.SDKDelivery)
+	assert.Equal(t, FallbackReasonNone, resp.FallbackReason)
+
+	deadline := time.Now().Add(time.Second)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	resp, err = client.DeliverContext(ctx, newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.True(t, hasDeadline)
+	assert.Equal(t, deadline.Add(-20*time.Millisecond), apiDeadline)
+}
+
+func TestDeliverSkipsDeliveryAPIWithoutTimeLeft(t *testing.T) {
+	logs := captureLog(t)
+	called := false
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		called = true
+		return nil, errors.New("unexpected call")
+	})
+	client := newDeadlineTestClient(t, api, 0, 50*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	resp, err := client.DeliverContext(ctx, newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.False(t, called)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Contains(t, logs.String(), "no time left for the Delivery API after reserving 50ms for fallback")
+	assert.Equal(t, FallbackReasonNoTimeLeft, resp.FallbackReason)
+	assert.Zero(t, resp.Timing.DeliveryAPI)
+}
+
+func TestDeliverTimeoutBoundsHTTPCall(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	captureLog(t)
+
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryTimeoutMillis(1000).
+		WithDeliverTimeout(80 * time.Millisecond).
+		WithFallbackReserve(30 * time.Millisecond).
+		WithMetricsEndpoint(server.URL).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Less(t, resp.Timing.Total, 500*time.Millisecond)
+	assert.Less(t, resp.Timing.DeliveryAPI, 500*time.Millisecond)
+	assert.Equal(t, FallbackReasonTimeout, resp.FallbackReason)
+}
+
+func TestDeliverReportsFallbackErrors(t *testing.T) {
+	captureLog(t)
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		return nil, errors.New("failure calling Delivery API; statusCode=503")
+	})
+	client := newDeadlineTestClient(t, api, 0, 0)
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Equal(t, FallbackReasonError, resp.FallbackReason)
+	assert.Equal(t, "error", resp.FallbackReason.String())
+
+	// Requests that are planned for SDK delivery did not fall back.
+	req := newDeadlineTestRequest()
+	req.OnlyLog = true
+	resp, err = client.Deliver(req)
+	assert.NoError(t, err)
+	assert.Equal(t, FallbackReasonNone, resp.FallbackReason)
+	assert.Zero(t, resp.Timing.DeliveryAPI)
+}
+
+func TestDeliverReportsRetriesAndDecoding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-api-key") != "new-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"requestId":"request-1","insertion":[{"contentId":"2"},{"contentId":"1"},{"contentId":"0"}]}`))
+	}))
+	defer server.Close()
+	var calls atomic.Int32
+	credentials := CredentialsFunc(func(ctx context.Context) (string, error) {
+		if calls.Add(1) == 1 {
+			return "old-key", nil
+		}
+		return "new-key", nil
+	})
+
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryAPIKey("").
+		WithDeliveryCredentials(credentials).
+		WithMetricsEndpoint(server.URL).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.Equal(t, 1, resp.Retries)
+	assert.Equal(t, FallbackReasonNone, resp.FallbackReason)
+	assert.Positive(t, resp.Timing.Decoding)
+	assert.Less(t, resp.Timing.Decoding, resp.Timing.DeliveryAPI)
+	assert.LessOrEqual(t, resp.Timing.Validation+resp.Timing.DeliveryAPI, resp.Timing.Total)
+}
+
+func TestBuildDeliverTimeoutErrors(t *testing.T) {
+	_, err := newTestClientBuilder().
+		WithDeliverTimeout(-time.Second).
+		WithFallbackReserve(-time.Second).
+		Build()
+	assert.EqualError(t, err, "deliverTimeout must not be negative\nfallbackReserve must not be negative")
+
+	_, err = newTestClientBuilder().
+		WithDeliverTimeout(10 * time.Millisecond).
+		Build()
+	assert.EqualError(t, err, "fallbackReserve 10ms must be shorter than deliverTimeout 10ms")
+}
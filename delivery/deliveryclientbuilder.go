@@ -2,6 +2,10 @@ package delivery
 
 import (
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
 )
 
 type PromotedDeliveryClientBuilder struct {
@@ -17,9 +21,22 @@ type PromotedDeliveryClientBuilder struct {
 	sampler                   Sampler
 	apiFactory                APIFactory
 	shadowTrafficDeliveryRate float32
-	performChecks             bool
+	validationMode            ValidationMode
 	blockingShadowTraffic     bool
 	acceptsGzip               bool
+	sdkOnly                   bool
+	keepAliveInterval         time.Duration
+	deliveryHTTPOptions       HTTPOptions
+	metricsHTTPOptions        HTTPOptions
+	deliveryCredentials       CredentialsProvider
+	metricsCredentials        CredentialsProvider
+	requestCompression        RequestCompression
+	deliverTimeout            time.Duration
+	fallbackReserve           time.Duration
+	planLogging               bool
+	deliverInterceptors       []DeliverInterceptor
+	deliveryAPIInterceptors   []DeliveryAPIInterceptor
+	metricsInterceptors       []MetricsInterceptor
 }
 
 // NewPromotedDeliveryClientBuilder implements a builder interface for PromotedDeliveryClient.
@@ -28,6 +45,7 @@ func NewPromotedDeliveryClientBuilder() *PromotedDeliveryClientBuilder {
 		deliveryTimeoutMillis: defaultDeliveryTimeoutMillis,
 		metricsTimeoutMillis:  defaultMetricsTimeoutMillis,
 		maxRequestInsertions:  defaultMaxRequestInsertions,
+		fallbackReserve:       DefaultFallbackReserve,
 	}
 }
 
@@ -86,8 +104,20 @@ func (b *PromotedDeliveryClientBuilder) WithShadowTrafficDeliveryRate(shadowTraf
 	return b
 }
 
+// WithPerformChecks turns on logging of request validation issues. It is equivalent to
+// WithValidationMode(ValidationModeLog) when true and WithValidationMode(ValidationModeOff) when false.
 func (b *PromotedDeliveryClientBuilder) WithPerformChecks(performChecks bool) *PromotedDeliveryClientBuilder {
-	b.performChecks = performChecks
+	if performChecks {
+		b.validationMode = ValidationModeLog
+	} else {
+		b.validationMode = ValidationModeOff
+	}
+	return b
+}
+
+// WithValidationMode sets how request validation issues are handled. Defaults to ValidationModeOff.
+func (b *PromotedDeliveryClientBuilder) WithValidationMode(validationMode ValidationMode) *PromotedDeliveryClientBuilder {
+	b.validationMode = validationMode
 	return b
 }
 
@@ -101,7 +131,154 @@ func (b *PromotedDeliveryClientBuilder) WithAcceptsGzip(acceptsGzip bool) *Promo
 	return b
 }
 
+// WithWarmup opens connections to the Delivery API with concurrent health checks when the client is built, so the
+// first deliveries do not pay for connection setup. Build blocks until the warmup finishes.
+func (b *PromotedDeliveryClientBuilder) WithWarmup(warmup bool) *PromotedDeliveryClientBuilder {
+	b.warmup = warmup
+	return b
+}
+
+// WithKeepAliveInterval pings the Delivery API health check in the background whenever the interval passes
+// without a Delivery API call, so pooled connections stay open during low traffic. Zero, the default, turns it
+// off. Call Close on the client to stop it.
+func (b *PromotedDeliveryClientBuilder) WithKeepAliveInterval(keepAliveInterval time.Duration) *PromotedDeliveryClientBuilder {
+	b.keepAliveInterval = keepAliveInterval
+	return b
+}
+
+// WithDeliverTimeout bounds each Deliver call from start to finish, including SDK delivery after a failed Delivery
+// API call. The Delivery API call gets what is left of it after the fallback reserve, or the Delivery timeout if
+// that is shorter. Zero, the default, leaves Deliver unbounded apart from the Delivery timeout.
+func (b *PromotedDeliveryClientBuilder) WithDeliverTimeout(deliverTimeout time.Duration) *PromotedDeliveryClientBuilder {
+	b.deliverTimeout = deliverTimeout
+	return b
+}
+
+// WithFallbackReserve sets how much of the Deliver deadline is kept for SDK delivery when the Delivery API call
+// fails or runs out of time. It applies to the WithDeliverTimeout deadline and to the deadline of the context
+// passed to DeliverContext. Defaults to DefaultFallbackReserve.
+func (b *PromotedDeliveryClientBuilder) WithFallbackReserve(fallbackReserve time.Duration) *PromotedDeliveryClientBuilder {
+	b.fallbackReserve = fallbackReserve
+	return b
+}
+
+// WithPlanLogging logs the delivery plan and outcome of every Deliver call as key=value pairs, including calls
+// delivered by the SDK. Defaults to false.
+func (b *PromotedDeliveryClientBuilder) WithPlanLogging(planLogging bool) *PromotedDeliveryClientBuilder {
+	b.planLogging = planLogging
+	return b
+}
+
+// WithDeliverInterceptors adds interceptors around each Deliver and DeliverContext call, after the ones already
+// added. The first interceptor is the outermost: it sees the request first and the response last.
+func (b *PromotedDeliveryClientBuilder) WithDeliverInterceptors(interceptors ...DeliverInterceptor) *PromotedDeliveryClientBuilder {
+	b.deliverInterceptors = append(b.deliverInterceptors, interceptors...)
+	return b
+}
+
+// WithDeliveryAPIInterceptors adds interceptors around each Delivery API call, after the ones already added. The
+// first interceptor is the outermost.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryAPIInterceptors(interceptors ...DeliveryAPIInterceptor) *PromotedDeliveryClientBuilder {
+	b.deliveryAPIInterceptors = append(b.deliveryAPIInterceptors, interceptors...)
+	return b
+}
+
+// WithMetricsInterceptors adds interceptors around each Metrics API call, after the ones already added. The first
+// interceptor is the outermost.
+func (b *PromotedDeliveryClientBuilder) WithMetricsInterceptors(interceptors ...MetricsInterceptor) *PromotedDeliveryClientBuilder {
+	b.metricsInterceptors = append(b.metricsInterceptors, interceptors...)
+	return b
+}
+
+// WithRequestCompression compresses the request bodies sent to the Delivery and Metrics APIs. This is separate
+// from WithAcceptsGzip, which asks for compressed Delivery API responses.
+func (b *PromotedDeliveryClientBuilder) WithRequestCompression(compression RequestCompression) *PromotedDeliveryClientBuilder {
+	b.requestCompression = compression
+	return b
+}
+
+// WithDeliveryCredentials supplies the Delivery API key for each request instead of the fixed key from
+// WithDeliveryAPIKey, so the key can be rotated without rebuilding the client.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryCredentials(credentials CredentialsProvider) *PromotedDeliveryClientBuilder {
+	b.deliveryCredentials = credentials
+	return b
+}
+
+// WithMetricsCredentials supplies the Metrics API key for each request instead of the fixed key from
+// WithMetricsAPIKey.
+func (b *PromotedDeliveryClientBuilder) WithMetricsCredentials(credentials CredentialsProvider) *PromotedDeliveryClientBuilder {
+	b.metricsCredentials = credentials
+	return b
+}
+
+// WithDeliveryHTTPOptions sets the HTTP client, transport and connection pool settings of the Delivery API
+// client. The defaults keep DefaultMaxIdleConnsPerHost idle connections per host.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryHTTPOptions(options HTTPOptions) *PromotedDeliveryClientBuilder {
+	b.deliveryHTTPOptions = options
+	return b
+}
+
+// WithMetricsHTTPOptions sets the HTTP client, transport and connection pool settings of the Metrics API client.
+func (b *PromotedDeliveryClientBuilder) WithMetricsHTTPOptions(options HTTPOptions) *PromotedDeliveryClientBuilder {
+	b.metricsHTTPOptions = options
+	return b
+}
+
+// WithDeliveryHTTPClient makes the Delivery API client send requests with httpClient, which is used as is.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryHTTPClient(httpClient *http.Client) *PromotedDeliveryClientBuilder {
+	b.deliveryHTTPOptions.Client = httpClient
+	return b
+}
+
+// WithMetricsHTTPClient makes the Metrics API client send requests with httpClient, which is used as is.
+func (b *PromotedDeliveryClientBuilder) WithMetricsHTTPClient(httpClient *http.Client) *PromotedDeliveryClientBuilder {
+	b.metricsHTTPOptions.Client = httpClient
+	return b
+}
+
+// WithDeliveryTransport makes the Delivery API client send requests with transport. See HTTPOptions.Transport.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryTransport(transport http.RoundTripper) *PromotedDeliveryClientBuilder {
+	b.deliveryHTTPOptions.Transport = transport
+	return b
+}
+
+// WithMetricsTransport makes the Metrics API client send requests with transport. See HTTPOptions.Transport.
+func (b *PromotedDeliveryClientBuilder) WithMetricsTransport(transport http.RoundTripper) *PromotedDeliveryClientBuilder {
+	b.metricsHTTPOptions.Transport = transport
+	return b
+}
+
+// WithDeliveryTLS sets the client certificate, CAs and server name of the Delivery API connections.
+func (b *PromotedDeliveryClientBuilder) WithDeliveryTLS(options TLSOptions) *PromotedDeliveryClientBuilder {
+	b.deliveryHTTPOptions.TLS = &options
+	return b
+}
+
+// WithMetricsTLS sets the client certificate, CAs and server name of the Metrics API connections.
+func (b *PromotedDeliveryClientBuilder) WithMetricsTLS(options TLSOptions) *PromotedDeliveryClientBuilder {
+	b.metricsHTTPOptions.TLS = &options
+	return b
+}
+
+// WithSDKOnly makes the client rank every request with SDK delivery and never call the Delivery API, for example
+// in local development. The Delivery API endpoint and key are then optional. The Metrics API endpoint is also
+// optional, and nothing is logged without it.
+func (b *PromotedDeliveryClientBuilder) WithSDKOnly(sdkOnly bool) *PromotedDeliveryClientBuilder {
+	b.sdkOnly = sdkOnly
+	return b
+}
+
+// Build validates the configuration and creates the client. It returns every problem found as one error.
+// The APIFactory defaults to DefaultAPIFactory.
 func (b *PromotedDeliveryClientBuilder) Build() (*PromotedDeliveryClient, error) {
+	if err := b.validate(); err != nil {
+		return nil, err
+	}
+
+	if b.apiFactory == nil {
+		b.apiFactory = &DefaultAPIFactory{}
+	}
+
 	if b.deliveryTimeoutMillis <= 0 {
 		b.deliveryTimeoutMillis = defaultDeliveryTimeoutMillis
 	}
@@ -114,30 +291,88 @@ func (b *PromotedDeliveryClientBuilder) Build() (*PromotedDeliveryClient, error)
 		b.maxRequestInsertions = defaultMaxRequestInsertions
 	}
 
-	if b.shadowTrafficDeliveryRate < 0 || b.shadowTrafficDeliveryRate > 1 {
-		return nil, errors.New("shadowTrafficDeliveryRate must be between 0 and 1")
+	// HTTP options and credentials are applied through an HTTPAPIFactory. Other factories create their own clients.
+	httpFactory, _ := b.apiFactory.(HTTPAPIFactory)
+	if httpFactory == nil && (!b.deliveryHTTPOptions.isZero() || !b.metricsHTTPOptions.isZero()) {
+		return nil, fmt.Errorf("HTTP options require an HTTPAPIFactory, got %T", b.apiFactory)
+	}
+	if httpFactory == nil && (b.deliveryCredentials != nil || b.metricsCredentials != nil) {
+		return nil, fmt.Errorf("credentials providers require an HTTPAPIFactory, got %T", b.apiFactory)
+	}
+	if httpFactory == nil && b.requestCompression.Encoding != CompressionNone {
+		return nil, fmt.Errorf("requestCompression requires an HTTPAPIFactory, got %T", b.apiFactory)
+	}
+
+	var deliveryAPI DeliveryAPI
+	if !b.sdkOnly {
+		if httpFactory != nil {
+			httpClient, err := newAPIHTTPClient(b.deliveryHTTPOptions, b.deliveryTimeoutMillis, b.deliveryCredentials, b.requestCompression)
+			if err != nil {
+				return nil, err
+			}
+			deliveryAPI = httpFactory.CreateDeliveryAPIWithHTTPClient(
+				httpClient,
+				b.deliveryEndpoint,
+				b.deliveryAPIKey,
+				b.deliveryTimeoutMillis,
+				b.maxRequestInsertions,
+				b.acceptsGzip,
+				b.warmup,
+			)
+		} else {
+			deliveryAPI = b.apiFactory.CreateDeliveryAPI(
+				b.deliveryEndpoint,
+				b.deliveryAPIKey,
+				b.deliveryTimeoutMillis,
+				b.maxRequestInsertions,
+				b.acceptsGzip,
+				b.warmup,
+			)
+		}
 	}
 
-	deliveryAPI := b.apiFactory.CreateDeliveryAPI(
-		b.deliveryEndpoint,
-		b.deliveryAPIKey,
-		b.deliveryTimeoutMillis,
-		b.maxRequestInsertions,
-		b.acceptsGzip,
-		b.warmup,
-	)
+	var metricsAPI MetricsAPI
+	if b.metricsEndpoint != "" {
+		if httpFactory != nil {
+			httpClient, err := newAPIHTTPClient(b.metricsHTTPOptions, b.metricsTimeoutMillis, b.metricsCredentials, b.requestCompression)
+			if err != nil {
+				return nil, err
+			}
+			metricsAPI = httpFactory.CreateMetricsAPIWithHTTPClient(
+				httpClient,
+				b.metricsEndpoint,
+				b.metricsAPIKey,
+				b.metricsTimeoutMillis,
+			)
+		} else {
+			metricsAPI = b.apiFactory.CreateMetricsAPI(
+				b.metricsEndpoint,
+				b.metricsAPIKey,
+				b.metricsTimeoutMillis,
+			)
+		}
+	}
+
+	var healthChecker HealthChecker
+	if b.keepAliveInterval > 0 {
+		var ok bool
+		if healthChecker, ok = deliveryAPI.(HealthChecker); !ok {
+			return nil, errors.New("keepAliveInterval requires a Delivery API with a HealthCheck method")
+		}
+	}
 
-	metricsAPI := b.apiFactory.CreateMetricsAPI(
-		b.metricsEndpoint,
-		b.metricsAPIKey,
-		b.metricsTimeoutMillis,
-	)
+	if deliveryAPI != nil && len(b.deliveryAPIInterceptors) > 0 {
+		deliveryAPI = newInterceptedDeliveryAPI(deliveryAPI, b.deliveryAPIInterceptors)
+	}
+	if metricsAPI != nil && len(b.metricsInterceptors) > 0 {
+		metricsAPI = newInterceptedMetricsAPI(metricsAPI, b.metricsInterceptors)
+	}
 
 	if b.sampler == nil {
 		b.sampler = NewDefaultSampler()
 	}
 
-	return &PromotedDeliveryClient{
+	client := &PromotedDeliveryClient{
 		deliveryAPI:               deliveryAPI,
 		metricsAPI:                metricsAPI,
 		sdkDelivery:               b.apiFactory.CreateSDKDelivery(),
@@ -151,7 +386,114 @@ func (b *PromotedDeliveryClientBuilder) Build() (*PromotedDeliveryClient, error)
 		applyTreatmentChecker:     b.applyTreatmentChecker,
 		shadowTrafficDeliveryRate: b.shadowTrafficDeliveryRate,
 		sampler:                   b.sampler,
-		performChecks:             b.performChecks,
+		validationMode:            b.validationMode,
+		defaultValidator:          NewRuleValidator(DefaultValidationRulesFor(b.maxRequestInsertions)...),
 		blockingShadowTraffic:     b.blockingShadowTraffic,
-	}, nil
+		sdkOnly:                   b.sdkOnly,
+		deliverTimeout:            b.deliverTimeout,
+		fallbackReserve:           b.fallbackReserve,
+		planLogging:               b.planLogging,
+		done:                      make(chan struct{}),
+	}
+	if len(b.deliverInterceptors) > 0 {
+		client.deliverHandler = chainDeliverInterceptors(b.deliverInterceptors, client.deliver)
+	}
+	if healthChecker != nil {
+		client.startKeepAlive(healthChecker, b.keepAliveInterval)
+	}
+	return client, nil
+}
+
+// newAPIHTTPClient creates an API's HTTP client, compressing its requests and authenticating them with credentials
+// if set. Compression sits below the credentials retry, so a retried request is compressed again.
+func newAPIHTTPClient(options HTTPOptions, timeoutMillis int64, credentials CredentialsProvider, compression RequestCompression) (*http.Client, error) {
+	httpClient, err := options.NewClient(time.Duration(timeoutMillis) * time.Millisecond)
+	if err != nil {
+		return nil, err
+	}
+	httpClient = withCompression(httpClient, compression)
+	if credentials != nil {
+		httpClient = withCredentials(httpClient, credentials)
+	}
+	return httpClient, nil
+}
+
+// validate returns every configuration problem as one error. API keys are never included in the messages.
+func (b *PromotedDeliveryClientBuilder) validate() error {
+	var errs []error
+	if !b.sdkOnly {
+		errs = append(errs, checkEndpoint("deliveryEndpoint", b.deliveryEndpoint))
+		if b.deliveryAPIKey == "" && b.deliveryCredentials == nil {
+			errs = append(errs, errors.New("deliveryAPIKey or deliveryCredentials must be set unless WithSDKOnly(true) is used"))
+		}
+	} else if b.deliveryEndpoint != "" {
+		errs = append(errs, checkEndpoint("deliveryEndpoint", b.deliveryEndpoint))
+	}
+
+	if !b.sdkOnly || b.metricsEndpoint != "" {
+		errs = append(errs, checkEndpoint("metricsEndpoint", b.metricsEndpoint))
+	}
+	if !b.sdkOnly && b.metricsAPIKey == "" && b.metricsCredentials == nil {
+		errs = append(errs, errors.New("metricsAPIKey or metricsCredentials must be set unless WithSDKOnly(true) is used"))
+	}
+	if b.deliveryAPIKey != "" && b.deliveryCredentials != nil {
+		errs = append(errs, errors.New("deliveryAPIKey and deliveryCredentials must not both be set"))
+	}
+	if b.metricsAPIKey != "" && b.metricsCredentials != nil {
+		errs = append(errs, errors.New("metricsAPIKey and metricsCredentials must not both be set"))
+	}
+
+	if b.shadowTrafficDeliveryRate < 0 || b.shadowTrafficDeliveryRate > 1 {
+		errs = append(errs, errors.New("shadowTrafficDeliveryRate must be between 0 and 1"))
+	} else if b.sdkOnly && b.shadowTrafficDeliveryRate > 0 {
+		errs = append(errs, errors.New("shadowTrafficDeliveryRate must be 0 with WithSDKOnly(true), which does not call the Delivery API"))
+	}
+
+	if b.validationMode < ValidationModeOff || b.validationMode > ValidationModeStrict {
+		errs = append(errs, fmt.Errorf("unknown validationMode %d", b.validationMode))
+	}
+
+	errs = append(errs, b.requestCompression.validate()...)
+	errs = append(errs, b.deliveryHTTPOptions.validate("deliveryHTTPOptions")...)
+	errs = append(errs, b.metricsHTTPOptions.validate("metricsHTTPOptions")...)
+
+	if b.deliverTimeout < 0 {
+		errs = append(errs, errors.New("deliverTimeout must not be negative"))
+	}
+	if b.fallbackReserve < 0 {
+		errs = append(errs, errors.New("fallbackReserve must not be negative"))
+	} else if b.deliverTimeout > 0 && b.fallbackReserve >= b.deliverTimeout {
+		errs = append(errs, fmt.Errorf("fallbackReserve %s must be shorter than deliverTimeout %s", b.fallbackReserve, b.deliverTimeout))
+	}
+
+	if b.keepAliveInterval < 0 {
+		errs = append(errs, errors.New("keepAliveInterval must not be negative"))
+	} else if b.sdkOnly && b.keepAliveInterval > 0 {
+		errs = append(errs, errors.New("keepAliveInterval must be 0 with WithSDKOnly(true), which does not call the Delivery API"))
+	}
+	return errors.Join(errs...)
+}
+
+// checkEndpoint returns an error unless the endpoint is an absolute http or https URL with a host.
+func checkEndpoint(name, endpoint string) error {
+	if endpoint == "" {
+		return fmt.Errorf("%s must be set", name)
+	}
+	// Endpoints may hold credentials as user:password@, so errors show the redacted URL, and parse errors only
+	// their cause, which url.Error would otherwise quote with the whole endpoint.
+	uri, err := url.Parse(endpoint)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s is not a valid URL: %v", name, err)
+	}
+	if uri.Scheme != "http" && uri.Scheme != "https" {
+		return fmt.Errorf("%s %q must use http or https", name, uri.Redacted())
+	}
+	if uri.Host == "" {
+		return fmt.Errorf("%s %q must include a host", name, uri.Redacted())
+	}
+	return nil
 }
@@ -1,15 +1,109 @@
 package delivery
 
+import (
+	"fmt"
+	"strings"
+
+	"github.com/promotedai/schema/generated/go/proto/event"
+)
+
 // DeliveryPlan represents a plan object that indicates how to execute delivery.
 type DeliveryPlan struct {
 	ClientRequestID string
 	UseAPIResponse  bool
+
+	// Reason is why the plan does or doesn't use the Delivery API response.
+	Reason PlanReason
+
+	// OnlyLog, SDKOnly, Experiment and CustomTreatmentChecker are the inputs the decision was made from.
+	// Experiment is the request's cohort membership, which may be nil.
+	OnlyLog                bool
+	SDKOnly                bool
+	Experiment             *event.CohortMembership
+	CustomTreatmentChecker bool
 }
 
-// NewDeliveryPlan is a factory method for DeliveryPlan.
+// NewDeliveryPlan is a factory method for DeliveryPlan. Its Reason is PlanReasonUnknown.
 func NewDeliveryPlan(clientRequestID string, useAPIResponse bool) *DeliveryPlan {
 	return &DeliveryPlan{
 		ClientRequestID: clientRequestID,
 		UseAPIResponse:  useAPIResponse,
 	}
 }
+
+// String formats the plan as key=value pairs for logs.
+func (p *DeliveryPlan) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "client_request_id=%s use_api_response=%t plan_reason=%s only_log=%t sdk_only=%t",
+		p.ClientRequestID, p.UseAPIResponse, p.Reason, p.OnlyLog, p.SDKOnly)
+	if p.Experiment != nil {
+		fmt.Fprintf(&b, " cohort_id=%q arm=%s", p.Experiment.GetCohortId(), p.Experiment.GetArm())
+	}
+	fmt.Fprintf(&b, " custom_treatment_checker=%t", p.CustomTreatmentChecker)
+	return b.String()
+}
+
+// PlanReason is why a DeliveryPlan does or doesn't use the Delivery API response.
+type PlanReason int
+
+const (
+	// PlanReasonUnknown is the reason of plans made with NewDeliveryPlan.
+	PlanReasonUnknown PlanReason = iota
+
+	// PlanReasonOnlyLog means the request set OnlyLog, so it was delivered by the SDK.
+	PlanReasonOnlyLog
+
+	// PlanReasonSDKOnly means the client was built with WithSDKOnly(true), so it was delivered by the SDK.
+	PlanReasonSDKOnly
+
+	// PlanReasonNoExperiment means the request has no experiment, so it uses the Delivery API.
+	PlanReasonNoExperiment
+
+	// PlanReasonTreatmentArm means the request is in an experiment's treatment arm, so it uses the Delivery API.
+	PlanReasonTreatmentArm
+
+	// PlanReasonControlArm means the request is in an experiment's control arm, so it was delivered by the SDK.
+	PlanReasonControlArm
+
+	// PlanReasonTreatmentCheckerApplied means the ApplyTreatmentChecker applied treatment, so the request uses
+	// the Delivery API.
+	PlanReasonTreatmentCheckerApplied
+
+	// PlanReasonTreatmentCheckerDeclined means the ApplyTreatmentChecker did not apply treatment, so the request
+	// was delivered by the SDK.
+	PlanReasonTreatmentCheckerDeclined
+)
+
+// String returns the reason in snake case, for logs.
+func (r PlanReason) String() string {
+	switch r {
+	case PlanReasonUnknown:
+		return "unknown"
+	case PlanReasonOnlyLog:
+		return "only_log"
+	case PlanReasonSDKOnly:
+		return "sdk_only"
+	case PlanReasonNoExperiment:
+		return "no_experiment"
+	case PlanReasonTreatmentArm:
+		return "treatment_arm"
+	case PlanReasonControlArm:
+		return "control_arm"
+	case PlanReasonTreatmentCheckerApplied:
+		return "treatment_checker_applied"
+	case PlanReasonTreatmentCheckerDeclined:
+		return "treatment_checker_declined"
+	default:
+		return fmt.Sprintf("PlanReason(%d)", int(r))
+	}
+}
+
+// UsesAPI returns whether plans with the reason use the Delivery API response.
+func (r PlanReason) UsesAPI() bool {
+	switch r {
+	case PlanReasonNoExperiment, PlanReasonTreatmentArm, PlanReasonTreatmentCheckerApplied:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,127 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// treatmentCheckerFunc is an ApplyTreatmentChecker that calls the function.
+type treatmentCheckerFunc func(cohortMembership *event.CohortMembership) bool
+
+func (f treatmentCheckerFunc) ShouldApplyTreatment(cohortMembership *event.CohortMembership) bool {
+	return f(cohortMembership)
+}
+
+func TestPlanReasons(t *testing.T) {
+	control := &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL}
+	treatment := &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_TREATMENT}
+	checker := treatmentCheckerFunc(func(cohortMembership *event.CohortMembership) bool {
+		return cohortMembership.GetCohortId() == "applied"
+	})
+
+	tests := []struct {
+		name       string
+		builder    *PromotedDeliveryClientBuilder
+		onlyLog    bool
+		experiment *event.CohortMembership
+		want       PlanReason
+	}{
+		{"only log", newTestClientBuilder(), true, treatment, PlanReasonOnlyLog},
+		{"sdk only", NewPromotedDeliveryClientBuilder().WithSDKOnly(true), false, treatment, PlanReasonSDKOnly},
+		{"no experiment", newTestClientBuilder(), false, nil, PlanReasonNoExperiment},
+		{"treatment", newTestClientBuilder(), false, treatment, PlanReasonTreatmentArm},
+		{"control", newTestClientBuilder(), false, control, PlanReasonControlArm},
+		{"checker applied", newTestClientBuilder().WithApplyTreatmentChecker(checker), false, &event.CohortMembership{CohortId: "applied"}, PlanReasonTreatmentCheckerApplied},
+		{"checker declined", newTestClientBuilder().WithApplyTreatmentChecker(checker), false, treatment, PlanReasonTreatmentCheckerDeclined},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := tc.builder.Build()
+			assert.NoError(t, err)
+			plan := client.Plan(tc.onlyLog, tc.experiment)
+			assert.Equal(t, tc.want, plan.Reason)
+			assert.Equal(t, tc.want.UsesAPI(), plan.UseAPIResponse)
+			assert.Equal(t, tc.onlyLog, plan.OnlyLog)
+			assert.Equal(t, tc.experiment, plan.Experiment)
+		})
+	}
+}
+
+func TestPlanSkipsTreatmentCheckerWhenOnlyLogging(t *testing.T) {
+	called := false
+	client, err := newTestClientBuilder().
+		WithApplyTreatmentChecker(treatmentCheckerFunc(func(*event.CohortMembership) bool {
+			called = true
+			return true
+		})).
+		Build()
+	assert.NoError(t, err)
+	plan := client.Plan(true, nil)
+	assert.Equal(t, PlanReasonOnlyLog, plan.Reason)
+	assert.True(t, plan.CustomTreatmentChecker)
+	assert.False(t, called)
+}
+
+func TestDeliveryPlanString(t *testing.T) {
+	plan := &DeliveryPlan{
+		ClientRequestID: "request-1",
+		Reason:          PlanReasonControlArm,
+		Experiment:      &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL},
+	}
+	assert.Equal(t, `client_request_id=request-1 use_api_response=false plan_reason=control_arm only_log=false `+
+		`sdk_only=false cohort_id="exp" arm=CONTROL custom_treatment_checker=false`, plan.String())
+	assert.Equal(t, PlanReasonUnknown, NewDeliveryPlan("request-2", true).Reason)
+	assert.Equal(t, "PlanReason(99)", PlanReason(99).String())
+}
+
+func TestDeliverReportsPlanReason(t *testing.T) {
+	logs := captureLog(t)
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		return nil, errors.New("unavailable")
+	})
+	client := newDeadlineTestClient(t, api, 0, 0)
+
+	req := newDeadlineTestRequest()
+	req.Experiment = &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL}
+	resp, err := client.Deliver(req)
+	assert.NoError(t, err)
+	assert.Equal(t, PlanReasonControlArm, resp.PlanReason)
+	assert.Equal(t, FallbackReasonNone, resp.FallbackReason)
+	assert.Empty(t, logs.String())
+
+	resp, err = client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, PlanReasonNoExperiment, resp.PlanReason)
+	assert.Equal(t, FallbackReasonError, resp.FallbackReason)
+	assert.Contains(t, logs.String(), "Error calling Delivery API, falling back: client_request_id="+resp.ClientRequestID+
+		" use_api_response=true plan_reason=no_experiment only_log=false sdk_only=false custom_treatment_checker=false"+
+		" fallback_reason=error error=\"unavailable\"")
+}
+
+func TestDeliverLogsPlanWithPlanLogging(t *testing.T) {
+	logs := captureLog(t)
+	api := contextDeliveryFunc(func(ctx context.Context, req *DeliveryRequest) (*delivery.Response, error) {
+		return &delivery.Response{}, nil
+	})
+	client := newDeadlineTestClient(t, api, 0, 0)
+
+	req := newDeadlineTestRequest()
+	req.Experiment = &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL}
+	_, err := client.Deliver(req)
+	assert.NoError(t, err)
+	assert.Empty(t, logs.String())
+
+	client.planLogging = true
+	req = newDeadlineTestRequest()
+	req.Experiment = &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL}
+	resp, err := client.Deliver(req)
+	assert.NoError(t, err)
+	assert.Contains(t, logs.String(), "Delivery plan: client_request_id="+resp.ClientRequestID+
+		" use_api_response=false plan_reason=control_arm only_log=false sdk_only=false cohort_id=\"exp\" arm=CONTROL"+
+		" custom_treatment_checker=false execution_server=SDK fallback_reason=none")
+}
@@ -77,3 +77,29 @@ func (d *DeliveryRequest) Validate() []string {
 	}
 	return nil
 }
+
+// ValidateIssues checks the state of the DeliveryRequest and returns any validation issues with their
+// field paths and rule codes. Requests created without NewDeliveryRequest use the default validator.
+func (d *DeliveryRequest) ValidateIssues() []ValidationIssue {
+	return d.validateIssues(&DefaultDeliveryRequestValidator{})
+}
+
+// validateIssues is ValidateIssues with defaultValidator used in place of the default validator, for requests
+// without a custom one.
+func (d *DeliveryRequest) validateIssues(defaultValidator DeliveryRequestValidator) []ValidationIssue {
+	if d == nil {
+		return []ValidationIssue{{Field: "deliveryRequest", Rule: RuleRequestRequired, Message: "DeliveryRequest is nil"}}
+	}
+	validator := d.validator
+	if _, ok := validator.(*DefaultDeliveryRequestValidator); ok || validator == nil {
+		validator = defaultValidator
+	}
+	if issueValidator, ok := validator.(IssueValidator); ok {
+		return issueValidator.ValidateIssues(d)
+	}
+	var issues []ValidationIssue
+	for _, message := range validator.Validate(d) {
+		issues = append(issues, ValidationIssue{Rule: RuleCustom, Message: message})
+	}
+	return issues
+}
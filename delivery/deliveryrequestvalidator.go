@@ -1,61 +1,146 @@
 package delivery
 
-import (
-	"github.com/promotedai/schema/generated/go/proto/delivery"
-)
-
-// DeliveryRequestValidator performs validation on delivery requests during a deliver call when performChecks is true in the client.
+// DeliveryRequestValidator performs validation on delivery requests during a deliver call when validation is enabled in the client.
 type DeliveryRequestValidator interface {
 	// Validate checks the state of the delivery request and collects/returns any validation errors as strings.
 	Validate(request *DeliveryRequest) []string
 }
 
-// DefaultDeliveryRequestValidator implements the default delivery request validation logic.
-type DefaultDeliveryRequestValidator struct{}
+// IssueValidator is implemented by validators that report structured issues with a field path and rule code.
+// Validators that only implement DeliveryRequestValidator have their messages reported under RuleCustom.
+type IssueValidator interface {
+	// ValidateIssues checks the state of the delivery request and returns any validation issues.
+	ValidateIssues(request *DeliveryRequest) []ValidationIssue
+}
 
-// Validate performs validation on the DeliveryRequest and returns any validation errors.
-func (v *DefaultDeliveryRequestValidator) Validate(request *DeliveryRequest) []string {
-	if request == nil {
-		return []string{"DeliveryRequest is nil"}
-	}
+// ValidationRule is a single named check run by a RuleValidator. Rules are only run on requests with a non-nil Request.
+type ValidationRule interface {
+	// Name is the rule code reported on the issues the rule produces.
+	Name() string
+
+	// Check returns the issues found on the request.
+	Check(request *DeliveryRequest) []ValidationIssue
+}
+
+// funcValidationRule adapts a function into a ValidationRule.
+type funcValidationRule struct {
+	name  string
+	check func(request *DeliveryRequest) []ValidationIssue
+}
+
+// NewValidationRule creates a named ValidationRule from a check function. Issues returned without a Rule
+// are reported under the rule's name.
+func NewValidationRule(name string, check func(request *DeliveryRequest) []ValidationIssue) ValidationRule {
+	return &funcValidationRule{name: name, check: check}
+}
+
+// Name returns the rule name.
+func (r *funcValidationRule) Name() string {
+	return r.name
+}
 
-	var validationErrors []string
+// Check runs the check function.
+func (r *funcValidationRule) Check(request *DeliveryRequest) []ValidationIssue {
+	return r.check(request)
+}
+
+// RuleValidator validates delivery requests with an ordered list of named rules that can be enabled, disabled or added to.
+// It is not safe to modify the rules while the validator is in use.
+type RuleValidator struct {
+	rules    []ValidationRule
+	disabled map[string]bool
+}
 
-	reqBuilder := request.Request
-	if reqBuilder == nil {
-		validationErrors = append(validationErrors, "Request builder must be set")
-		return validationErrors
+// NewRuleValidator creates a validator that runs the given rules in order.
+func NewRuleValidator(rules ...ValidationRule) *RuleValidator {
+	return &RuleValidator{
+		rules:    append([]ValidationRule(nil), rules...),
+		disabled: map[string]bool{},
 	}
+}
 
-	// Check the IDs.
-	validationErrors = append(validationErrors, v.validateIDs(request.Request)...)
+// NewDefaultRuleValidator creates a validator with DefaultValidationRules.
+func NewDefaultRuleValidator() *RuleValidator {
+	return NewRuleValidator(DefaultValidationRules()...)
+}
 
-	// Insertion start should be >= 0.
-	if request.RetrievalInsertionOffset < 0 {
-		validationErrors = append(validationErrors, "Insertion start must be greater or equal to 0")
+// Add appends a rule, replacing any existing rule with the same name in place.
+func (v *RuleValidator) Add(rule ValidationRule) *RuleValidator {
+	for i, existing := range v.rules {
+		if existing.Name() == rule.Name() {
+			v.rules[i] = rule
+			return v
+		}
 	}
+	v.rules = append(v.rules, rule)
+	return v
+}
 
-	return validationErrors
+// Enable turns a previously disabled rule back on.
+func (v *RuleValidator) Enable(name string) *RuleValidator {
+	delete(v.disabled, name)
+	return v
 }
 
-func (v *DefaultDeliveryRequestValidator) validateIDs(req *delivery.Request) []string {
-	var validationErrors []string
+// Disable turns off the named rule.
+func (v *RuleValidator) Disable(name string) *RuleValidator {
+	v.disabled[name] = true
+	return v
+}
 
-	if req.RequestId != "" {
-		validationErrors = append(validationErrors, "Request.requestID should not be set")
+// RuleNames returns the names of the enabled rules in the order they run.
+func (v *RuleValidator) RuleNames() []string {
+	var names []string
+	for _, rule := range v.rules {
+		if !v.disabled[rule.Name()] {
+			names = append(names, rule.Name())
+		}
 	}
+	return names
+}
 
-	if req.UserInfo == nil {
-		validationErrors = append(validationErrors, "Request.userInfo should be set")
-	} else if req.GetUserInfo().GetAnonUserId() == "" {
-		validationErrors = append(validationErrors, "Request.userInfo.anonUserID should be set")
+// Validate performs validation on the DeliveryRequest and returns any validation errors.
+func (v *RuleValidator) Validate(request *DeliveryRequest) []string {
+	return issueMessages(v.ValidateIssues(request))
+}
+
+// ValidateIssues runs the enabled rules on the DeliveryRequest and returns any validation issues.
+func (v *RuleValidator) ValidateIssues(request *DeliveryRequest) []ValidationIssue {
+	if request == nil {
+		return []ValidationIssue{{Field: "deliveryRequest", Rule: RuleRequestRequired, Message: "DeliveryRequest is nil"}}
+	}
+	if request.Request == nil {
+		return []ValidationIssue{{Field: "request", Rule: RuleRequestRequired, Message: "Request builder must be set"}}
 	}
 
-	for _, ins := range req.Insertion {
-		if ins.ContentId == "" {
-			validationErrors = append(validationErrors, "Insertion.contentID should be set")
+	var issues []ValidationIssue
+	for _, rule := range v.rules {
+		if v.disabled[rule.Name()] {
+			continue
+		}
+		for _, issue := range rule.Check(request) {
+			if issue.Rule == "" {
+				issue.Rule = rule.Name()
+			}
+			issues = append(issues, issue)
 		}
 	}
+	return issues
+}
+
+// defaultRuleValidator backs DefaultDeliveryRequestValidator.
+var defaultRuleValidator = NewDefaultRuleValidator()
+
+// DefaultDeliveryRequestValidator implements the default delivery request validation logic, running DefaultValidationRules.
+// Use a RuleValidator to enable, disable or add rules.
+type DefaultDeliveryRequestValidator struct{}
+
+// Validate performs validation on the DeliveryRequest and returns any validation errors.
+func (v *DefaultDeliveryRequestValidator) Validate(request *DeliveryRequest) []string {
+	return defaultRuleValidator.Validate(request)
+}
 
-	return validationErrors
+// ValidateIssues performs validation on the DeliveryRequest and returns any validation issues.
+func (v *DefaultDeliveryRequestValidator) ValidateIssues(request *DeliveryRequest) []ValidationIssue {
+	return defaultRuleValidator.ValidateIssues(request)
 }
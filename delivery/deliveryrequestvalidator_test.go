@@ -0,0 +1,251 @@
+package delivery
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type ruleTestCase struct {
+	name   string
+	modify func(req *DeliveryRequest)
+	fields []string
+}
+
+func TestValidationRules(t *testing.T) {
+	now := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+	bigString := strings.Repeat("x", 2048)
+
+	tests := map[string][]ruleTestCase{
+		RuleRequestIDUnset: {
+			{name: "unset", modify: func(req *DeliveryRequest) {}},
+			{name: "set", modify: func(req *DeliveryRequest) { req.Request.RequestId = "r" }, fields: []string{"request.requestId"}},
+		},
+		RuleUserInfoRequired: {
+			{name: "set", modify: func(req *DeliveryRequest) {}},
+			{name: "missing", modify: func(req *DeliveryRequest) { req.Request.UserInfo = nil }, fields: []string{"request.userInfo"}},
+		},
+		RuleAnonUserIDRequired: {
+			{name: "set", modify: func(req *DeliveryRequest) {}},
+			{name: "no user info", modify: func(req *DeliveryRequest) { req.Request.UserInfo = nil }},
+			{name: "empty", modify: func(req *DeliveryRequest) { req.Request.UserInfo.AnonUserId = "" }, fields: []string{"request.userInfo.anonUserId"}},
+		},
+		RuleContentIDRequired: {
+			{name: "all set", modify: func(req *DeliveryRequest) {}},
+			{name: "one missing", modify: func(req *DeliveryRequest) { req.Request.Insertion[2].ContentId = "" }, fields: []string{"request.insertion[2].contentId"}},
+		},
+		RuleNonNegativeOffset: {
+			{name: "zero", modify: func(req *DeliveryRequest) {}},
+			{name: "negative", modify: func(req *DeliveryRequest) { req.RetrievalInsertionOffset = -1 }, fields: []string{"retrievalInsertionOffset"}},
+		},
+		RuleDuplicateContentID: {
+			{name: "unique", modify: func(req *DeliveryRequest) {}},
+			{name: "empty ids are not duplicates", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion[0].ContentId = ""
+				req.Request.Insertion[1].ContentId = ""
+			}},
+			{name: "duplicates", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion[3].ContentId = "0"
+				req.Request.Insertion[4].ContentId = "0"
+			}, fields: []string{"request.insertion[3].contentId", "request.insertion[4].contentId"}},
+		},
+		RulePagingSize: {
+			{name: "no paging", modify: func(req *DeliveryRequest) { req.Request.Paging = nil }},
+			{name: "positive", modify: func(req *DeliveryRequest) {}},
+			{name: "equal to insertions", modify: func(req *DeliveryRequest) { req.Request.Paging.Size = 5 }},
+			{name: "larger than insertions", modify: func(req *DeliveryRequest) { req.Request.Paging.Size = 100 }, fields: []string{"request.paging.size"}},
+			{name: "larger than insertions when only logging", modify: func(req *DeliveryRequest) {
+				req.OnlyLog = true
+				req.Request.Paging.Size = 100
+			}},
+			{name: "no insertions", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion = nil
+				req.Request.Paging.Size = 100
+			}},
+			{name: "negative", modify: func(req *DeliveryRequest) { req.Request.Paging.Size = -1 }, fields: []string{"request.paging.size"}},
+		},
+		RulePagingOffset: {
+			{name: "no paging", modify: func(req *DeliveryRequest) { req.Request.Paging = nil }},
+			{name: "cursor", modify: func(req *DeliveryRequest) {
+				req.Request.Paging.Starting = &delivery.Paging_Cursor{Cursor: "c"}
+			}},
+			{name: "within insertions", modify: func(req *DeliveryRequest) { req.Request.Paging = NewPaging(2, 4) }},
+			{name: "within retrieval offset", modify: func(req *DeliveryRequest) {
+				req.RetrievalInsertionOffset = 10
+				req.Request.Paging = NewPaging(2, 14)
+			}},
+			{name: "negative", modify: func(req *DeliveryRequest) { req.Request.Paging = NewPaging(2, -1) }, fields: []string{"request.paging.offset"}},
+			{name: "before retrieval offset", modify: func(req *DeliveryRequest) {
+				req.RetrievalInsertionOffset = 10
+				req.Request.Paging = NewPaging(2, 5)
+			}, fields: []string{"request.paging.offset"}},
+			{name: "past insertions", modify: func(req *DeliveryRequest) { req.Request.Paging = NewPaging(2, 5) }, fields: []string{"request.paging.offset"}},
+			{name: "no insertions", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion = nil
+				req.Request.Paging = NewPaging(2, 5)
+			}},
+		},
+		RuleMaxRequestInsertions: {
+			{name: "under limit", modify: func(req *DeliveryRequest) {}},
+			{name: "at limit", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion = CreateTestRequestInsertions(defaultMaxRequestInsertions)
+			}},
+			{name: "over limit", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion = CreateTestRequestInsertions(defaultMaxRequestInsertions + 1)
+			}, fields: []string{"request.insertion"}},
+		},
+		RuleUseCase: {
+			{name: "unknown use case", modify: func(req *DeliveryRequest) { req.Request.UseCase = delivery.UseCase_UNKNOWN_USE_CASE }},
+			{name: "search", modify: func(req *DeliveryRequest) { req.Request.UseCase = delivery.UseCase_SEARCH }},
+			{name: "out of range", modify: func(req *DeliveryRequest) { req.Request.UseCase = delivery.UseCase(999) }, fields: []string{"request.useCase"}},
+		},
+		RulePlatformID: {
+			{name: "unset on insertions", modify: func(req *DeliveryRequest) { req.Request.PlatformId = 5 }},
+			{name: "matches request", modify: func(req *DeliveryRequest) {
+				req.Request.PlatformId = 5
+				req.Request.Insertion[0].PlatformId = 5
+			}},
+			{name: "differs from request", modify: func(req *DeliveryRequest) {
+				req.Request.PlatformId = 5
+				req.Request.Insertion[1].PlatformId = 6
+			}, fields: []string{"request.insertion[1].platformId"}},
+		},
+		RulePropertiesSize: {
+			{name: "small", modify: func(req *DeliveryRequest) {
+				req.Request.Properties = testProperties(t, 1, "small")
+				req.Request.Insertion[0].Properties = testProperties(t, 1, "small")
+			}},
+			{name: "large request properties", modify: func(req *DeliveryRequest) {
+				req.Request.Properties = testProperties(t, 40, bigString)
+			}, fields: []string{"request.properties"}},
+			{name: "large insertion properties", modify: func(req *DeliveryRequest) {
+				req.Request.Insertion[4].Properties = testProperties(t, 40, bigString)
+			}, fields: []string{"request.insertion[4].properties"}},
+		},
+		RuleInsertionIDUnset: {
+			{name: "unset", modify: func(req *DeliveryRequest) {}},
+			{name: "set", modify: func(req *DeliveryRequest) { req.Request.Insertion[1].InsertionId = "ins" }, fields: []string{"request.insertion[1].insertionId"}},
+		},
+		RuleTimingTimestampInRange: {
+			{name: "unset", modify: func(req *DeliveryRequest) { req.Request.Timing = nil }},
+			{name: "zero", modify: func(req *DeliveryRequest) { req.Request.Timing = &common.Timing{} }},
+			{name: "now", modify: func(req *DeliveryRequest) {
+				req.Request.Timing = &common.Timing{ClientLogTimestamp: uint64(now.UnixMilli())}
+			}},
+			{name: "seconds instead of millis", modify: func(req *DeliveryRequest) {
+				req.Request.Timing = &common.Timing{ClientLogTimestamp: uint64(now.Unix())}
+			}, fields: []string{"request.timing.clientLogTimestamp"}},
+			{name: "too far in the future", modify: func(req *DeliveryRequest) {
+				req.Request.Timing = &common.Timing{ClientLogTimestamp: uint64(now.Add(48 * time.Hour).UnixMilli())}
+			}, fields: []string{"request.timing.clientLogTimestamp"}},
+		},
+	}
+
+	rules := DefaultValidationRules()
+	rules[len(rules)-1] = NewTimingTimestampRangeRule(func() time.Time { return now })
+	for _, rule := range rules {
+		cases, ok := tests[rule.Name()]
+		assert.True(t, ok, "missing test cases for rule %s", rule.Name())
+		for _, tc := range cases {
+			t.Run(rule.Name()+"/"+tc.name, func(t *testing.T) {
+				req := validTestDeliveryRequest()
+				tc.modify(req)
+				var fields []string
+				for _, issue := range rule.Check(req) {
+					fields = append(fields, issue.Field)
+					assert.NotEmpty(t, issue.Message)
+				}
+				assert.Equal(t, tc.fields, fields)
+			})
+		}
+	}
+}
+
+func TestRuleValidatorValidRequestHasNoIssues(t *testing.T) {
+	assert.Empty(t, NewDefaultRuleValidator().ValidateIssues(validTestDeliveryRequest()))
+}
+
+func TestRuleValidatorStampsRuleName(t *testing.T) {
+	req := validTestDeliveryRequest()
+	req.Request.Insertion[1].InsertionId = "ins"
+	issues := NewDefaultRuleValidator().ValidateIssues(req)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, RuleInsertionIDUnset, issues[0].Rule)
+}
+
+func TestRuleValidatorNilRequests(t *testing.T) {
+	v := NewDefaultRuleValidator()
+	assert.Equal(t, []string{"DeliveryRequest is nil"}, v.Validate(nil))
+	assert.Equal(t, []string{"Request builder must be set"}, v.Validate(&DeliveryRequest{}))
+}
+
+func TestRuleValidatorDisableAndEnable(t *testing.T) {
+	req := validTestDeliveryRequest()
+	req.Request.RequestId = "r"
+	req.Request.Insertion[0].InsertionId = "ins"
+
+	v := NewDefaultRuleValidator().Disable(RuleInsertionIDUnset)
+	assert.NotContains(t, v.RuleNames(), RuleInsertionIDUnset)
+	assert.Equal(t, []string{"Request.requestID should not be set"}, v.Validate(req))
+
+	v.Enable(RuleInsertionIDUnset)
+	assert.Contains(t, v.RuleNames(), RuleInsertionIDUnset)
+	assert.Equal(t, 2, len(v.Validate(req)))
+}
+
+func TestRuleValidatorAddCustomRule(t *testing.T) {
+	v := NewDefaultRuleValidator().Add(NewValidationRule("search_query_required", func(request *DeliveryRequest) []ValidationIssue {
+		if request.Request.SearchQuery != "" {
+			return nil
+		}
+		return []ValidationIssue{{Field: "request.searchQuery", Message: "Request.searchQuery should be set"}}
+	}))
+	assert.Equal(t, "search_query_required", v.RuleNames()[len(v.RuleNames())-1])
+
+	issues := v.ValidateIssues(validTestDeliveryRequest())
+	assert.Equal(t, []ValidationIssue{{Field: "request.searchQuery", Rule: "search_query_required", Message: "Request.searchQuery should be set"}}, issues)
+}
+
+func TestRuleValidatorAddReplacesRuleWithSameName(t *testing.T) {
+	v := NewDefaultRuleValidator().Add(NewMaxRequestInsertionsRule(2))
+	req := validTestDeliveryRequest()
+	issues := v.ValidateIssues(req)
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, RuleMaxRequestInsertions, issues[0].Rule)
+	assert.Equal(t, len(DefaultValidationRules()), len(v.RuleNames()))
+}
+
+func TestRuleValidatorWithDeliveryRequest(t *testing.T) {
+	validator := NewDefaultRuleValidator().Disable(RuleUserInfoRequired)
+	req := NewDeliveryRequest(&delivery.Request{Insertion: CreateTestRequestInsertions(1)}, nil, false, 0, validator)
+	assert.Empty(t, req.Validate())
+}
+
+func validTestDeliveryRequest() *DeliveryRequest {
+	return NewDeliveryRequest(
+		&delivery.Request{
+			UserInfo:  &common.UserInfo{AnonUserId: "a"},
+			Paging:    NewPaging(5, 0),
+			Insertion: CreateTestRequestInsertions(5),
+		},
+		nil,
+		false,
+		0,
+		nil,
+	)
+}
+
+func testProperties(t *testing.T, numFields int, value string) *common.Properties {
+	fields := make(map[string]any, numFields)
+	for i := 0; i < numFields; i++ {
+		fields["field"+strings.Repeat("_", i)] = value
+	}
+	s, err := structpb.NewStruct(fields)
+	assert.NoError(t, err)
+	return &common.Properties{StructField: &common.Properties_Struct{Struct: s}}
+}
@@ -1,6 +1,11 @@
 package delivery
 
 import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
 	"github.com/promotedai/schema/generated/go/proto/delivery"
 )
 
@@ -14,4 +19,107 @@ type DeliveryResponse struct {
 
 	// ExecutionServer indicates if delivery happened in the SDK or via Delivery API.
 	ExecutionServer delivery.ExecutionServer
+
+	// PlanReason is why the Delivery API response was or wasn't planned to be used. SDK delivery after a failed
+	// Delivery API call is reported by FallbackReason instead.
+	PlanReason PlanReason
+
+	// Timing is the time spent in each phase of Deliver.
+	Timing DeliveryTiming
+
+	// Retries is how many times the Delivery API request was sent again, for example with a refreshed API key.
+	Retries int
+
+	// FallbackReason is why SDK delivery was used after a Delivery API call failed. It is FallbackReasonNone when
+	// the Delivery API response was used or the Delivery API was not called.
+	FallbackReason FallbackReason
+}
+
+// FallbackReason is why Deliver fell back to SDK delivery.
+type FallbackReason int
+
+const (
+	// FallbackReasonNone means Deliver did not fall back.
+	FallbackReasonNone FallbackReason = iota
+
+	// FallbackReasonTimeout means the Delivery API call ran out of time.
+	FallbackReasonTimeout
+
+	// FallbackReasonNoTimeLeft means the Delivery API was not called because only the fallback reserve was left
+	// of the Deliver deadline.
+	FallbackReasonNoTimeLeft
+
+	// FallbackReasonError means the Delivery API call failed, for example with a connection error, an error status
+	// or an invalid response.
+	FallbackReasonError
+)
+
+// String returns the reason in snake case, for logs.
+func (r FallbackReason) String() string {
+	switch r {
+	case FallbackReasonNone:
+		return "none"
+	case FallbackReasonTimeout:
+		return "timeout"
+	case FallbackReasonNoTimeLeft:
+		return "no_time_left"
+	case FallbackReasonError:
+		return "error"
+	default:
+		return "unknown"
+	}
+}
+
+// fallbackReasonOf classifies a Delivery API error.
+func fallbackReasonOf(err error) FallbackReason {
+	if errors.Is(err, errNoTimeLeft) {
+		return FallbackReasonNoTimeLeft
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &timeout) && timeout.Timeout()) {
+		return FallbackReasonTimeout
+	}
+	return FallbackReasonError
+}
+
+// DeliveryTiming is the time Deliver spent in each phase.
+type DeliveryTiming struct {
+	// Validation is validating and preparing the request.
+	Validation time.Duration
+
+	// DeliveryAPI is the Delivery API round trip, including a failed call that fell back to SDK delivery. It is
+	// zero when the Delivery API was not called.
+	DeliveryAPI time.Duration
+
+	// Decoding is the part of DeliveryAPI spent unmarshaling the response. It is only reported by Delivery APIs
+	// built by DefaultAPIFactory.
+	Decoding time.Duration
+
+	// SDKDelivery is SDK delivery. It is zero when the Delivery API response was used.
+	SDKDelivery time.Duration
+
+	// Total is the whole Deliver call. Metrics API logging and non-blocking shadow traffic run in the background
+	// and are not included.
+	Total time.Duration
+}
+
+// callStats collects what happened during one Delivery API call from the layers below the client, which find it
+// in the request context.
+type callStats struct {
+	retries  atomic.Int32
+	decoding atomic.Int64
+}
+
+type callStatsKey struct{}
+
+// withCallStats returns a context that collects callStats.
+func withCallStats(ctx context.Context) (context.Context, *callStats) {
+	stats := &callStats{}
+	return context.WithValue(ctx, callStatsKey{}, stats), stats
+}
+
+// callStatsFromContext returns the context's callStats, or nil if it has none.
+func callStatsFromContext(ctx context.Context) *callStats {
+	stats, _ := ctx.Value(callStatsKey{}).(*callStats)
+	return stats
 }
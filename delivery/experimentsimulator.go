@@ -0,0 +1,285 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/google/uuid"
+	"github.com/promotedai/schema/generated/go/proto/event"
+)
+
+// SampleRatioMismatchPValue is the p-value below which a simulation reports a sample ratio mismatch.
+const SampleRatioMismatchPValue = 0.001
+
+// SimulatedArm is the arm a user is assigned to in a simulated experiment.
+type SimulatedArm int
+
+const (
+	// SimulatedArmNone is for users outside the experiment's active buckets.
+	SimulatedArmNone SimulatedArm = iota
+	SimulatedArmControl
+	SimulatedArmTreatment
+)
+
+// numSimulatedArms is the number of SimulatedArm values.
+const numSimulatedArms = 3
+
+// SimulatedArms lists the arms in the order they are reported.
+var SimulatedArms = []SimulatedArm{SimulatedArmControl, SimulatedArmTreatment, SimulatedArmNone}
+
+// String returns the arm's name.
+func (a SimulatedArm) String() string {
+	switch a {
+	case SimulatedArmControl:
+		return "control"
+	case SimulatedArmTreatment:
+		return "treatment"
+	default:
+		return "none"
+	}
+}
+
+// ExperimentSimulation is the result of assigning a list of users to a TwoArmExperiment with CheckMembership.
+type ExperimentSimulation struct {
+	// CohortID of the simulated experiment.
+	CohortID string
+
+	// Users is the number of users assigned.
+	Users int
+
+	// Counts is the number of users in each arm, indexed by SimulatedArm.
+	Counts [numSimulatedArms]int
+
+	// ExpectedShares is the fraction of users each arm should get from the bucket configuration, indexed by
+	// SimulatedArm.
+	ExpectedShares [numSimulatedArms]float64
+
+	// ChiSquare is the chi-square statistic of the counts against the expected shares.
+	ChiSquare float64
+
+	// DegreesOfFreedom of the chi-square test, one less than the number of arms that can receive users.
+	DegreesOfFreedom int
+
+	// PValue is the probability of counts at least this far from the expected shares if the assignment is correct.
+	PValue float64
+
+	// Overlap is the joint assignment with the other experiment, if one was given.
+	Overlap *ExperimentOverlap
+}
+
+// ExperimentOverlap counts how users are assigned across two experiments.
+type ExperimentOverlap struct {
+	// CohortID of the other experiment.
+	CohortID string
+
+	// Counts[a][b] is the number of users in arm a of the simulated experiment and arm b of the other experiment.
+	Counts [numSimulatedArms][numSimulatedArms]int
+
+	// ChiSquare is the chi-square statistic of the counts against independent assignment to the two experiments.
+	ChiSquare float64
+
+	// DegreesOfFreedom of the independence test.
+	DegreesOfFreedom int
+
+	// PValue is the probability of counts at least this far from independent assignment if the experiments are
+	// independent.
+	PValue float64
+}
+
+// Independent reports whether the assignments to the two experiments are consistent with being independent.
+// Experiments that are not independent give one experiment's arms a skewed mix of the other's.
+func (o *ExperimentOverlap) Independent() bool {
+	return o.PValue >= SampleRatioMismatchPValue
+}
+
+// Share returns the fraction of users assigned to the arm.
+func (s *ExperimentSimulation) Share(arm SimulatedArm) float64 {
+	if s.Users == 0 {
+		return 0
+	}
+	return float64(s.Counts[arm]) / float64(s.Users)
+}
+
+// Expected returns the number of users the arm should get from the bucket configuration.
+func (s *ExperimentSimulation) Expected(arm SimulatedArm) float64 {
+	return s.ExpectedShares[arm] * float64(s.Users)
+}
+
+// SampleRatioMismatch reports whether the counts differ from the expected shares by more than chance allows.
+func (s *ExperimentSimulation) SampleRatioMismatch() bool {
+	return s.PValue < SampleRatioMismatchPValue
+}
+
+// SimulateExperiment assigns each user ID to the experiment with CheckMembership and compares the arm counts with
+// the split expected from the bucket configuration. When other is not nil, it also counts how the users are
+// assigned across both experiments.
+func SimulateExperiment(experiment *TwoArmExperiment, userIDs []string, other *TwoArmExperiment) (*ExperimentSimulation, error) {
+	if err := checkSimulatedExperiment(experiment); err != nil {
+		return nil, err
+	}
+	if other != nil {
+		if err := checkSimulatedExperiment(other); err != nil {
+			return nil, fmt.Errorf("other experiment: %v", err)
+		}
+	}
+	if len(userIDs) == 0 {
+		return nil, errors.New("at least one user ID is required")
+	}
+
+	sim := &ExperimentSimulation{
+		CohortID:       experiment.CohortID,
+		Users:          len(userIDs),
+		ExpectedShares: expectedShares(experiment),
+	}
+	if other != nil {
+		sim.Overlap = &ExperimentOverlap{CohortID: other.CohortID}
+	}
+	for _, userID := range userIDs {
+		arm := simulatedArm(experiment.CheckMembership(userID))
+		sim.Counts[arm]++
+		if other != nil {
+			sim.Overlap.Counts[arm][simulatedArm(other.CheckMembership(userID))]++
+		}
+	}
+	sim.ChiSquare, sim.DegreesOfFreedom = chiSquare(sim.Counts, sim.ExpectedShares, sim.Users)
+	sim.PValue = chiSquarePValue(sim.ChiSquare, sim.DegreesOfFreedom)
+	if sim.Overlap != nil {
+		sim.Overlap.ChiSquare, sim.Overlap.DegreesOfFreedom = independenceChiSquare(sim.Overlap.Counts)
+		sim.Overlap.PValue = chiSquarePValue(sim.Overlap.ChiSquare, sim.Overlap.DegreesOfFreedom)
+	}
+	return sim, nil
+}
+
+// SyntheticUserIDs returns n random UUID user IDs. The same seed returns the same IDs.
+func SyntheticUserIDs(n int, seed int64) []string {
+	random := rand.New(rand.NewSource(seed))
+	userIDs := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		id, err := uuid.NewRandomFromReader(random)
+		if err != nil {
+			// math/rand readers never fail.
+			panic(err)
+		}
+		userIDs = append(userIDs, id.String())
+	}
+	return userIDs
+}
+
+// checkSimulatedExperiment returns an error for configurations CheckMembership cannot evaluate.
+func checkSimulatedExperiment(experiment *TwoArmExperiment) error {
+	if experiment == nil {
+		return errors.New("experiment must be set")
+	}
+	if experiment.NumTotalBuckets <= 0 {
+		return errors.New("experiment must have at least one bucket")
+	}
+	return nil
+}
+
+// expectedShares returns the fraction of buckets that assign users to each arm.
+func expectedShares(experiment *TwoArmExperiment) [numSimulatedArms]float64 {
+	total := float64(experiment.NumTotalBuckets)
+	var shares [numSimulatedArms]float64
+	shares[SimulatedArmControl] = float64(experiment.NumActiveControlBuckets) / total
+	shares[SimulatedArmTreatment] = float64(experiment.NumActiveTreatmentBuckets) / total
+	shares[SimulatedArmNone] = 1 - shares[SimulatedArmControl] - shares[SimulatedArmTreatment]
+	return shares
+}
+
+// simulatedArm converts a CheckMembership result to a SimulatedArm.
+func simulatedArm(membership *event.CohortMembership) SimulatedArm {
+	switch membership.GetArm() {
+	case event.CohortArm_CONTROL:
+		return SimulatedArmControl
+	case event.CohortArm_TREATMENT:
+		return SimulatedArmTreatment
+	default:
+		return SimulatedArmNone
+	}
+}
+
+// chiSquare returns Pearson's chi-square statistic and its degrees of freedom. Arms that should get no users are
+// left out of the degrees of freedom, and any users assigned to them make the statistic infinite.
+func chiSquare(counts [numSimulatedArms]int, shares [numSimulatedArms]float64, users int) (float64, int) {
+	statistic := 0.0
+	arms := 0
+	for arm, share := range shares {
+		expected := share * float64(users)
+		if expected <= 0 {
+			if counts[arm] > 0 {
+				statistic = math.Inf(1)
+			}
+			continue
+		}
+		arms++
+		diff := float64(counts[arm]) - expected
+		statistic += diff * diff / expected
+	}
+	return statistic, max(arms-1, 0)
+}
+
+// independenceChiSquare returns the chi-square statistic and degrees of freedom for independence of the rows and
+// columns of the counts. Rows and columns without users are left out.
+func independenceChiSquare(counts [numSimulatedArms][numSimulatedArms]int) (float64, int) {
+	var rows, cols [numSimulatedArms]int
+	total := 0
+	for a := range counts {
+		for b, count := range counts[a] {
+			rows[a] += count
+			cols[b] += count
+			total += count
+		}
+	}
+	statistic := 0.0
+	for a := range counts {
+		for b, count := range counts[a] {
+			expected := float64(rows[a]) * float64(cols[b]) / float64(total)
+			if expected > 0 {
+				diff := float64(count) - expected
+				statistic += diff * diff / expected
+			}
+		}
+	}
+	return statistic, max(nonZero(rows)-1, 0) * max(nonZero(cols)-1, 0)
+}
+
+// nonZero returns the number of non-zero counts.
+func nonZero(counts [numSimulatedArms]int) int {
+	n := 0
+	for _, count := range counts {
+		if count > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// chiSquarePValue returns the upper tail probability of the chi-square distribution, using the closed forms for
+// integer degrees of freedom.
+func chiSquarePValue(statistic float64, degreesOfFreedom int) float64 {
+	if math.IsInf(statistic, 1) {
+		return 0
+	}
+	if degreesOfFreedom <= 0 {
+		return 1
+	}
+	// Q(x; k+2) = Q(x; k) + (x/2)^(k/2) e^(-x/2) / Γ(k/2+1), starting from Q(x; 1) or Q(x; 2).
+	half := statistic / 2
+	var p, term float64
+	k := degreesOfFreedom % 2
+	if k == 1 {
+		p = math.Erfc(math.Sqrt(half))
+		term = math.Sqrt(half) * math.Exp(-half) * 2 / math.Sqrt(math.Pi)
+	} else {
+		k = 2
+		p = math.Exp(-half)
+		term = half * p
+	}
+	for ; k < degreesOfFreedom; k += 2 {
+		p += term
+		term *= half / (float64(k)/2 + 1)
+	}
+	return math.Min(math.Max(p, 0), 1)
+}
@@ -0,0 +1,133 @@
+package delivery
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulateExperimentMatchesBucketSplit(t *testing.T) {
+	exp, err := Create5050TwoArmExperimentConfig("HOLD_OUT", 10, 20)
+	assert.NoError(t, err)
+
+	sim, err := SimulateExperiment(exp, SyntheticUserIDs(20000, 1), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "HOLD_OUT", sim.CohortID)
+	assert.Equal(t, 20000, sim.Users)
+	assert.Equal(t, sim.Users, sim.Counts[SimulatedArmControl]+sim.Counts[SimulatedArmTreatment]+sim.Counts[SimulatedArmNone])
+	assert.InDelta(t, 0.1, sim.ExpectedShares[SimulatedArmControl], 1e-9)
+	assert.InDelta(t, 0.2, sim.ExpectedShares[SimulatedArmTreatment], 1e-9)
+	assert.InDelta(t, 0.7, sim.ExpectedShares[SimulatedArmNone], 1e-9)
+	assert.InDelta(t, 2000, sim.Expected(SimulatedArmControl), 1e-6)
+	assert.InDelta(t, 0.1, sim.Share(SimulatedArmControl), 0.01)
+	assert.InDelta(t, 0.2, sim.Share(SimulatedArmTreatment), 0.01)
+	assert.Equal(t, 2, sim.DegreesOfFreedom)
+	assert.False(t, sim.SampleRatioMismatch(), "p=%v", sim.PValue)
+	assert.Nil(t, sim.Overlap)
+}
+
+func TestSimulateExperimentCountsMatchCheckMembership(t *testing.T) {
+	exp, err := NewTwoArmExperiment("HOLD_OUT", 50, 50, 50, 50)
+	assert.NoError(t, err)
+
+	// user2 is in control and user4 is in treatment, as in the TwoArmExperiment tests.
+	sim, err := SimulateExperiment(exp, []string{"user2", "user4", "user2"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, sim.Counts[SimulatedArmControl])
+	assert.Equal(t, 1, sim.Counts[SimulatedArmTreatment])
+	assert.Equal(t, 0, sim.Counts[SimulatedArmNone])
+	// No users should be outside the experiment, so only control and treatment are tested.
+	assert.Equal(t, 1, sim.DegreesOfFreedom)
+	assert.InDelta(t, 1.0/3, sim.ChiSquare, 1e-9)
+	assert.InDelta(t, math.Erfc(math.Sqrt(1.0/6)), sim.PValue, 1e-9)
+}
+
+func TestSimulateExperimentDetectsSampleRatioMismatch(t *testing.T) {
+	exp, err := NewTwoArmExperiment("HOLD_OUT", 50, 50, 50, 50)
+	assert.NoError(t, err)
+
+	// The same user repeated lands in one arm, far from the expected even split.
+	userIDs := make([]string, 1000)
+	for i := range userIDs {
+		userIDs[i] = "user2"
+	}
+	sim, err := SimulateExperiment(exp, userIDs, nil)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1000, sim.ChiSquare, 1e-9)
+	assert.True(t, sim.SampleRatioMismatch())
+}
+
+func TestSimulateExperimentOverlap(t *testing.T) {
+	exp, err := Create5050TwoArmExperimentConfig("A", 50, 50)
+	assert.NoError(t, err)
+	other, err := Create5050TwoArmExperimentConfig("B", 25, 25)
+	assert.NoError(t, err)
+
+	userIDs := SyntheticUserIDs(10000, 2)
+	sim, err := SimulateExperiment(exp, userIDs, other)
+	assert.NoError(t, err)
+	assert.Equal(t, "B", sim.Overlap.CohortID)
+
+	total := 0
+	for _, arm := range SimulatedArms {
+		row := 0
+		for _, otherArm := range SimulatedArms {
+			row += sim.Overlap.Counts[arm][otherArm]
+		}
+		assert.Equal(t, sim.Counts[arm], row)
+		total += row
+	}
+	assert.Equal(t, len(userIDs), total)
+
+	// CheckMembership offsets the same user hash by each cohort ID's hash, so a user's bucket in one experiment
+	// determines their bucket in the other and the arms are not independent.
+	assert.Equal(t, 2, sim.Overlap.DegreesOfFreedom)
+	assert.False(t, sim.Overlap.Independent(), "p=%v", sim.Overlap.PValue)
+}
+
+func TestIndependenceChiSquare(t *testing.T) {
+	var counts [numSimulatedArms][numSimulatedArms]int
+	counts[SimulatedArmControl][SimulatedArmControl] = 25
+	counts[SimulatedArmControl][SimulatedArmTreatment] = 25
+	counts[SimulatedArmTreatment][SimulatedArmControl] = 25
+	counts[SimulatedArmTreatment][SimulatedArmTreatment] = 25
+	statistic, df := independenceChiSquare(counts)
+	assert.Equal(t, 0.0, statistic)
+	assert.Equal(t, 1, df)
+
+	counts[SimulatedArmControl][SimulatedArmTreatment] = 0
+	counts[SimulatedArmTreatment][SimulatedArmControl] = 0
+	statistic, df = independenceChiSquare(counts)
+	assert.InDelta(t, 50, statistic, 1e-9)
+	assert.Equal(t, 1, df)
+}
+
+func TestSimulateExperimentErrors(t *testing.T) {
+	exp, err := Create5050TwoArmExperimentConfig("A", 50, 50)
+	assert.NoError(t, err)
+
+	_, err = SimulateExperiment(nil, []string{"u"}, nil)
+	assert.EqualError(t, err, "experiment must be set")
+	_, err = SimulateExperiment(exp, nil, nil)
+	assert.EqualError(t, err, "at least one user ID is required")
+	_, err = SimulateExperiment(exp, []string{"u"}, &TwoArmExperiment{CohortID: "B"})
+	assert.EqualError(t, err, "other experiment: experiment must have at least one bucket")
+}
+
+func TestChiSquarePValue(t *testing.T) {
+	// Critical values of the chi-square distribution at p=0.05.
+	assert.InDelta(t, 0.05, chiSquarePValue(3.841, 1), 1e-4)
+	assert.InDelta(t, 0.05, chiSquarePValue(5.991, 2), 1e-4)
+	assert.InDelta(t, 0.05, chiSquarePValue(7.815, 3), 1e-4)
+	assert.InDelta(t, 0.05, chiSquarePValue(9.488, 4), 1e-4)
+	assert.InDelta(t, 0.01, chiSquarePValue(15.086, 5), 1e-4)
+	assert.Equal(t, 1.0, chiSquarePValue(0, 0))
+	assert.Equal(t, 0.0, chiSquarePValue(math.Inf(1), 0))
+}
+
+func TestSyntheticUserIDsAreDeterministic(t *testing.T) {
+	assert.Equal(t, SyntheticUserIDs(3, 7), SyntheticUserIDs(3, 7))
+	assert.NotEqual(t, SyntheticUserIDs(3, 7), SyntheticUserIDs(3, 8))
+	assert.Equal(t, 3, len(SyntheticUserIDs(3, 7)))
+}
@@ -0,0 +1,164 @@
+package delivery
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	// DefaultMaxIdleConnsPerHost is the number of idle connections kept per host. net/http keeps only 2, which
+	// makes a busy client open and close connections constantly.
+	DefaultMaxIdleConnsPerHost = 100
+
+	// DefaultIdleConnTimeout is how long an idle connection is kept open.
+	DefaultIdleConnTimeout = 90 * time.Second
+
+	// DefaultDialTimeout bounds opening a TCP connection.
+	DefaultDialTimeout = 30 * time.Second
+)
+
+// HTTPOptions configures the HTTP client of one API, so the Delivery and Metrics APIs can be tuned separately.
+// The zero value creates a client on a clone of http.DefaultTransport with the defaults above.
+type HTTPOptions struct {
+	// Client is used as is when set, and the other fields must be unset. Its Timeout is not changed; every call
+	// still has the API's timeout on its context.
+	Client *http.Client
+
+	// Transport sends the requests, for example to add a proxy or tracing. An *http.Transport is cloned and the
+	// settings below that are set are applied to the clone. Other RoundTrippers are used as is, and the settings
+	// must be unset.
+	Transport http.RoundTripper
+
+	// MaxIdleConnsPerHost defaults to DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout defaults to DefaultIdleConnTimeout.
+	IdleConnTimeout time.Duration
+
+	// DialTimeout defaults to DefaultDialTimeout.
+	DialTimeout time.Duration
+
+	// DisableHTTP2 keeps connections on HTTP/1.1. HTTP/2 is otherwise negotiated over TLS.
+	DisableHTTP2 bool
+
+	// TLS sets client certificates, CAs and the server name. It is applied on top of the transport's
+	// TLSClientConfig.
+	TLS *TLSOptions
+}
+
+// hasTransportSettings reports whether any of the settings applied to an *http.Transport are set.
+func (o HTTPOptions) hasTransportSettings() bool {
+	return o.MaxIdleConnsPerHost != 0 || o.IdleConnTimeout != 0 || o.DialTimeout != 0 || o.DisableHTTP2 || o.TLS != nil
+}
+
+// isZero reports whether the options are all unset.
+func (o HTTPOptions) isZero() bool {
+	return o.Client == nil && o.Transport == nil && !o.hasTransportSettings()
+}
+
+// validate returns every problem with the options, which are called name in the errors.
+func (o HTTPOptions) validate(name string) []error {
+	var errs []error
+	if o.Client != nil && (o.Transport != nil || o.hasTransportSettings()) {
+		errs = append(errs, fmt.Errorf("%s: Client cannot be combined with the other options", name))
+	}
+	if _, ok := o.Transport.(*http.Transport); o.Transport != nil && !ok && o.hasTransportSettings() {
+		errs = append(errs, fmt.Errorf("%s: connection settings require an *http.Transport, got %T", name, o.Transport))
+	}
+	if o.MaxIdleConnsPerHost < 0 {
+		errs = append(errs, fmt.Errorf("%s: MaxIdleConnsPerHost must not be negative", name))
+	}
+	if o.IdleConnTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: IdleConnTimeout must not be negative", name))
+	}
+	if o.DialTimeout < 0 {
+		errs = append(errs, fmt.Errorf("%s: DialTimeout must not be negative", name))
+	}
+	if o.TLS != nil {
+		errs = append(errs, o.TLS.validate(name)...)
+	}
+	return errs
+}
+
+// NewClient creates the HTTP client described by the options, with timeout as the client's overall timeout.
+func (o HTTPOptions) NewClient(timeout time.Duration) (*http.Client, error) {
+	if errs := o.validate("HTTPOptions"); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if o.Client != nil {
+		return o.Client, nil
+	}
+	transport, err := o.roundTripper()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// roundTripper returns the custom RoundTripper, or a tuned clone of the given or default transport.
+func (o HTTPOptions) roundTripper() (http.RoundTripper, error) {
+	if o.Transport == nil {
+		return o.tune(http.DefaultTransport.(*http.Transport).Clone(), HTTPOptions{
+			MaxIdleConnsPerHost: DefaultMaxIdleConnsPerHost,
+			IdleConnTimeout:     DefaultIdleConnTimeout,
+			DialTimeout:         DefaultDialTimeout,
+		})
+	}
+	if transport, ok := o.Transport.(*http.Transport); ok {
+		return o.tune(transport.Clone(), HTTPOptions{})
+	}
+	return o.Transport, nil
+}
+
+// tune applies the settings to transport, using defaults for the unset ones. Settings that are unset in both
+// keep the transport's values.
+func (o HTTPOptions) tune(transport *http.Transport, defaults HTTPOptions) (*http.Transport, error) {
+	if n := firstNonZero(o.MaxIdleConnsPerHost, defaults.MaxIdleConnsPerHost); n > 0 {
+		transport.MaxIdleConnsPerHost = n
+		if transport.MaxIdleConns != 0 && transport.MaxIdleConns < n {
+			transport.MaxIdleConns = n
+		}
+	}
+	if timeout := firstNonZero(o.IdleConnTimeout, defaults.IdleConnTimeout); timeout > 0 {
+		transport.IdleConnTimeout = timeout
+	}
+	if timeout := firstNonZero(o.DialTimeout, defaults.DialTimeout); timeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+		// A custom DialContext turns off HTTP/2 unless it is forced.
+		transport.ForceAttemptHTTP2 = true
+	}
+	if o.DisableHTTP2 {
+		// An empty TLSNextProto keeps TLS connections on HTTP/1.1.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	if o.TLS != nil {
+		config, err := o.TLS.newConfig(transport.TLSClientConfig)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = config
+	}
+	return transport, nil
+}
+
+func firstNonZero[T int | time.Duration](values ...T) T {
+	for _, v := range values {
+		if v != 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// HTTPAPIFactory is an APIFactory that can create the API clients with a given HTTP client. The builder uses it
+// to apply the HTTPOptions set with WithDeliveryHTTPOptions and WithMetricsHTTPOptions.
+type HTTPAPIFactory interface {
+	APIFactory
+	CreateDeliveryAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64, maxRequestInsertions int, acceptGzip, warmup bool) DeliveryAPI
+	CreateMetricsAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64) MetricsAPI
+}
@@ -0,0 +1,90 @@
+package delivery
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTripperFunc is an http.RoundTripper that calls the function.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestHTTPOptionsDefaults(t *testing.T) {
+	client, err := HTTPOptions{}.NewClient(250 * time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, client.Timeout)
+
+	transport := client.Transport.(*http.Transport)
+	assert.NotSame(t, http.DefaultTransport, transport)
+	assert.Equal(t, DefaultMaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, DefaultIdleConnTimeout, transport.IdleConnTimeout)
+	assert.NotNil(t, transport.DialContext)
+	assert.True(t, transport.ForceAttemptHTTP2)
+}
+
+func TestHTTPOptionsSettings(t *testing.T) {
+	client, err := HTTPOptions{
+		MaxIdleConnsPerHost: 300,
+		IdleConnTimeout:     time.Minute,
+		DialTimeout:         time.Second,
+		DisableHTTP2:        true,
+	}.NewClient(time.Second)
+	assert.NoError(t, err)
+
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, 300, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, 300, transport.MaxIdleConns)
+	assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto)
+	assert.Empty(t, transport.TLSNextProto)
+}
+
+func TestHTTPOptionsTransport(t *testing.T) {
+	// A given *http.Transport is cloned, so it is not changed, and only the set settings are applied.
+	base := &http.Transport{MaxIdleConnsPerHost: 7, IdleConnTimeout: time.Hour}
+	client, err := HTTPOptions{Transport: base, MaxIdleConnsPerHost: 50}.NewClient(time.Second)
+	assert.NoError(t, err)
+	transport := client.Transport.(*http.Transport)
+	assert.NotSame(t, base, transport)
+	assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+	assert.Equal(t, time.Hour, transport.IdleConnTimeout)
+	assert.Equal(t, 7, base.MaxIdleConnsPerHost)
+
+	// Other RoundTrippers are used as is.
+	var roundTripper http.RoundTripper = roundTripperFunc(func(req *http.Request) (*http.Response, error) { return nil, nil })
+	client, err = HTTPOptions{Transport: roundTripper}.NewClient(time.Second)
+	assert.NoError(t, err)
+	assert.NotNil(t, client.Transport)
+	_, ok := client.Transport.(roundTripperFunc)
+	assert.True(t, ok)
+
+	// A given client is used as is.
+	httpClient := &http.Client{}
+	client, err = HTTPOptions{Client: httpClient}.NewClient(time.Second)
+	assert.NoError(t, err)
+	assert.Same(t, httpClient, client)
+	assert.Zero(t, client.Timeout)
+}
+
+func TestHTTPOptionsErrors(t *testing.T) {
+	roundTripper := roundTripperFunc(func(req *http.Request) (*http.Response, error) { return nil, nil })
+	_, err := HTTPOptions{
+		Client:              &http.Client{},
+		Transport:           roundTripper,
+		MaxIdleConnsPerHost: -1,
+		IdleConnTimeout:     -time.Second,
+		DialTimeout:         -time.Second,
+	}.NewClient(time.Second)
+	assert.EqualError(t, err, "HTTPOptions: Client cannot be combined with the other options\n"+
+		"HTTPOptions: connection settings require an *http.Transport, got delivery.roundTripperFunc\n"+
+		"HTTPOptions: MaxIdleConnsPerHost must not be negative\n"+
+		"HTTPOptions: IdleConnTimeout must not be negative\n"+
+		"HTTPOptions: DialTimeout must not be negative")
+}
@@ -0,0 +1,276 @@
+package delivery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultAnonUserIDCookie is the cookie read for the anonymous user ID by FromHTTPRequest and HTTPMiddleware.
+const DefaultAnonUserIDCookie = "promoted_anon_user_id"
+
+// HTTPRequestInfo is the user, device and client information read from an incoming HTTP request.
+type HTTPRequestInfo struct {
+	UserInfo   *common.UserInfo
+	Device     *common.Device
+	ClientInfo *common.ClientInfo
+}
+
+// HTTPRequestExtractor reads HTTPRequestInfo from incoming HTTP requests. The zero value uses the connection's
+// remote address as the client IP and does not read an anonymous user ID.
+type HTTPRequestExtractor struct {
+	// TrustedProxies are the networks of proxies whose X-Forwarded-For entries are trusted. When the remote address
+	// is a trusted proxy, the client IP is the right-most X-Forwarded-For entry that is not a trusted proxy.
+	TrustedProxies []netip.Prefix
+
+	// AnonUserIDHeader is the request header holding the anonymous user ID. It is checked before the cookie.
+	AnonUserIDHeader string
+
+	// AnonUserIDCookie is the cookie holding the anonymous user ID.
+	AnonUserIDCookie string
+
+	// UserIDHeader is the request header holding the authenticated platform user ID, if any.
+	UserIDHeader string
+}
+
+// defaultHTTPRequestExtractor backs FromHTTPRequest and HTTPMiddleware.
+var defaultHTTPRequestExtractor = &HTTPRequestExtractor{AnonUserIDCookie: DefaultAnonUserIDCookie}
+
+// ParseTrustedProxies parses CIDR prefixes or single IP addresses into TrustedProxies.
+func ParseTrustedProxies(proxies ...string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if strings.Contains(proxy, "/") {
+			prefix, err := netip.ParsePrefix(proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// FromHTTPRequest reads HTTPRequestInfo from an incoming HTTP request with the default extractor, which trusts
+// no proxies and reads the anonymous user ID from DefaultAnonUserIDCookie.
+func FromHTTPRequest(r *http.Request) *HTTPRequestInfo {
+	return defaultHTTPRequestExtractor.Extract(r)
+}
+
+// HTTPMiddleware stores the HTTPRequestInfo of each request in its context using the default extractor.
+func HTTPMiddleware(next http.Handler) http.Handler {
+	return defaultHTTPRequestExtractor.Middleware(next)
+}
+
+// Middleware stores the HTTPRequestInfo of each request in its context, where DeliverContext picks it up.
+func (e *HTTPRequestExtractor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := ContextWithHTTPRequestInfo(r.Context(), e.Extract(r))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// Extract reads the client IP, user agent, client hints, viewport and user IDs from the request. The device type,
+// brand, model and OS version are parsed from the user agent and client hints.
+func (e *HTTPRequestExtractor) Extract(r *http.Request) *HTTPRequestInfo {
+	info := &HTTPRequestInfo{
+		UserInfo:   &common.UserInfo{},
+		Device:     &common.Device{},
+		ClientInfo: &common.ClientInfo{ClientType: common.ClientInfo_PLATFORM_SERVER},
+	}
+
+	if e.UserIDHeader != "" {
+		info.UserInfo.UserId = r.Header.Get(e.UserIDHeader)
+	}
+	info.UserInfo.AnonUserId = e.anonUserID(r)
+
+	if ip, ok := e.clientIP(r); ok {
+		info.Device.IpAddress = ip.String()
+	}
+
+	browser := &common.Browser{
+		UserAgent:    r.Header.Get("User-Agent"),
+		Referrer:     r.Header.Get("Referer"),
+		ClientHints:  ParseClientHintHeaders(r.Header),
+		ViewportSize: parseViewportSize(r.Header),
+	}
+	if !proto.Equal(browser, &common.Browser{}) {
+		info.Device.Browser = browser
+		ParseUserAgentAndHints(browser.UserAgent, browser.ClientHints).ApplyTo(info.Device)
+	}
+	return info
+}
+
+// anonUserID reads the anonymous user ID from the configured header or cookie.
+func (e *HTTPRequestExtractor) anonUserID(r *http.Request) string {
+	if e.AnonUserIDHeader != "" {
+		if id := r.Header.Get(e.AnonUserIDHeader); id != "" {
+			return id
+		}
+	}
+	if e.AnonUserIDCookie != "" {
+		if cookie, err := r.Cookie(e.AnonUserIDCookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+// clientIP returns the originating client IP, only trusting X-Forwarded-For entries added by trusted proxies.
+func (e *HTTPRequestExtractor) clientIP(r *http.Request) (netip.Addr, bool) {
+	remote, ok := parseRemoteAddr(r.RemoteAddr)
+	if !ok || !e.isTrustedProxy(remote) {
+		return remote, ok
+	}
+
+	var forwarded []netip.Addr
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(header, ",") {
+			if addr, ok := parseForwardedAddr(entry); ok {
+				forwarded = append(forwarded, addr)
+			}
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if !e.isTrustedProxy(forwarded[i]) {
+			return forwarded[i], true
+		}
+	}
+	if len(forwarded) > 0 {
+		return forwarded[0], true
+	}
+	return remote, true
+}
+
+// isTrustedProxy reports whether the address is in one of the trusted proxy networks.
+func (e *HTTPRequestExtractor) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range e.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRemoteAddr parses an http.Request RemoteAddr, which is usually host:port.
+func parseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// parseForwardedAddr parses an X-Forwarded-For entry, which may include a port or brackets.
+func parseForwardedAddr(entry string) (netip.Addr, bool) {
+	entry = strings.TrimSpace(entry)
+	if addrPort, err := netip.ParseAddrPort(entry); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(entry, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// parseViewportSize reads the viewport width and height client hints, returning nil when the width is missing.
+func parseViewportSize(header http.Header) *common.Size {
+	width := firstHeader(header, "Sec-CH-Viewport-Width", "Viewport-Width")
+	if width == "" {
+		return nil
+	}
+	w, err := strconv.ParseUint(width, 10, 32)
+	if err != nil {
+		return nil
+	}
+	size := &common.Size{Width: uint32(w)}
+	if h, err := strconv.ParseUint(firstHeader(header, "Sec-CH-Viewport-Height"), 10, 32); err == nil {
+		size.Height = uint32(h)
+	}
+	return size
+}
+
+// firstHeader returns the first non-empty header value among the names.
+func firstHeader(header http.Header, names ...string) string {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// ApplyTo fills in request fields that are not already set by the caller.
+func (info *HTTPRequestInfo) ApplyTo(req *delivery.Request) {
+	if info == nil || req == nil {
+		return
+	}
+	if info.UserInfo != nil {
+		if req.UserInfo == nil {
+			req.UserInfo = &common.UserInfo{}
+		}
+		if req.UserInfo.UserId == "" {
+			req.UserInfo.UserId = info.UserInfo.UserId
+		}
+		if req.UserInfo.AnonUserId == "" {
+			req.UserInfo.AnonUserId = info.UserInfo.AnonUserId
+		}
+	}
+	if info.Device != nil {
+		if req.Device == nil {
+			req.Device = proto.Clone(info.Device).(*common.Device)
+		} else {
+			if req.Device.IpAddress == "" {
+				req.Device.IpAddress = info.Device.IpAddress
+			}
+			if req.Device.Browser == nil && info.Device.Browser != nil {
+				req.Device.Browser = proto.Clone(info.Device.Browser).(*common.Browser)
+			}
+			UserAgent{
+				DeviceType:   info.Device.DeviceType,
+				Brand:        info.Device.Brand,
+				Manufacturer: info.Device.Manufacturer,
+				Model:        info.Device.Identifier,
+				OSVersion:    info.Device.OsVersion,
+			}.ApplyTo(req.Device)
+		}
+	}
+	if info.ClientInfo != nil && req.ClientInfo == nil {
+		req.ClientInfo = proto.Clone(info.ClientInfo).(*common.ClientInfo)
+	}
+}
+
+// httpRequestInfoKey is the context key for HTTPRequestInfo.
+type httpRequestInfoKey struct{}
+
+// ContextWithHTTPRequestInfo returns a context carrying the HTTPRequestInfo.
+func ContextWithHTTPRequestInfo(ctx context.Context, info *HTTPRequestInfo) context.Context {
+	return context.WithValue(ctx, httpRequestInfoKey{}, info)
+}
+
+// HTTPRequestInfoFromContext returns the HTTPRequestInfo stored by the HTTP middleware, if any.
+func HTTPRequestInfoFromContext(ctx context.Context) (*HTTPRequestInfo, bool) {
+	info, ok := ctx.Value(httpRequestInfoKey{}).(*HTTPRequestInfo)
+	return info, ok && info != nil
+}
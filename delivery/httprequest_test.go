@@ -0,0 +1,193 @@
+package delivery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newTestHTTPRequest(remoteAddr string, headers map[string]string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/search", nil)
+	r.RemoteAddr = remoteAddr
+	for k, v := range headers {
+		r.Header.Add(k, v)
+	}
+	return r
+}
+
+func TestHTTPRequestExtractorClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8", "192.168.1.1", "2001:db8::/32")
+	assert.NoError(t, err)
+	extractor := &HTTPRequestExtractor{TrustedProxies: proxies}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		xff        []string
+		expected   string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted remote ignores xff", "203.0.113.5:1234", []string{"1.2.3.4"}, "203.0.113.5"},
+		{"trusted remote uses xff", "10.1.2.3:1234", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"skips trusted hops right to left", "10.1.2.3:1234", []string{"1.1.1.1, 198.51.100.7, 10.9.9.9"}, "198.51.100.7"},
+		{"spoofed left-most entry ignored", "192.168.1.1:80", []string{"6.6.6.6", "198.51.100.7"}, "198.51.100.7"},
+		{"all trusted uses left-most", "10.1.2.3:1234", []string{"10.0.0.1, 10.0.0.2"}, "10.0.0.1"},
+		{"entries with ports", "10.1.2.3:1234", []string{"198.51.100.7:5555"}, "198.51.100.7"},
+		{"ipv6", "[2001:db8::1]:443", []string{"[2001:db9::5]:80"}, "2001:db9::5"},
+		{"ipv4 mapped", "[::ffff:10.1.2.3]:443", []string{"198.51.100.7"}, "198.51.100.7"},
+		{"invalid entries skipped", "10.1.2.3:1234", []string{"198.51.100.7, garbage"}, "198.51.100.7"},
+		{"empty xff", "10.1.2.3:1234", nil, "10.1.2.3"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestHTTPRequest(tc.remoteAddr, nil)
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tc.expected, extractor.Extract(r).Device.IpAddress)
+		})
+	}
+}
+
+func TestParseTrustedProxiesError(t *testing.T) {
+	_, err := ParseTrustedProxies("10.0.0.0/99")
+	assert.ErrorContains(t, err, `invalid trusted proxy "10.0.0.0/99"`)
+	_, err = ParseTrustedProxies("proxy.local")
+	assert.ErrorContains(t, err, `invalid trusted proxy "proxy.local"`)
+}
+
+func TestFromHTTPRequestReadsBrowserAndClientHints(t *testing.T) {
+	r := newTestHTTPRequest("203.0.113.5:1234", map[string]string{
+		"User-Agent":                  "Mozilla/5.0",
+		"Referer":                     "https://example.com/",
+		"Sec-CH-UA":                   `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`,
+		"Sec-CH-UA-Full-Version-List": `"Chromium";v="118.0.5993.88", "Google Chrome";v="118.0.5993.88"`,
+		"Sec-CH-UA-Mobile":            "?1",
+		"Sec-CH-UA-Platform":          `"Android"`,
+		"Sec-CH-UA-Platform-Version":  `"13.0.0"`,
+		"Sec-CH-UA-Model":             `"Pixel 7"`,
+		"Sec-CH-Viewport-Width":       "412",
+		"Sec-CH-Viewport-Height":      "915",
+	})
+	r.AddCookie(&http.Cookie{Name: DefaultAnonUserIDCookie, Value: "anon-1"})
+
+	info := FromHTTPRequest(r)
+	assert.Equal(t, "anon-1", info.UserInfo.AnonUserId)
+	assert.Equal(t, "203.0.113.5", info.Device.IpAddress)
+	assert.Equal(t, common.ClientInfo_PLATFORM_SERVER, info.ClientInfo.ClientType)
+	assert.Equal(t, common.DeviceType_MOBILE, info.Device.DeviceType)
+	assert.Equal(t, "Google", info.Device.Manufacturer)
+	assert.Equal(t, "Pixel 7", info.Device.Identifier)
+	assert.Equal(t, "13.0.0", info.Device.OsVersion)
+
+	browser := info.Device.Browser
+	assert.Equal(t, "Mozilla/5.0", browser.UserAgent)
+	assert.Equal(t, "https://example.com/", browser.Referrer)
+	assert.Equal(t, uint32(412), browser.ViewportSize.Width)
+	assert.Equal(t, uint32(915), browser.ViewportSize.Height)
+
+	hints := browser.ClientHints
+	assert.True(t, hints.IsMobile)
+	assert.Equal(t, "Android", hints.Platform)
+	assert.Equal(t, "13.0.0", hints.PlatformVersion)
+	assert.Equal(t, "Pixel 7", hints.Model)
+	assert.Equal(t, 2, len(hints.Brand))
+	assert.Equal(t, "Google Chrome", hints.Brand[1].Brand)
+	assert.Equal(t, "118.0.5993.88", hints.Brand[1].Version)
+}
+
+func TestFromHTTPRequestWithoutHeaders(t *testing.T) {
+	info := FromHTTPRequest(newTestHTTPRequest("not an address", nil))
+	assert.Equal(t, "", info.Device.IpAddress)
+	assert.Nil(t, info.Device.Browser)
+	assert.Equal(t, "", info.UserInfo.AnonUserId)
+}
+
+func TestParseClientHintBrands(t *testing.T) {
+	brands := ParseClientHintBrands(`"Not_A Brand";v="8", "Chromium";v="120", "Quoted \"Brand\", Inc";v="1"`)
+	assert.Equal(t, 3, len(brands))
+	assert.Equal(t, "Not_A Brand", brands[0].Brand)
+	assert.Equal(t, "8", brands[0].Version)
+	assert.Equal(t, `Quoted "Brand", Inc`, brands[2].Brand)
+	assert.Nil(t, ParseClientHintBrands(""))
+}
+
+func TestHTTPRequestExtractorUserIDs(t *testing.T) {
+	extractor := &HTTPRequestExtractor{AnonUserIDHeader: "X-Anon-Id", AnonUserIDCookie: "anon", UserIDHeader: "X-User-Id"}
+
+	r := newTestHTTPRequest("203.0.113.5:1", map[string]string{"X-Anon-Id": "from-header", "X-User-Id": "u1"})
+	r.AddCookie(&http.Cookie{Name: "anon", Value: "from-cookie"})
+	info := extractor.Extract(r)
+	assert.Equal(t, "from-header", info.UserInfo.AnonUserId)
+	assert.Equal(t, "u1", info.UserInfo.UserId)
+
+	r = newTestHTTPRequest("203.0.113.5:1", nil)
+	r.AddCookie(&http.Cookie{Name: "anon", Value: "from-cookie"})
+	assert.Equal(t, "from-cookie", extractor.Extract(r).UserInfo.AnonUserId)
+}
+
+func TestHTTPRequestInfoApplyToKeepsSetFields(t *testing.T) {
+	info := &HTTPRequestInfo{
+		UserInfo:   &common.UserInfo{UserId: "u-http", AnonUserId: "a-http"},
+		Device:     &common.Device{IpAddress: "1.2.3.4", Browser: &common.Browser{UserAgent: "ua"}},
+		ClientInfo: &common.ClientInfo{ClientType: common.ClientInfo_PLATFORM_SERVER},
+	}
+
+	req := &delivery.Request{UserInfo: &common.UserInfo{AnonUserId: "a-set"}, Device: &common.Device{IpAddress: "5.6.7.8"}}
+	info.ApplyTo(req)
+	assert.Equal(t, "u-http", req.UserInfo.UserId)
+	assert.Equal(t, "a-set", req.UserInfo.AnonUserId)
+	assert.Equal(t, "5.6.7.8", req.Device.IpAddress)
+	assert.Equal(t, "ua", req.Device.Browser.UserAgent)
+	assert.Equal(t, common.ClientInfo_PLATFORM_SERVER, req.ClientInfo.ClientType)
+
+	// The request gets copies, not the info's messages.
+	req.Device.Browser.UserAgent = "changed"
+	assert.Equal(t, "ua", info.Device.Browser.UserAgent)
+
+	empty := &delivery.Request{}
+	info.ApplyTo(empty)
+	assert.Equal(t, "1.2.3.4", empty.Device.IpAddress)
+}
+
+func TestHTTPMiddlewareAndDeliverContext(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	client, _ := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: mockApiDelivery, metricsAPI: new(MockMetrics)}).
+		Build()
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "r"}, nil)
+
+	var resp *DeliveryResponse
+	var err error
+	handler := HTTPMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := &DeliveryRequest{Request: &delivery.Request{Insertion: CreateTestRequestInsertions(2)}}
+		resp, err = client.DeliverContext(r.Context(), req)
+	}))
+
+	r := newTestHTTPRequest("203.0.113.5:1234", map[string]string{"User-Agent": "test-agent"})
+	r.AddCookie(&http.Cookie{Name: DefaultAnonUserIDCookie, Value: "anon-1"})
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	sent := mockApiDelivery.Calls[0].Arguments.Get(0).(*DeliveryRequest).Request
+	assert.Equal(t, "anon-1", sent.UserInfo.AnonUserId)
+	assert.Equal(t, "203.0.113.5", sent.Device.IpAddress)
+	assert.Equal(t, "test-agent", sent.Device.Browser.UserAgent)
+}
+
+func TestDeliverContextCanceled(t *testing.T) {
+	client, _ := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: new(MockDelivery), metricsAPI: new(MockMetrics)}).
+		Build()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := client.DeliverContext(ctx, &DeliveryRequest{Request: &delivery.Request{}})
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+)
+
+// DeliverHandler runs the rest of a Deliver call.
+type DeliverHandler func(ctx context.Context, deliveryRequest *DeliveryRequest) (*DeliveryResponse, error)
+
+// DeliverInterceptor wraps each Deliver and DeliverContext call, like a gRPC unary interceptor. It can inspect or
+// modify the request before calling next, and the response and error after. Not calling next skips delivery.
+type DeliverInterceptor func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliverHandler) (*DeliveryResponse, error)
+
+// DeliveryAPIHandler runs the rest of a Delivery API call.
+type DeliveryAPIHandler func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error)
+
+// DeliveryAPIInterceptor wraps each Delivery API call, including shadow traffic. An error it returns makes Deliver
+// fall back to SDK delivery, like any other Delivery API error.
+type DeliveryAPIInterceptor func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliveryAPIHandler) (*delivery.Response, error)
+
+// MetricsHandler runs the rest of a Metrics API call.
+type MetricsHandler func(ctx context.Context, logRequest *event.LogRequest) error
+
+// MetricsInterceptor wraps each Metrics API call. It runs on the goroutine that logs in the background.
+type MetricsInterceptor func(ctx context.Context, logRequest *event.LogRequest, next MetricsHandler) error
+
+// chainDeliverInterceptors returns a handler that runs the interceptors around handler, the first outermost.
+func chainDeliverInterceptors(interceptors []DeliverInterceptor, handler DeliverHandler) DeliverHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, deliveryRequest *DeliveryRequest) (*DeliveryResponse, error) {
+			return interceptor(ctx, deliveryRequest, next)
+		}
+	}
+	return handler
+}
+
+// chainDeliveryAPIInterceptors returns a handler that runs the interceptors around handler, the first outermost.
+func chainDeliveryAPIInterceptors(interceptors []DeliveryAPIInterceptor, handler DeliveryAPIHandler) DeliveryAPIHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+			return interceptor(ctx, deliveryRequest, next)
+		}
+	}
+	return handler
+}
+
+// chainMetricsInterceptors returns a handler that runs the interceptors around handler, the first outermost.
+func chainMetricsInterceptors(interceptors []MetricsInterceptor, handler MetricsHandler) MetricsHandler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(ctx context.Context, logRequest *event.LogRequest) error {
+			return interceptor(ctx, logRequest, next)
+		}
+	}
+	return handler
+}
+
+// interceptedDeliveryAPI runs a DeliveryAPI through its interceptors.
+type interceptedDeliveryAPI struct {
+	api     DeliveryAPI
+	handler DeliveryAPIHandler
+}
+
+func newInterceptedDeliveryAPI(api DeliveryAPI, interceptors []DeliveryAPIInterceptor) *interceptedDeliveryAPI {
+	return &interceptedDeliveryAPI{
+		api: api,
+		handler: chainDeliveryAPIInterceptors(interceptors, func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+			return runDeliveryContext(ctx, api, deliveryRequest)
+		}),
+	}
+}
+
+func (a *interceptedDeliveryAPI) RunDelivery(deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return a.handler(context.Background(), deliveryRequest)
+}
+
+func (a *interceptedDeliveryAPI) RunDeliveryContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return a.handler(ctx, deliveryRequest)
+}
+
+// HealthCheck passes health checks through without the interceptors.
+func (a *interceptedDeliveryAPI) HealthCheck(ctx context.Context) error {
+	healthChecker, ok := a.api.(HealthChecker)
+	if !ok {
+		return errors.New("the intercepted Delivery API does not support health checks")
+	}
+	return healthChecker.HealthCheck(ctx)
+}
+
+// interceptedMetricsAPI runs a MetricsAPI through its interceptors.
+type interceptedMetricsAPI struct {
+	handler MetricsHandler
+}
+
+func newInterceptedMetricsAPI(api MetricsAPI, interceptors []MetricsInterceptor) *interceptedMetricsAPI {
+	return &interceptedMetricsAPI{
+		handler: chainMetricsInterceptors(interceptors, func(ctx context.Context, logRequest *event.LogRequest) error {
+			return api.RunMetricsLogging(logRequest)
+		}),
+	}
+}
+
+func (m *interceptedMetricsAPI) RunMetricsLogging(logRequest *event.LogRequest) error {
+	return m.handler(context.Background(), logRequest)
+}
@@ -0,0 +1,200 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// metricsFunc is a MetricsAPI that calls the function.
+type metricsFunc func(logRequest *event.LogRequest) error
+
+func (f metricsFunc) RunMetricsLogging(logRequest *event.LogRequest) error {
+	return f(logRequest)
+}
+
+// echoDeliveryAPI returns the request's insertions.
+var echoDeliveryAPI = contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return &delivery.Response{RequestId: "request-1", Insertion: deliveryRequest.Request.Insertion}, nil
+})
+
+// recordingDeliverInterceptor appends name to calls before and after calling next.
+func recordingDeliverInterceptor(name string, calls *[]string) DeliverInterceptor {
+	return func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliverHandler) (*DeliveryResponse, error) {
+		*calls = append(*calls, name+" before")
+		resp, err := next(ctx, deliveryRequest)
+		*calls = append(*calls, name+" after")
+		return resp, err
+	}
+}
+
+func TestDeliverInterceptorsRunInOrder(t *testing.T) {
+	var calls []string
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: echoDeliveryAPI, metricsAPI: metricsFunc(func(*event.LogRequest) error { return nil })}).
+		WithDeliverInterceptors(recordingDeliverInterceptor("first", &calls), recordingDeliverInterceptor("second", &calls)).
+		WithDeliverInterceptors(recordingDeliverInterceptor("third", &calls)).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.Equal(t, []string{"first before", "second before", "third before", "third after", "second after", "first after"}, calls)
+}
+
+func TestDeliverInterceptorModifiesRequestAndResponse(t *testing.T) {
+	var apiUserID string
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		apiUserID = deliveryRequest.Request.GetUserInfo().GetAnonUserId()
+		return echoDeliveryAPI(ctx, deliveryRequest)
+	})
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: api, metricsAPI: metricsFunc(func(*event.LogRequest) error { return nil })}).
+		WithDeliverInterceptors(func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliverHandler) (*DeliveryResponse, error) {
+			deliveryRequest.Request.UserInfo = &common.UserInfo{AnonUserId: "anon-1"}
+			resp, err := next(ctx, deliveryRequest)
+			if err == nil {
+				resp.Response.Insertion = resp.Response.Insertion[:1]
+			}
+			return resp, err
+		}).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, "anon-1", apiUserID)
+	assert.Equal(t, 1, len(resp.Response.Insertion))
+}
+
+func TestDeliverInterceptorShortCircuits(t *testing.T) {
+	interceptorErr := errors.New("rejected")
+	api := contextDeliveryFunc(func(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+		t.Error("the Delivery API should not be called")
+		return nil, nil
+	})
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: api}).
+		WithDeliverInterceptors(func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliverHandler) (*DeliveryResponse, error) {
+			return nil, interceptorErr
+		}).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.DeliverContext(context.Background(), newDeadlineTestRequest())
+	assert.Nil(t, resp)
+	assert.ErrorIs(t, err, interceptorErr)
+}
+
+func TestDeliveryAPIInterceptorsWrapAPICalls(t *testing.T) {
+	var calls []string
+	var hasDeadline bool
+	recording := func(name string) DeliveryAPIInterceptor {
+		return func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliveryAPIHandler) (*delivery.Response, error) {
+			calls = append(calls, name)
+			_, hasDeadline = ctx.Deadline()
+			return next(ctx, deliveryRequest)
+		}
+	}
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: echoDeliveryAPI, metricsAPI: metricsFunc(func(*event.LogRequest) error { return nil })}).
+		WithDeliverTimeout(time.Second).
+		WithDeliveryAPIInterceptors(recording("first"), recording("second")).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.Equal(t, []string{"first", "second"}, calls)
+	assert.True(t, hasDeadline)
+}
+
+func TestDeliveryAPIInterceptorErrorFallsBack(t *testing.T) {
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: echoDeliveryAPI, metricsAPI: metricsFunc(func(*event.LogRequest) error { return nil })}).
+		WithDeliveryAPIInterceptors(func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliveryAPIHandler) (*delivery.Response, error) {
+			return nil, errors.New("redaction failed")
+		}).
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newDeadlineTestRequest())
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Equal(t, 3, len(resp.Response.Insertion))
+	assert.Equal(t, FallbackReasonError, resp.FallbackReason)
+}
+
+func TestDeliveryAPIInterceptorsKeepHealthChecks(t *testing.T) {
+	var healthChecks atomic.Int32
+	api := &struct {
+		contextDeliveryFunc
+		healthCheckFunc
+	}{echoDeliveryAPI, func() { healthChecks.Add(1) }}
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: api}).
+		WithKeepAliveInterval(time.Millisecond).
+		WithDeliveryAPIInterceptors(func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliveryAPIHandler) (*delivery.Response, error) {
+			return next(ctx, deliveryRequest)
+		}).
+		Build()
+	assert.NoError(t, err)
+	defer client.Close()
+
+	assert.Eventually(t, func() bool { return healthChecks.Load() > 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, client.deliveryAPI.(HealthChecker).HealthCheck(context.Background()))
+
+	_, err = newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: echoDeliveryAPI}).
+		WithKeepAliveInterval(time.Millisecond).
+		WithDeliveryAPIInterceptors(func(ctx context.Context, deliveryRequest *DeliveryRequest, next DeliveryAPIHandler) (*delivery.Response, error) {
+			return next(ctx, deliveryRequest)
+		}).
+		Build()
+	assert.EqualError(t, err, "keepAliveInterval requires a Delivery API with a HealthCheck method")
+}
+
+func TestMetricsInterceptorsWrapLogging(t *testing.T) {
+	logged := make(chan *event.LogRequest, 1)
+	metrics := metricsFunc(func(logRequest *event.LogRequest) error {
+		logged <- logRequest
+		return nil
+	})
+	var calls []string
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: NewSDKDelivery(), deliveryAPI: echoDeliveryAPI, metricsAPI: metrics}).
+		WithMetricsInterceptors(
+			func(ctx context.Context, logRequest *event.LogRequest, next MetricsHandler) error {
+				calls = append(calls, "first")
+				logRequest.PlatformId = 7
+				return next(ctx, logRequest)
+			},
+			func(ctx context.Context, logRequest *event.LogRequest, next MetricsHandler) error {
+				calls = append(calls, "second")
+				return next(ctx, logRequest)
+			}).
+		Build()
+	assert.NoError(t, err)
+
+	req := newDeadlineTestRequest()
+	req.OnlyLog = true
+	_, err = client.Deliver(req)
+	assert.NoError(t, err)
+
+	select {
+	case logRequest := <-logged:
+		assert.Equal(t, uint64(7), logRequest.PlatformId)
+		assert.Equal(t, []string{"first", "second"}, calls)
+	case <-time.After(time.Second):
+		t.Fatal("the Metrics API was not called")
+	}
+}
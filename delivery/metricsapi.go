@@ -1,9 +1,7 @@
 package delivery
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
 	"net/http"
 	"time"
@@ -30,13 +28,14 @@ type PromotedMetricsAPI struct {
 	TimeoutDuration time.Duration
 }
 
-// NewPromotedMetricsAPI instantiates a new Metrics API client.
+// NewPromotedMetricsAPI instantiates a new Metrics API client with the default HTTPOptions.
 func NewPromotedMetricsAPI(endpoint, apiKey string, timeoutMillis int64) *PromotedMetricsAPI {
-	timeout := time.Duration(timeoutMillis) * time.Millisecond
-	httpClient := &http.Client{
-		Timeout: timeout,
-	}
+	httpClient, _ := HTTPOptions{}.NewClient(time.Duration(timeoutMillis) * time.Millisecond)
+	return NewPromotedMetricsAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis)
+}
 
+// NewPromotedMetricsAPIWithHTTPClient instantiates a new Metrics API client that sends requests with httpClient.
+func NewPromotedMetricsAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64) *PromotedMetricsAPI {
 	return &PromotedMetricsAPI{
 		Endpoint:        endpoint,
 		APIKey:          apiKey,
@@ -50,24 +49,28 @@ func (m *PromotedMetricsAPI) RunMetricsLogging(logRequest *event.LogRequest) err
 	ctx, cancel := context.WithTimeout(context.Background(), m.TimeoutDuration)
 	defer cancel()
 
-	requestBody, err := json.Marshal(logRequest)
+	body, err := encodeJSON(logRequest)
 	if err != nil {
 		return fmt.Errorf("error marshaling log request: %v", err)
 	}
+	defer body.release()
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Endpoint, bytes.NewBuffer(requestBody))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.Endpoint, nil)
 	if err != nil {
 		return fmt.Errorf("error creating HTTP request: %v", err)
 	}
+	body.setRequestBody(req)
 
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("x-api-key", m.APIKey)
+	if m.APIKey != "" {
+		req.Header.Set("x-api-key", m.APIKey)
+	}
 
 	resp, err := m.HTTPClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("error making HTTP request: %v", err)
 	}
-	defer resp.Body.Close()
+	drainAndClose(resp.Body)
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("failure calling Metrics API; statusCode=%d", resp.StatusCode)
@@ -0,0 +1,790 @@
+package delivery
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// propertiesTag is the struct tag read by MarshalProperties and UnmarshalProperties.
+const propertiesTag = "promoted"
+
+// PropertiesValueMarshaler is implemented by types that convert themselves into a properties value.
+type PropertiesValueMarshaler interface {
+	MarshalPropertiesValue() (*structpb.Value, error)
+}
+
+// PropertiesValueUnmarshaler is implemented by types that populate themselves from a properties value.
+type PropertiesValueUnmarshaler interface {
+	UnmarshalPropertiesValue(value *structpb.Value) error
+}
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	marshalerType   = reflect.TypeOf((*PropertiesValueMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*PropertiesValueUnmarshaler)(nil)).Elem()
+)
+
+// MarshalProperties converts a struct, pointer to struct or string-keyed map into Properties.
+//
+// Struct fields are named by their `promoted:"name,omitempty"` tag, or by the Go field name when untagged.
+// A tag of "-" skips the field and omitempty skips zero values, including the zero time.Time. Untagged
+// exported embedded structs are flattened into the parent. Nested structs and maps become structs, slices
+// and arrays become lists, numbers become doubles, []byte becomes a base64 string and time.Time becomes an
+// RFC 3339 string. Types that implement PropertiesValueMarshaler encode themselves. Encoding plans are
+// cached per type.
+func MarshalProperties(v any) (*common.Properties, error) {
+	s, err := MarshalPropertiesStruct(v)
+	if err != nil {
+		return nil, err
+	}
+	return &common.Properties{StructField: &common.Properties_Struct{Struct: s}}, nil
+}
+
+// MarshalPropertiesStruct is like MarshalProperties but returns the underlying struct.
+func MarshalPropertiesStruct(v any) (*structpb.Struct, error) {
+	if v == nil {
+		return nil, errors.New("properties: cannot marshal nil")
+	}
+	rv := reflect.ValueOf(v)
+	value, err := typeEncoder(rv.Type())(rv)
+	if err != nil {
+		return nil, err
+	}
+	s := value.GetStructValue()
+	if s == nil {
+		return nil, fmt.Errorf("properties: %s does not encode to a struct", rv.Type())
+	}
+	return s, nil
+}
+
+// UnmarshalProperties populates the struct or map pointed to by v from Properties, using the same field
+// naming rules as MarshalProperties. Keys without a matching field are ignored.
+func UnmarshalProperties(properties *common.Properties, v any) error {
+	switch field := properties.GetStructField().(type) {
+	case *common.Properties_Struct:
+		return UnmarshalPropertiesStruct(field.Struct, v)
+	case *common.Properties_StructBytes:
+		var s structpb.Struct
+		if err := proto.Unmarshal(field.StructBytes, &s); err != nil {
+			return fmt.Errorf("properties: error unmarshaling struct bytes: %v", err)
+		}
+		return UnmarshalPropertiesStruct(&s, v)
+	default:
+		return UnmarshalPropertiesStruct(nil, v)
+	}
+}
+
+// UnmarshalPropertiesStruct is like UnmarshalProperties but reads from a struct.
+func UnmarshalPropertiesStruct(s *structpb.Struct, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return errors.New("properties: unmarshal target must be a non-nil pointer")
+	}
+	if s == nil {
+		return nil
+	}
+	return typeDecoder(rv.Type().Elem())(structpb.NewStructValue(s), rv.Elem(), "")
+}
+
+// encoderFunc encodes a value of a specific type.
+type encoderFunc func(v reflect.Value) (*structpb.Value, error)
+
+// decoderFunc decodes into a settable value of a specific type. path is used in error messages.
+type decoderFunc func(value *structpb.Value, v reflect.Value, path string) error
+
+var (
+	encoderCache sync.Map // map[reflect.Type]encoderFunc
+	decoderCache sync.Map // map[reflect.Type]decoderFunc
+	fieldsCache  sync.Map // map[reflect.Type][]propertyField
+)
+
+// typeEncoder returns the cached encoder for a type, building it on first use. Recursive types resolve through
+// a placeholder that waits for the real encoder.
+func typeEncoder(t reflect.Type) encoderFunc {
+	if f, ok := encoderCache.Load(t); ok {
+		return f.(encoderFunc)
+	}
+	var (
+		wg sync.WaitGroup
+		f  encoderFunc
+	)
+	wg.Add(1)
+	placeholder, loaded := encoderCache.LoadOrStore(t, encoderFunc(func(v reflect.Value) (*structpb.Value, error) {
+		wg.Wait()
+		return f(v)
+	}))
+	if loaded {
+		return placeholder.(encoderFunc)
+	}
+	f = newTypeEncoder(t, true)
+	wg.Done()
+	encoderCache.Store(t, f)
+	return f
+}
+
+// newTypeEncoder builds an encoder. allowAddr enables pointer-receiver marshalers on addressable values.
+func newTypeEncoder(t reflect.Type, allowAddr bool) encoderFunc {
+	if t.Kind() != reflect.Pointer && allowAddr && reflect.PointerTo(t).Implements(marshalerType) {
+		return newCondAddrEncoder(addrMarshalerEncoder, newTypeEncoder(t, false))
+	}
+	if t.Implements(marshalerType) {
+		return marshalerEncoder
+	}
+	if t == timeType {
+		return timeEncoder
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return structpb.NewBoolValue(v.Bool()), nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return structpb.NewNumberValue(float64(v.Int())), nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return structpb.NewNumberValue(float64(v.Uint())), nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return floatEncoder
+	case reflect.String:
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return structpb.NewStringValue(v.String()), nil
+		}
+	case reflect.Interface:
+		return interfaceEncoder
+	case reflect.Struct:
+		return newStructEncoder(t)
+	case reflect.Map:
+		return newMapEncoder(t)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 && !reflect.PointerTo(t.Elem()).Implements(marshalerType) {
+			return bytesEncoder
+		}
+		return newSliceEncoder(t)
+	case reflect.Array:
+		return newArrayEncoder(t)
+	case reflect.Pointer:
+		return newPointerEncoder(t)
+	default:
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return nil, fmt.Errorf("properties: unsupported type %s", t)
+		}
+	}
+}
+
+func marshalerEncoder(v reflect.Value) (*structpb.Value, error) {
+	if v.Kind() == reflect.Pointer && v.IsNil() {
+		return structpb.NewNullValue(), nil
+	}
+	return v.Interface().(PropertiesValueMarshaler).MarshalPropertiesValue()
+}
+
+func addrMarshalerEncoder(v reflect.Value) (*structpb.Value, error) {
+	return v.Addr().Interface().(PropertiesValueMarshaler).MarshalPropertiesValue()
+}
+
+// newCondAddrEncoder uses canAddrEnc for addressable values and elseEnc otherwise.
+func newCondAddrEncoder(canAddrEnc, elseEnc encoderFunc) encoderFunc {
+	return func(v reflect.Value) (*structpb.Value, error) {
+		if v.CanAddr() {
+			return canAddrEnc(v)
+		}
+		return elseEnc(v)
+	}
+}
+
+func timeEncoder(v reflect.Value) (*structpb.Value, error) {
+	return structpb.NewStringValue(v.Interface().(time.Time).Format(time.RFC3339Nano)), nil
+}
+
+func floatEncoder(v reflect.Value) (*structpb.Value, error) {
+	f := v.Float()
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return nil, fmt.Errorf("properties: unsupported float value %v", f)
+	}
+	return structpb.NewNumberValue(f), nil
+}
+
+func bytesEncoder(v reflect.Value) (*structpb.Value, error) {
+	if v.IsNil() {
+		return structpb.NewNullValue(), nil
+	}
+	return structpb.NewStringValue(base64.StdEncoding.EncodeToString(v.Bytes())), nil
+}
+
+func interfaceEncoder(v reflect.Value) (*structpb.Value, error) {
+	if v.IsNil() {
+		return structpb.NewNullValue(), nil
+	}
+	elem := v.Elem()
+	return typeEncoder(elem.Type())(elem)
+}
+
+func newPointerEncoder(t reflect.Type) encoderFunc {
+	elemEnc := typeEncoder(t.Elem())
+	return func(v reflect.Value) (*structpb.Value, error) {
+		if v.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		return elemEnc(v.Elem())
+	}
+}
+
+func newSliceEncoder(t reflect.Type) encoderFunc {
+	arrayEnc := newArrayEncoder(t)
+	return func(v reflect.Value) (*structpb.Value, error) {
+		if v.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		return arrayEnc(v)
+	}
+}
+
+func newArrayEncoder(t reflect.Type) encoderFunc {
+	elemEnc := typeEncoder(t.Elem())
+	return func(v reflect.Value) (*structpb.Value, error) {
+		values := make([]*structpb.Value, v.Len())
+		for i := range values {
+			value, err := elemEnc(v.Index(i))
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			values[i] = value
+		}
+		return structpb.NewListValue(&structpb.ListValue{Values: values}), nil
+	}
+}
+
+func newMapEncoder(t reflect.Type) encoderFunc {
+	keyString, err := mapKeyFormatter(t.Key())
+	if err != nil {
+		return func(v reflect.Value) (*structpb.Value, error) {
+			return nil, err
+		}
+	}
+	elemEnc := typeEncoder(t.Elem())
+	return func(v reflect.Value) (*structpb.Value, error) {
+		if v.IsNil() {
+			return structpb.NewNullValue(), nil
+		}
+		fields := make(map[string]*structpb.Value, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := keyString(iter.Key())
+			value, err := elemEnc(iter.Value())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			fields[key] = value
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: fields}), nil
+	}
+}
+
+// mapKeyFormatter returns a function that formats map keys of string or integer kinds.
+func mapKeyFormatter(t reflect.Type) (func(v reflect.Value) string, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) string { return v.String() }, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) string { return strconv.FormatInt(v.Int(), 10) }, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(v reflect.Value) string { return strconv.FormatUint(v.Uint(), 10) }, nil
+	default:
+		return nil, fmt.Errorf("properties: unsupported map key type %s", t)
+	}
+}
+
+func newStructEncoder(t reflect.Type) encoderFunc {
+	fields := cachedPropertyFields(t)
+	encoders := make([]encoderFunc, len(fields))
+	for i, field := range fields {
+		encoders[i] = typeEncoder(field.typ)
+	}
+	return func(v reflect.Value) (*structpb.Value, error) {
+		values := make(map[string]*structpb.Value, len(fields))
+		for i, field := range fields {
+			fv, ok := fieldByIndex(v, field.index)
+			if !ok || (field.omitEmpty && isEmptyPropertyValue(fv)) {
+				continue
+			}
+			value, err := encoders[i](fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", field.name, err)
+			}
+			values[field.name] = value
+		}
+		return structpb.NewStructValue(&structpb.Struct{Fields: values}), nil
+	}
+}
+
+// fieldByIndex walks an index path, returning false when it passes through a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyPropertyValue reports whether a value is skipped by omitempty.
+func isEmptyPropertyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	case reflect.Struct:
+		if v.Type() == timeType {
+			return v.Interface().(time.Time).IsZero()
+		}
+	}
+	return false
+}
+
+// propertyField is a struct field encoded into properties.
+type propertyField struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// cachedPropertyFields returns the encoded fields of a struct type.
+func cachedPropertyFields(t reflect.Type) []propertyField {
+	if fields, ok := fieldsCache.Load(t); ok {
+		return fields.([]propertyField)
+	}
+	fields, _ := fieldsCache.LoadOrStore(t, propertyFields(t, nil, map[reflect.Type]bool{}))
+	return fields.([]propertyField)
+}
+
+// propertyFields lists the fields of a struct type, flattening untagged embedded structs. Fields of the outer
+// struct win over promoted fields with the same name.
+func propertyFields(t reflect.Type, index []int, visited map[reflect.Type]bool) []propertyField {
+	visited[t] = true
+	var fields, embedded []propertyField
+	seen := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag := sf.Tag.Get(propertiesTag)
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if sf.Anonymous && !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous && name == "" {
+			ft := sf.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && ft != timeType {
+				if !visited[ft] {
+					embedded = append(embedded, propertyFields(ft, fieldIndex, visited)...)
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		seen[name] = true
+		fields = append(fields, propertyField{
+			name:      name,
+			index:     fieldIndex,
+			typ:       sf.Type,
+			omitEmpty: hasTagOption(opts, "omitempty"),
+		})
+	}
+	for _, field := range embedded {
+		if !seen[field.name] {
+			seen[field.name] = true
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// hasTagOption reports whether a comma-separated tag option list contains the option.
+func hasTagOption(opts, option string) bool {
+	for opts != "" {
+		var opt string
+		opt, opts, _ = strings.Cut(opts, ",")
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
+
+// typeDecoder returns the cached decoder for a type, building it on first use.
+func typeDecoder(t reflect.Type) decoderFunc {
+	if f, ok := decoderCache.Load(t); ok {
+		return f.(decoderFunc)
+	}
+	var (
+		wg sync.WaitGroup
+		f  decoderFunc
+	)
+	wg.Add(1)
+	placeholder, loaded := decoderCache.LoadOrStore(t, decoderFunc(func(value *structpb.Value, v reflect.Value, path string) error {
+		wg.Wait()
+		return f(value, v, path)
+	}))
+	if loaded {
+		return placeholder.(decoderFunc)
+	}
+	f = newTypeDecoder(t)
+	wg.Done()
+	decoderCache.Store(t, f)
+	return f
+}
+
+func newTypeDecoder(t reflect.Type) decoderFunc {
+	if t.Kind() != reflect.Pointer && reflect.PointerTo(t).Implements(unmarshalerType) {
+		return unmarshalerDecoder
+	}
+	if t == timeType {
+		return timeDecoder
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			b, ok := value.Kind.(*structpb.Value_BoolValue)
+			if !ok {
+				return newDecodeError(path, value, t)
+			}
+			v.SetBool(b.BoolValue)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			n, ok := value.Kind.(*structpb.Value_NumberValue)
+			if !ok || n.NumberValue != math.Trunc(n.NumberValue) || v.OverflowInt(int64(n.NumberValue)) {
+				return newDecodeError(path, value, t)
+			}
+			v.SetInt(int64(n.NumberValue))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			n, ok := value.Kind.(*structpb.Value_NumberValue)
+			if !ok || n.NumberValue < 0 || n.NumberValue != math.Trunc(n.NumberValue) || v.OverflowUint(uint64(n.NumberValue)) {
+				return newDecodeError(path, value, t)
+			}
+			v.SetUint(uint64(n.NumberValue))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			n, ok := value.Kind.(*structpb.Value_NumberValue)
+			if !ok || v.OverflowFloat(n.NumberValue) {
+				return newDecodeError(path, value, t)
+			}
+			v.SetFloat(n.NumberValue)
+			return nil
+		}
+	case reflect.String:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			s, ok := value.Kind.(*structpb.Value_StringValue)
+			if !ok {
+				return newDecodeError(path, value, t)
+			}
+			v.SetString(s.StringValue)
+			return nil
+		}
+	case reflect.Interface:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			if t.NumMethod() != 0 {
+				return newDecodeError(path, value, t)
+			}
+			if decoded := value.AsInterface(); decoded != nil {
+				v.Set(reflect.ValueOf(decoded))
+			} else {
+				v.Set(reflect.Zero(t))
+			}
+			return nil
+		}
+	case reflect.Struct:
+		return newStructDecoder(t)
+	case reflect.Map:
+		return newMapDecoder(t)
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return bytesDecoder
+		}
+		return newSliceDecoder(t)
+	case reflect.Array:
+		return newArrayDecoder(t)
+	case reflect.Pointer:
+		return newPointerDecoder(t)
+	default:
+		return func(value *structpb.Value, v reflect.Value, path string) error {
+			return fmt.Errorf("properties: unsupported type %s at %s", t, displayPath(path))
+		}
+	}
+}
+
+// decodeNull sets v to its zero value and reports true when value is null.
+func decodeNull(value *structpb.Value, v reflect.Value) bool {
+	if _, ok := value.GetKind().(*structpb.Value_NullValue); ok || value.GetKind() == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return true
+	}
+	return false
+}
+
+func unmarshalerDecoder(value *structpb.Value, v reflect.Value, path string) error {
+	if err := v.Addr().Interface().(PropertiesValueUnmarshaler).UnmarshalPropertiesValue(value); err != nil {
+		return fmt.Errorf("properties: error decoding %s: %w", displayPath(path), err)
+	}
+	return nil
+}
+
+func timeDecoder(value *structpb.Value, v reflect.Value, path string) error {
+	if decodeNull(value, v) {
+		return nil
+	}
+	s, ok := value.Kind.(*structpb.Value_StringValue)
+	if !ok {
+		return newDecodeError(path, value, timeType)
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s.StringValue)
+	if err != nil {
+		return fmt.Errorf("properties: error decoding %s: %v", displayPath(path), err)
+	}
+	v.Set(reflect.ValueOf(parsed))
+	return nil
+}
+
+func bytesDecoder(value *structpb.Value, v reflect.Value, path string) error {
+	if decodeNull(value, v) {
+		return nil
+	}
+	s, ok := value.Kind.(*structpb.Value_StringValue)
+	if !ok {
+		return newDecodeError(path, value, v.Type())
+	}
+	b, err := base64.StdEncoding.DecodeString(s.StringValue)
+	if err != nil {
+		return fmt.Errorf("properties: error decoding %s: %v", displayPath(path), err)
+	}
+	v.SetBytes(b)
+	return nil
+}
+
+func newPointerDecoder(t reflect.Type) decoderFunc {
+	elemDec := typeDecoder(t.Elem())
+	return func(value *structpb.Value, v reflect.Value, path string) error {
+		if decodeNull(value, v) {
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return elemDec(value, v.Elem(), path)
+	}
+}
+
+func newSliceDecoder(t reflect.Type) decoderFunc {
+	elemDec := typeDecoder(t.Elem())
+	return func(value *structpb.Value, v reflect.Value, path string) error {
+		if decodeNull(value, v) {
+			return nil
+		}
+		list, ok := value.Kind.(*structpb.Value_ListValue)
+		if !ok {
+			return newDecodeError(path, value, t)
+		}
+		values := list.ListValue.GetValues()
+		slice := reflect.MakeSlice(t, len(values), len(values))
+		for i, elem := range values {
+			if err := elemDec(elem, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+}
+
+func newArrayDecoder(t reflect.Type) decoderFunc {
+	elemDec := typeDecoder(t.Elem())
+	return func(value *structpb.Value, v reflect.Value, path string) error {
+		if decodeNull(value, v) {
+			return nil
+		}
+		list, ok := value.Kind.(*structpb.Value_ListValue)
+		if !ok || len(list.ListValue.GetValues()) > t.Len() {
+			return newDecodeError(path, value, t)
+		}
+		v.Set(reflect.Zero(t))
+		for i, elem := range list.ListValue.GetValues() {
+			if err := elemDec(elem, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func newMapDecoder(t reflect.Type) decoderFunc {
+	elemDec := typeDecoder(t.Elem())
+	keyType := t.Key()
+	return func(value *structpb.Value, v reflect.Value, path string) error {
+		if decodeNull(value, v) {
+			return nil
+		}
+		s, ok := value.Kind.(*structpb.Value_StructValue)
+		if !ok {
+			return newDecodeError(path, value, t)
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(t, len(s.StructValue.GetFields())))
+		}
+		for key, fieldValue := range s.StructValue.GetFields() {
+			mapKey, err := parseMapKey(key, keyType)
+			if err != nil {
+				return fmt.Errorf("properties: error decoding %s: %v", displayPath(joinPath(path, key)), err)
+			}
+			elem := reflect.New(t.Elem()).Elem()
+			if err := elemDec(fieldValue, elem, joinPath(path, key)); err != nil {
+				return err
+			}
+			v.SetMapIndex(mapKey, elem)
+		}
+		return nil
+	}
+}
+
+// parseMapKey converts a struct key into a map key of string or integer kind.
+func parseMapKey(key string, t reflect.Type) (reflect.Value, error) {
+	mapKey := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		mapKey.SetString(key)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		mapKey.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(key, 10, t.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		mapKey.SetUint(n)
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported map key type %s", t)
+	}
+	return mapKey, nil
+}
+
+func newStructDecoder(t reflect.Type) decoderFunc {
+	fields := cachedPropertyFields(t)
+	byName := make(map[string]int, len(fields))
+	decoders := make([]decoderFunc, len(fields))
+	for i, field := range fields {
+		byName[field.name] = i
+		decoders[i] = typeDecoder(field.typ)
+	}
+	return func(value *structpb.Value, v reflect.Value, path string) error {
+		if decodeNull(value, v) {
+			return nil
+		}
+		s, ok := value.Kind.(*structpb.Value_StructValue)
+		if !ok {
+			return newDecodeError(path, value, t)
+		}
+		for key, fieldValue := range s.StructValue.GetFields() {
+			i, ok := byName[key]
+			if !ok {
+				continue
+			}
+			fv := settableFieldByIndex(v, fields[i].index)
+			if err := decoders[i](fieldValue, fv, joinPath(path, key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// settableFieldByIndex walks an index path, allocating nil embedded pointers.
+func settableFieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// newDecodeError describes a value that cannot be decoded into a type.
+func newDecodeError(path string, value *structpb.Value, t reflect.Type) error {
+	return fmt.Errorf("properties: cannot decode %s into %s at %s", valueKindName(value), t, displayPath(path))
+}
+
+// valueKindName names the kind of a properties value for error messages.
+func valueKindName(value *structpb.Value) string {
+	switch kind := value.GetKind().(type) {
+	case *structpb.Value_BoolValue:
+		return "bool"
+	case *structpb.Value_NumberValue:
+		return "number " + strconv.FormatFloat(kind.NumberValue, 'g', -1, 64)
+	case *structpb.Value_StringValue:
+		return "string"
+	case *structpb.Value_ListValue:
+		return "list"
+	case *structpb.Value_StructValue:
+		return "struct"
+	default:
+		return "null"
+	}
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "top level"
+	}
+	return path
+}
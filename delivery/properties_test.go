@@ -0,0 +1,251 @@
+package delivery
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type testMoney struct {
+	Cents    int64
+	Currency string
+}
+
+func (m testMoney) MarshalPropertiesValue() (*structpb.Value, error) {
+	return structpb.NewStringValue(strconv.FormatInt(m.Cents, 10) + " " + m.Currency), nil
+}
+
+func (m *testMoney) UnmarshalPropertiesValue(value *structpb.Value) error {
+	amount, currency, _ := strings.Cut(value.GetStringValue(), " ")
+	cents, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil {
+		return err
+	}
+	m.Cents = cents
+	m.Currency = currency
+	return nil
+}
+
+type TestAudit struct {
+	CreatedBy string `promoted:"createdBy"`
+}
+
+type testSeller struct {
+	ID     string  `promoted:"id"`
+	Rating float64 `promoted:"rating,omitempty"`
+}
+
+type testProduct struct {
+	TestAudit
+	ID         string            `promoted:"id"`
+	Title      string            `promoted:"title"`
+	Price      testMoney         `promoted:"price"`
+	Stock      int               `promoted:"stock"`
+	Discount   float32           `promoted:"discount,omitempty"`
+	Tags       []string          `promoted:"tags,omitempty"`
+	Attributes map[string]string `promoted:"attributes,omitempty"`
+	Seller     *testSeller       `promoted:"seller"`
+	ListedAt   time.Time         `promoted:"listedAt,omitempty"`
+	Thumbnail  []byte            `promoted:"thumbnail,omitempty"`
+	Extra      any               `promoted:"extra,omitempty"`
+	Internal   string            `promoted:"-"`
+	Untagged   bool
+	unexported string
+}
+
+func TestMarshalPropertiesEncodesTaggedStruct(t *testing.T) {
+	listedAt := time.Date(2024, 1, 20, 12, 30, 0, 0, time.UTC)
+	product := &testProduct{
+		TestAudit:  TestAudit{CreatedBy: "importer"},
+		ID:         "p1",
+		Title:      "Shoes",
+		Price:      testMoney{Cents: 1999, Currency: "USD"},
+		Stock:      3,
+		Tags:       []string{"red", "sale"},
+		Attributes: map[string]string{"size": "10"},
+		Seller:     &testSeller{ID: "s1"},
+		ListedAt:   listedAt,
+		Thumbnail:  []byte{1, 2, 3},
+		Extra:      map[string]any{"n": 1},
+		Internal:   "secret",
+		Untagged:   true,
+		unexported: "x",
+	}
+
+	props, err := MarshalProperties(product)
+	assert.NoError(t, err)
+
+	expected, err := structpb.NewStruct(map[string]any{
+		"createdBy":  "importer",
+		"id":         "p1",
+		"title":      "Shoes",
+		"price":      "1999 USD",
+		"stock":      3,
+		"tags":       []any{"red", "sale"},
+		"attributes": map[string]any{"size": "10"},
+		"seller":     map[string]any{"id": "s1"},
+		"listedAt":   "2024-01-20T12:30:00Z",
+		"thumbnail":  "AQID",
+		"extra":      map[string]any{"n": 1},
+		"Untagged":   true,
+	})
+	assert.NoError(t, err)
+	assert.True(t, proto.Equal(expected, props.GetStruct()), "got %v", props.GetStruct())
+}
+
+func TestMarshalPropertiesOmitEmptyAndNulls(t *testing.T) {
+	s, err := MarshalPropertiesStruct(testProduct{ID: "p1"})
+	assert.NoError(t, err)
+	assert.NotContains(t, s.Fields, "tags")
+	assert.NotContains(t, s.Fields, "listedAt")
+	assert.NotContains(t, s.Fields, "discount")
+	assert.Equal(t, structpb.NewNullValue().Kind, s.Fields["seller"].Kind)
+	assert.Equal(t, float64(0), s.Fields["stock"].GetNumberValue())
+}
+
+func TestMarshalPropertiesMap(t *testing.T) {
+	s, err := MarshalPropertiesStruct(map[int][]float64{1: {1.5}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, s.Fields["1"].GetListValue().Values[0].GetNumberValue())
+}
+
+func TestMarshalPropertiesErrors(t *testing.T) {
+	_, err := MarshalProperties(nil)
+	assert.EqualError(t, err, "properties: cannot marshal nil")
+
+	_, err = MarshalProperties("not a struct")
+	assert.EqualError(t, err, "properties: string does not encode to a struct")
+
+	_, err = MarshalProperties(struct {
+		Items []any `promoted:"items"`
+	}{Items: []any{1, make(chan int)}})
+	assert.EqualError(t, err, "items: [1]: properties: unsupported type chan int")
+
+	_, err = MarshalProperties(map[bool]string{true: "x"})
+	assert.EqualError(t, err, "properties: unsupported map key type bool")
+}
+
+type testCategory struct {
+	Name   string          `promoted:"name"`
+	Parent *testCategory   `promoted:"parent,omitempty"`
+	Child  []*testCategory `promoted:"children,omitempty"`
+}
+
+func TestPropertiesRecursiveTypes(t *testing.T) {
+	category := &testCategory{Name: "shoes", Parent: &testCategory{Name: "apparel"}}
+	props, err := MarshalProperties(category)
+	assert.NoError(t, err)
+	assert.Equal(t, "apparel", props.GetStruct().Fields["parent"].GetStructValue().Fields["name"].GetStringValue())
+
+	var decoded testCategory
+	assert.NoError(t, UnmarshalProperties(props, &decoded))
+	assert.Equal(t, *category, decoded)
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	product := testProduct{
+		TestAudit:  TestAudit{CreatedBy: "importer"},
+		ID:         "p1",
+		Title:      "Shoes",
+		Price:      testMoney{Cents: 1999, Currency: "USD"},
+		Stock:      3,
+		Discount:   0.25,
+		Tags:       []string{"red", "sale"},
+		Attributes: map[string]string{"size": "10"},
+		Seller:     &testSeller{ID: "s1", Rating: 4.5},
+		ListedAt:   time.Date(2024, 1, 20, 12, 30, 0, 5, time.UTC),
+		Thumbnail:  []byte{1, 2, 3},
+		Extra:      map[string]any{"n": float64(1)},
+		Untagged:   true,
+	}
+	props, err := MarshalProperties(product)
+	assert.NoError(t, err)
+
+	var decoded testProduct
+	assert.NoError(t, UnmarshalProperties(props, &decoded))
+	assert.Equal(t, product, decoded)
+}
+
+func TestUnmarshalPropertiesFromStructBytes(t *testing.T) {
+	s, err := structpb.NewStruct(map[string]any{"id": "p1", "stock": 2, "unknown": true})
+	assert.NoError(t, err)
+	b, err := proto.Marshal(s)
+	assert.NoError(t, err)
+
+	var decoded testProduct
+	err = UnmarshalProperties(&common.Properties{StructField: &common.Properties_StructBytes{StructBytes: b}}, &decoded)
+	assert.NoError(t, err)
+	assert.Equal(t, "p1", decoded.ID)
+	assert.Equal(t, 2, decoded.Stock)
+}
+
+func TestUnmarshalPropertiesIntoMap(t *testing.T) {
+	s, err := structpb.NewStruct(map[string]any{"a": 1, "b": 2})
+	assert.NoError(t, err)
+	decoded := map[string]int{}
+	assert.NoError(t, UnmarshalPropertiesStruct(s, &decoded))
+	assert.Equal(t, map[string]int{"a": 1, "b": 2}, decoded)
+}
+
+func TestUnmarshalPropertiesErrors(t *testing.T) {
+	var decoded testProduct
+	assert.EqualError(t, UnmarshalProperties(nil, decoded), "properties: unmarshal target must be a non-nil pointer")
+	assert.NoError(t, UnmarshalProperties(nil, &decoded))
+
+	tests := []struct {
+		name   string
+		fields map[string]any
+		err    string
+	}{
+		{"wrong kind", map[string]any{"product": map[string]any{"title": 1}}, "properties: cannot decode number 1 into string at product.title"},
+		{"fractional int", map[string]any{"product": map[string]any{"stock": 1.5}}, "properties: cannot decode number 1.5 into int at product.stock"},
+		{"negative uint", map[string]any{"n": -1}, "properties: cannot decode number -1 into uint8 at n"},
+		{"overflow", map[string]any{"n": 256}, "properties: cannot decode number 256 into uint8 at n"},
+		{"nested path", map[string]any{"product": map[string]any{"seller": map[string]any{"rating": "high"}}}, "properties: cannot decode string into float64 at product.seller.rating"},
+		{"list element", map[string]any{"product": map[string]any{"tags": []any{"a", true}}}, "properties: cannot decode bool into string at product.tags[1]"},
+		{"bad time", map[string]any{"product": map[string]any{"listedAt": "yesterday"}}, "properties: error decoding product.listedAt: parsing time \"yesterday\" as \"2006-01-02T15:04:05.999999999Z07:00\": cannot parse \"yesterday\" as \"2006\""},
+		{"custom unmarshaler", map[string]any{"product": map[string]any{"price": "lots USD"}}, "properties: error decoding product.price: strconv.ParseInt: parsing \"lots\": invalid syntax"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := structpb.NewStruct(tc.fields)
+			assert.NoError(t, err)
+			var target struct {
+				Product testProduct `promoted:"product"`
+				N       uint8       `promoted:"n"`
+			}
+			assert.EqualError(t, UnmarshalPropertiesStruct(s, &target), tc.err)
+		})
+	}
+}
+
+func BenchmarkMarshalProperties1000Insertions(b *testing.B) {
+	products := make([]testProduct, 1000)
+	for i := range products {
+		products[i] = testProduct{
+			ID:         strconv.Itoa(i),
+			Title:      "Product " + strconv.Itoa(i),
+			Price:      testMoney{Cents: int64(i * 100), Currency: "USD"},
+			Stock:      i,
+			Tags:       []string{"a", "b"},
+			Attributes: map[string]string{"size": "10"},
+			Seller:     &testSeller{ID: "s", Rating: 4.5},
+			ListedAt:   time.Unix(int64(i), 0),
+		}
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		for i := range products {
+			if _, err := MarshalProperties(&products[i]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
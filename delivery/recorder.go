@@ -0,0 +1,300 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Record is a call written by a Recorder. It is written as a length-delimited message of its own, with the
+// DeliveryLog as one field, so the fields a Recorder adds don't depend on the DeliveryLog schema.
+type Record struct {
+	// DeliveryLog holds the request, the Delivery API response and the execution.
+	DeliveryLog *delivery.DeliveryLog
+
+	// Experiment is the cohort ID and arm of the request's experiment, may be nil.
+	Experiment *event.CohortMembership
+
+	// OnlyLog is the request's OnlyLog.
+	OnlyLog bool
+
+	// RetrievalInsertionOffset is the request's RetrievalInsertionOffset.
+	RetrievalInsertionOffset int
+}
+
+// Field numbers of the Record message.
+const (
+	recordDeliveryLogField              protowire.Number = 1
+	recordExperimentField               protowire.Number = 2
+	recordOnlyLogField                  protowire.Number = 3
+	recordRetrievalInsertionOffsetField protowire.Number = 4
+)
+
+// maxRecordSize is the largest record that is read, as for protodelim.
+const maxRecordSize = 4 << 20
+
+// DeliveryRequest returns the recorded DeliveryRequest, so a Replayer can plan it as it was delivered.
+func (r *Record) DeliveryRequest() *DeliveryRequest {
+	return &DeliveryRequest{
+		Request:                  r.DeliveryLog.GetRequest(),
+		OnlyLog:                  r.OnlyLog,
+		RetrievalInsertionOffset: r.RetrievalInsertionOffset,
+		Experiment:               r.Experiment,
+	}
+}
+
+// marshal appends the length-delimited record to b.
+func (r *Record) marshal(b []byte) ([]byte, error) {
+	var m []byte
+	if r.DeliveryLog != nil {
+		deliveryLog, err := proto.Marshal(r.DeliveryLog)
+		if err != nil {
+			return nil, err
+		}
+		m = protowire.AppendTag(m, recordDeliveryLogField, protowire.BytesType)
+		m = protowire.AppendBytes(m, deliveryLog)
+	}
+	if r.Experiment != nil {
+		experiment, err := proto.Marshal(r.Experiment)
+		if err != nil {
+			return nil, err
+		}
+		m = protowire.AppendTag(m, recordExperimentField, protowire.BytesType)
+		m = protowire.AppendBytes(m, experiment)
+	}
+	if r.OnlyLog {
+		m = protowire.AppendTag(m, recordOnlyLogField, protowire.VarintType)
+		m = protowire.AppendVarint(m, protowire.EncodeBool(true))
+	}
+	if r.RetrievalInsertionOffset != 0 {
+		m = protowire.AppendTag(m, recordRetrievalInsertionOffsetField, protowire.VarintType)
+		m = protowire.AppendVarint(m, protowire.EncodeZigZag(int64(r.RetrievalInsertionOffset)))
+	}
+	return protowire.AppendBytes(b, m), nil
+}
+
+// unmarshal reads the record message from b. Unknown fields are skipped.
+func (r *Record) unmarshal(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == recordDeliveryLogField && typ == protowire.BytesType:
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				r.DeliveryLog = &delivery.DeliveryLog{}
+				if err := proto.Unmarshal(v, r.DeliveryLog); err != nil {
+					return err
+				}
+			}
+		case num == recordExperimentField && typ == protowire.BytesType:
+			var v []byte
+			if v, n = protowire.ConsumeBytes(b); n >= 0 {
+				r.Experiment = &event.CohortMembership{}
+				if err := proto.Unmarshal(v, r.Experiment); err != nil {
+					return err
+				}
+			}
+		case num == recordOnlyLogField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			r.OnlyLog = protowire.DecodeBool(v)
+		case num == recordRetrievalInsertionOffsetField && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			r.RetrievalInsertionOffset = int(protowire.DecodeZigZag(v))
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+// Redactor removes sensitive fields from a request before the Recorder writes it. It is called on a copy.
+type Redactor func(req *delivery.Request)
+
+// RedactUserInfo is a Redactor that clears the user IDs, IP address, location and user agent.
+func RedactUserInfo(req *delivery.Request) {
+	if req.UserInfo != nil {
+		req.UserInfo.UserId = ""
+		req.UserInfo.AnonUserId = ""
+		req.UserInfo.RetainedUserId = ""
+	}
+	if req.Device != nil {
+		req.Device.IpAddress = ""
+		req.Device.Location = nil
+		if req.Device.Browser != nil {
+			req.Device.Browser.UserAgent = ""
+		}
+	}
+}
+
+// Recorder is a DeliveryAPI decorator that writes sampled request and response pairs to w as length-delimited
+// Records, which a Replayer can read back. Failed calls and shadow traffic are not recorded.
+type Recorder struct {
+	// api is the decorated Delivery API.
+	api DeliveryAPI
+
+	// sampleRate is the fraction of calls in [0, 1] that are recorded.
+	sampleRate float32
+
+	// redactor is applied to each recorded request, if set.
+	redactor Redactor
+
+	mu      sync.Mutex
+	w       io.Writer
+	sampler Sampler
+}
+
+// NewRecorder creates a Recorder that records sampleRate of the calls to api. redactor may be nil.
+func NewRecorder(api DeliveryAPI, w io.Writer, sampleRate float32, redactor Redactor) *Recorder {
+	return &Recorder{
+		api:        api,
+		w:          w,
+		sampleRate: sampleRate,
+		redactor:   redactor,
+		sampler:    NewDefaultSampler(),
+	}
+}
+
+// WithSampler replaces the random sampler, mostly for testing.
+func (r *Recorder) WithSampler(sampler Sampler) *Recorder {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sampler = sampler
+	return r
+}
+
+// RunDelivery calls the decorated API and records the request and response when sampled. Recording errors are
+// logged and do not fail the call.
+func (r *Recorder) RunDelivery(deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	return r.RunDeliveryContext(context.Background(), deliveryRequest)
+}
+
+// RunDeliveryContext is like RunDelivery, but passes ctx to the decorated API if it is a ContextDeliveryAPI.
+func (r *Recorder) RunDeliveryContext(ctx context.Context, deliveryRequest *DeliveryRequest) (*delivery.Response, error) {
+	resp, err := runDeliveryContext(ctx, r.api, deliveryRequest)
+	if err != nil || deliveryRequest.Request.GetClientInfo().GetTrafficType() == common.ClientInfo_SHADOW {
+		return resp, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.sampler.SampleRandom(r.sampleRate) {
+		return resp, nil
+	}
+	req := deliveryRequest.Request
+	if r.redactor != nil {
+		req = proto.Clone(req).(*delivery.Request)
+		r.redactor(req)
+	}
+	record := &Record{
+		DeliveryLog: &delivery.DeliveryLog{
+			Request:  req,
+			Response: resp,
+			Execution: &delivery.DeliveryExecution{
+				ExecutionServer: delivery.ExecutionServer_API,
+				ServerVersion:   serverVersion,
+			},
+		},
+		OnlyLog:                  deliveryRequest.OnlyLog,
+		RetrievalInsertionOffset: deliveryRequest.RetrievalInsertionOffset,
+	}
+	// Only the experiment's cohort ID and arm decide the plan.
+	if experiment := deliveryRequest.Experiment; experiment != nil {
+		record.Experiment = &event.CohortMembership{CohortId: experiment.CohortId, Arm: experiment.Arm}
+	}
+	b, err := record.marshal(nil)
+	if err == nil {
+		_, err = r.w.Write(b)
+	}
+	if err != nil {
+		log.Printf("Error recording delivery request: %v\n", err)
+	}
+	return resp, nil
+}
+
+// HealthCheck passes the health check through to the decorated API, so a Recorder can be used with warmup and
+// keep-alive.
+func (r *Recorder) HealthCheck(ctx context.Context) error {
+	healthChecker, ok := r.api.(HealthChecker)
+	if !ok {
+		return errors.New("the recorded Delivery API does not support health checks")
+	}
+	return healthChecker.HealthCheck(ctx)
+}
+
+// RecordingAPIFactory wraps an APIFactory so the Delivery API it creates is decorated with a Recorder.
+type RecordingAPIFactory struct {
+	// Base creates the API clients. Defaults to DefaultAPIFactory.
+	Base APIFactory
+
+	// Writer receives the recorded calls.
+	Writer io.Writer
+
+	// SampleRate is the fraction of calls in [0, 1] that are recorded.
+	SampleRate float32
+
+	// Redactor is applied to each recorded request, if set.
+	Redactor Redactor
+}
+
+// CreateSDKDelivery creates an SDK delivery instance with the base factory.
+func (f *RecordingAPIFactory) CreateSDKDelivery() DeliveryAPI {
+	return f.base().CreateSDKDelivery()
+}
+
+// CreateDeliveryAPI creates an API delivery instance with the base factory and wraps it in a Recorder.
+func (f *RecordingAPIFactory) CreateDeliveryAPI(endpoint, apiKey string, timeoutMillis int64, maxRequestInsertions int, acceptGzip, warmup bool) DeliveryAPI {
+	api := f.base().CreateDeliveryAPI(endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+	return NewRecorder(api, f.Writer, f.SampleRate, f.Redactor)
+}
+
+// CreateMetricsAPI creates an API metrics instance with the base factory.
+func (f *RecordingAPIFactory) CreateMetricsAPI(endpoint, apiKey string, timeoutMillis int64) MetricsAPI {
+	return f.base().CreateMetricsAPI(endpoint, apiKey, timeoutMillis)
+}
+
+// CreateDeliveryAPIWithHTTPClient creates an API delivery instance with the base factory and wraps it in a
+// Recorder. httpClient is only used when the base factory is an HTTPAPIFactory.
+func (f *RecordingAPIFactory) CreateDeliveryAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64, maxRequestInsertions int, acceptGzip, warmup bool) DeliveryAPI {
+	var api DeliveryAPI
+	if base, ok := f.base().(HTTPAPIFactory); ok {
+		api = base.CreateDeliveryAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+	} else {
+		api = f.base().CreateDeliveryAPI(endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+	}
+	return NewRecorder(api, f.Writer, f.SampleRate, f.Redactor)
+}
+
+// CreateMetricsAPIWithHTTPClient creates an API metrics instance with the base factory. httpClient is only used
+// when the base factory is an HTTPAPIFactory.
+func (f *RecordingAPIFactory) CreateMetricsAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64) MetricsAPI {
+	if base, ok := f.base().(HTTPAPIFactory); ok {
+		return base.CreateMetricsAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis)
+	}
+	return f.base().CreateMetricsAPI(endpoint, apiKey, timeoutMillis)
+}
+
+func (f *RecordingAPIFactory) base() APIFactory {
+	if f.Base == nil {
+		return &DefaultAPIFactory{}
+	}
+	return f.Base
+}
@@ -0,0 +1,179 @@
+package delivery
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func readTestRecords(t *testing.T, buf *bytes.Buffer) []*Record {
+	var records []*Record
+	err := ReadRecords(buf, func(record *Record) error {
+		records = append(records, record)
+		return nil
+	})
+	assert.NoError(t, err)
+	return records
+}
+
+func TestRecorderWritesSampledCalls(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{
+		RequestId: "r",
+		Insertion: CreateTestResponseInsertions(2, 0),
+	}, nil)
+
+	var buf bytes.Buffer
+	sampler := &FakeSampler{samplesIn: true}
+	recorder := NewRecorder(mockApiDelivery, &buf, 0.5, nil).WithSampler(sampler)
+
+	req := &DeliveryRequest{Request: &delivery.Request{ClientRequestId: "c1", Insertion: CreateTestRequestInsertions(2)}}
+	resp, err := recorder.RunDelivery(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "r", resp.RequestId)
+
+	sampler.samplesIn = false
+	_, err = recorder.RunDelivery(req)
+	assert.NoError(t, err)
+
+	records := readTestRecords(t, &buf)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, "c1", records[0].DeliveryLog.Request.ClientRequestId)
+	assert.Equal(t, "r", records[0].DeliveryLog.Response.RequestId)
+	assert.Equal(t, 2, len(records[0].DeliveryLog.Response.Insertion))
+	assert.Equal(t, delivery.ExecutionServer_API, records[0].DeliveryLog.Execution.ExecutionServer)
+}
+
+func TestRecorderRedactsCopy(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "r"}, nil)
+
+	var buf bytes.Buffer
+	recorder := NewRecorder(mockApiDelivery, &buf, 1, RedactUserInfo)
+	req := &DeliveryRequest{Request: &delivery.Request{
+		UserInfo: &common.UserInfo{UserId: "u", AnonUserId: "a"},
+		Device:   &common.Device{IpAddress: "1.2.3.4", Browser: &common.Browser{UserAgent: "ua", Referrer: "ref"}},
+	}}
+	_, err := recorder.RunDelivery(req)
+	assert.NoError(t, err)
+
+	records := readTestRecords(t, &buf)
+	assert.Equal(t, "", records[0].DeliveryLog.Request.UserInfo.UserId)
+	assert.Equal(t, "", records[0].DeliveryLog.Request.UserInfo.AnonUserId)
+	assert.Equal(t, "", records[0].DeliveryLog.Request.Device.IpAddress)
+	assert.Equal(t, "", records[0].DeliveryLog.Request.Device.Browser.UserAgent)
+	assert.Equal(t, "ref", records[0].DeliveryLog.Request.Device.Browser.Referrer)
+
+	// The caller's request is not modified.
+	assert.Equal(t, "u", req.Request.UserInfo.UserId)
+	assert.Equal(t, "1.2.3.4", req.Request.Device.IpAddress)
+}
+
+func TestRecorderSkipsFailedCalls(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return((*delivery.Response)(nil), errors.New("boom"))
+
+	var buf bytes.Buffer
+	_, err := NewRecorder(mockApiDelivery, &buf, 1, nil).RunDelivery(&DeliveryRequest{Request: &delivery.Request{}})
+	assert.EqualError(t, err, "boom")
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestRecorderSkipsShadowTraffic(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "r"}, nil)
+
+	var buf bytes.Buffer
+	req := &DeliveryRequest{Request: &delivery.Request{ClientInfo: &common.ClientInfo{TrafficType: common.ClientInfo_SHADOW}}}
+	_, err := NewRecorder(mockApiDelivery, &buf, 1, nil).RunDelivery(req)
+	assert.NoError(t, err)
+	mockApiDelivery.AssertNumberOfCalls(t, "RunDelivery", 1)
+	assert.Equal(t, 0, buf.Len())
+}
+
+func TestRecordRoundTrip(t *testing.T) {
+	record := &Record{
+		DeliveryLog:              &delivery.DeliveryLog{Request: &delivery.Request{ClientRequestId: "c1"}},
+		Experiment:               &event.CohortMembership{CohortId: "c", Arm: event.CohortArm_CONTROL},
+		OnlyLog:                  true,
+		RetrievalInsertionOffset: -1,
+	}
+	b, err := record.marshal(nil)
+	assert.NoError(t, err)
+	records := readTestRecords(t, bytes.NewBuffer(b))
+	assert.Equal(t, 1, len(records))
+	assert.True(t, proto.Equal(record.DeliveryLog, records[0].DeliveryLog))
+	assert.True(t, proto.Equal(record.Experiment, records[0].Experiment))
+	assert.True(t, records[0].OnlyLog)
+	assert.Equal(t, -1, records[0].RetrievalInsertionOffset)
+
+	deliveryRequest := records[0].DeliveryRequest()
+	assert.Equal(t, "c1", deliveryRequest.Request.ClientRequestId)
+	assert.Equal(t, event.CohortArm_CONTROL, deliveryRequest.Experiment.Arm)
+	assert.True(t, deliveryRequest.OnlyLog)
+	assert.Equal(t, -1, deliveryRequest.RetrievalInsertionOffset)
+
+	// Unknown fields, as written by later versions, are skipped.
+	b = protowire.AppendTag(nil, 99, protowire.VarintType)
+	b = protowire.AppendVarint(b, 1)
+	unknown := &Record{}
+	assert.NoError(t, unknown.unmarshal(b))
+	assert.Nil(t, unknown.DeliveryLog)
+}
+
+func TestRecordingAPIFactoryWrapsDeliveryAPI(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "r"}, nil)
+	mockMetrics := new(MockMetrics)
+
+	var buf bytes.Buffer
+	factory := &RecordingAPIFactory{
+		Base:       &TestApiFactory{deliveryAPI: mockApiDelivery, metricsAPI: mockMetrics},
+		Writer:     &buf,
+		SampleRate: 1,
+	}
+	client, err := newTestClientBuilder().WithAPIFactory(factory).Build()
+	assert.NoError(t, err)
+
+	_, err = client.Deliver(&DeliveryRequest{Request: &delivery.Request{UserInfo: &common.UserInfo{AnonUserId: "a"}}})
+	assert.NoError(t, err)
+	records := readTestRecords(t, &buf)
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, common.ClientInfo_PRODUCTION, records[0].DeliveryLog.Request.ClientInfo.TrafficType)
+	assert.Equal(t, mockMetrics, factory.CreateMetricsAPI("", "", 0))
+}
+
+func TestRecordingAPIFactoryPassesHTTPClient(t *testing.T) {
+	httpClient := &http.Client{}
+	factory := &RecordingAPIFactory{Writer: &bytes.Buffer{}, SampleRate: 1}
+	api := factory.CreateDeliveryAPIWithHTTPClient(httpClient, "https://delivery.example.com", "key", 250, 10, false, false)
+	assert.Same(t, httpClient, api.(*Recorder).api.(*PromotedDeliveryAPI).httpClient)
+	metricsAPI := factory.CreateMetricsAPIWithHTTPClient(httpClient, "https://metrics.example.com", "key", 3000)
+	assert.Same(t, httpClient, metricsAPI.(*PromotedMetricsAPI).HTTPClient)
+}
+
+func TestRecorderHealthCheck(t *testing.T) {
+	var healthChecks int
+	recorder := NewRecorder(&healthCheckingDelivery{healthCheckFunc: func() { healthChecks++ }}, &bytes.Buffer{}, 1, nil)
+	assert.NoError(t, recorder.HealthCheck(context.Background()))
+	assert.Equal(t, 1, healthChecks)
+
+	recorder = NewRecorder(new(MockDelivery), &bytes.Buffer{}, 1, nil)
+	assert.EqualError(t, recorder.HealthCheck(context.Background()), "the recorded Delivery API does not support health checks")
+}
+
+// healthCheckingDelivery is a MockDelivery that also implements HealthChecker.
+type healthCheckingDelivery struct {
+	MockDelivery
+	healthCheckFunc
+}
@@ -0,0 +1,166 @@
+package delivery
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+)
+
+// ReadRecords calls fn with each Record written by a Recorder, stopping at the first error.
+func ReadRecords(r io.Reader, fn func(record *Record) error) error {
+	reader := bufio.NewReader(r)
+	for {
+		size, err := binary.ReadUvarint(reader)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("error reading recorded delivery: %v", err)
+		}
+		if size > maxRecordSize {
+			return fmt.Errorf("error reading recorded delivery: record size %d exceeds %d", size, maxRecordSize)
+		}
+		b := make([]byte, size)
+		if _, err := io.ReadFull(reader, b); err != nil {
+			return fmt.Errorf("error reading recorded delivery: %v", err)
+		}
+		record := &Record{}
+		if err := record.unmarshal(b); err != nil {
+			return fmt.Errorf("error reading recorded delivery: %v", err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+}
+
+// ReplayDifference is a difference between a recorded response and its replayed response.
+type ReplayDifference struct {
+	// Record is the zero-based index of the record.
+	Record int
+
+	// ClientRequestID is the client request ID of the recorded request.
+	ClientRequestID string
+
+	// Field is the response field that differs, e.g. "insertion[2].contentId".
+	Field string
+
+	// Recorded is the recorded value.
+	Recorded string
+
+	// Replayed is the replayed value.
+	Replayed string
+}
+
+// String formats the difference for reports.
+func (d ReplayDifference) String() string {
+	return fmt.Sprintf("record %d (clientRequestId=%s): %s recorded=%s replayed=%s", d.Record, d.ClientRequestID, d.Field, d.Recorded, d.Replayed)
+}
+
+// ReplayReport summarizes a replay.
+type ReplayReport struct {
+	// Records is the number of records replayed.
+	Records int
+
+	// Matched is the number of records whose replayed response had the same insertions at the same positions.
+	Matched int
+
+	// Differences lists every difference, in record order.
+	Differences []ReplayDifference
+}
+
+// Replayer sends recorded requests back through a PromotedDeliveryClient and compares the responses.
+type Replayer struct {
+	client *PromotedDeliveryClient
+}
+
+// NewReplayer creates a Replayer that delivers with client. Point the client at a fake server or staging, not
+// production.
+func NewReplayer(client *PromotedDeliveryClient) *Replayer {
+	return &Replayer{client: client}
+}
+
+// Replay delivers every recorded request read from r and reports where the responses differ. Requests are sent
+// with the experiment, OnlyLog and RetrievalInsertionOffset they were recorded with, so they are planned as in
+// production. They use the REPLAY traffic type and a new client request ID. Insertion and request IDs are
+// expected to change and are not compared. The error is only set when the records cannot be read.
+func (r *Replayer) Replay(reader io.Reader) (*ReplayReport, error) {
+	report := &ReplayReport{}
+	err := ReadRecords(reader, func(record *Record) error {
+		index := report.Records
+		report.Records++
+
+		deliveryRequest := record.DeliveryRequest()
+		req := deliveryRequest.Request
+		if req == nil {
+			req = &delivery.Request{}
+			deliveryRequest.Request = req
+		}
+		if req.ClientInfo == nil {
+			req.ClientInfo = &common.ClientInfo{}
+		}
+		req.ClientInfo.TrafficType = common.ClientInfo_REPLAY
+		clientRequestID := req.GetClientRequestId()
+		req.ClientRequestId = ""
+
+		resp, err := r.client.Deliver(deliveryRequest)
+		differences := compareResponses(record.DeliveryLog.GetResponse(), resp, err)
+		for i := range differences {
+			differences[i].Record = index
+			differences[i].ClientRequestID = clientRequestID
+		}
+		if len(differences) == 0 {
+			report.Matched++
+		}
+		report.Differences = append(report.Differences, differences...)
+		return nil
+	})
+	return report, err
+}
+
+// compareResponses lists the differences between a recorded Delivery API response and a replayed response.
+func compareResponses(recorded *delivery.Response, replayed *DeliveryResponse, err error) []ReplayDifference {
+	if err != nil {
+		return []ReplayDifference{{Field: "error", Recorded: "", Replayed: err.Error()}}
+	}
+	var differences []ReplayDifference
+	if replayed.ExecutionServer != delivery.ExecutionServer_API {
+		differences = append(differences, ReplayDifference{
+			Field:    "executionServer",
+			Recorded: delivery.ExecutionServer_API.String(),
+			Replayed: replayed.ExecutionServer.String(),
+		})
+	}
+	recordedInsertions := recorded.GetInsertion()
+	replayedInsertions := replayed.Response.GetInsertion()
+	if len(recordedInsertions) != len(replayedInsertions) {
+		differences = append(differences, ReplayDifference{
+			Field:    "insertion.length",
+			Recorded: fmt.Sprint(len(recordedInsertions)),
+			Replayed: fmt.Sprint(len(replayedInsertions)),
+		})
+	}
+	for i := 0; i < min(len(recordedInsertions), len(replayedInsertions)); i++ {
+		rec, rep := recordedInsertions[i], replayedInsertions[i]
+		if rec.GetContentId() != rep.GetContentId() {
+			differences = append(differences, ReplayDifference{
+				Field:    fmt.Sprintf("insertion[%d].contentId", i),
+				Recorded: rec.GetContentId(),
+				Replayed: rep.GetContentId(),
+			})
+		}
+		if rec.GetPosition() != rep.GetPosition() {
+			differences = append(differences, ReplayDifference{
+				Field:    fmt.Sprintf("insertion[%d].position", i),
+				Recorded: fmt.Sprint(rec.GetPosition()),
+				Replayed: fmt.Sprint(rep.GetPosition()),
+			})
+		}
+	}
+	return differences
+}
@@ -0,0 +1,150 @@
+package delivery
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func writeTestRecord(t *testing.T, buf *bytes.Buffer, clientRequestID string, resp *delivery.Response) {
+	record := &Record{DeliveryLog: &delivery.DeliveryLog{
+		Request: &delivery.Request{
+			ClientRequestId: clientRequestID,
+			SearchQuery:     clientRequestID,
+			UserInfo:        &common.UserInfo{AnonUserId: "a"},
+			Insertion:       CreateTestRequestInsertions(3),
+		},
+		Response: resp,
+	}}
+	b, err := record.marshal(nil)
+	assert.NoError(t, err)
+	buf.Write(b)
+}
+
+func createReplayClient(t *testing.T, api DeliveryAPI) *PromotedDeliveryClient {
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: api, metricsAPI: new(MockMetrics), sdkDelivery: NewSDKDelivery()}).
+		Build()
+	assert.NoError(t, err)
+	return client
+}
+
+func TestReplayerReportsDifferences(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestRecord(t, &buf, "same", &delivery.Response{RequestId: "r1", Insertion: CreateTestResponseInsertions(3, 0)})
+	writeTestRecord(t, &buf, "reordered", &delivery.Response{RequestId: "r2", Insertion: CreateTestResponseInsertions(3, 0)})
+
+	reordered := CreateTestResponseInsertions(2, 0)
+	reordered[0].ContentId, reordered[1].ContentId = "1", "0"
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.MatchedBy(func(req *DeliveryRequest) bool {
+		return req.Request.SearchQuery == "same"
+	})).Return(&delivery.Response{RequestId: "new1", Insertion: CreateTestResponseInsertions(3, 0)}, nil)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "new2", Insertion: reordered}, nil)
+
+	report, err := NewReplayer(createReplayClient(t, mockApiDelivery)).Replay(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, report.Records)
+	assert.Equal(t, 1, report.Matched)
+	assert.Equal(t, []ReplayDifference{
+		{Record: 1, ClientRequestID: "reordered", Field: "insertion.length", Recorded: "3", Replayed: "2"},
+		{Record: 1, ClientRequestID: "reordered", Field: "insertion[0].contentId", Recorded: "0", Replayed: "1"},
+		{Record: 1, ClientRequestID: "reordered", Field: "insertion[1].contentId", Recorded: "1", Replayed: "0"},
+	}, report.Differences)
+	assert.Equal(t, "record 1 (clientRequestId=reordered): insertion.length recorded=3 replayed=2", report.Differences[0].String())
+
+	// Replayed requests are marked as replay traffic and get new client request IDs.
+	for _, call := range mockApiDelivery.Calls {
+		req := call.Arguments.Get(0).(*DeliveryRequest).Request
+		assert.Equal(t, common.ClientInfo_REPLAY, req.ClientInfo.TrafficType)
+		assert.NotEqual(t, req.SearchQuery, req.ClientRequestId)
+		assert.NotEmpty(t, req.ClientRequestId)
+	}
+}
+
+func TestReplayerRestoresRecordedRequestFields(t *testing.T) {
+	recordedAPI := new(MockDelivery)
+	recordedAPI.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "r", Insertion: CreateTestResponseInsertions(3, 10)}, nil)
+	var buf bytes.Buffer
+	recorder := NewRecorder(recordedAPI, &buf, 1, nil)
+	request := func(clientRequestID string) *delivery.Request {
+		return &delivery.Request{
+			ClientRequestId: clientRequestID,
+			SearchQuery:     clientRequestID,
+			UserInfo:        &common.UserInfo{AnonUserId: "a"},
+			Insertion:       CreateTestRequestInsertions(3),
+		}
+	}
+	treatment := &event.CohortMembership{CohortId: "c", Arm: event.CohortArm_TREATMENT, UserInfo: &common.UserInfo{UserId: "u"}}
+	_, err := recorder.RunDelivery(&DeliveryRequest{Request: request("treatment"), Experiment: treatment, RetrievalInsertionOffset: 10})
+	assert.NoError(t, err)
+	// Shadow traffic for requests that were delivered by the SDK is not recorded.
+	shadow := request("control")
+	shadow.ClientInfo = &common.ClientInfo{TrafficType: common.ClientInfo_SHADOW}
+	control := &event.CohortMembership{CohortId: "c", Arm: event.CohortArm_CONTROL}
+	_, err = recorder.RunDelivery(&DeliveryRequest{Request: shadow, Experiment: control})
+	assert.NoError(t, err)
+
+	// Only the cohort ID and arm of the experiment are kept.
+	records := readTestRecords(t, bytes.NewBuffer(buf.Bytes()))
+	assert.Equal(t, 1, len(records))
+	recorded := records[0].DeliveryRequest()
+	assert.Equal(t, "c", recorded.Experiment.CohortId)
+	assert.Equal(t, event.CohortArm_TREATMENT, recorded.Experiment.Arm)
+	assert.Nil(t, recorded.Experiment.UserInfo)
+	assert.Equal(t, 10, recorded.RetrievalInsertionOffset)
+	assert.False(t, recorded.OnlyLog)
+
+	replayAPI := new(MockDelivery)
+	replayAPI.On("RunDelivery", mock.Anything).Return(&delivery.Response{RequestId: "new", Insertion: CreateTestResponseInsertions(3, 10)}, nil)
+	mockMetrics := new(MockMetrics)
+	mockMetrics.On("RunMetricsLogging", mock.Anything).Return(nil)
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: replayAPI, metricsAPI: mockMetrics, sdkDelivery: NewSDKDelivery()}).
+		Build()
+	assert.NoError(t, err)
+
+	report, err := NewReplayer(client).Replay(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Records)
+	assert.Equal(t, 1, report.Matched)
+	assert.Empty(t, report.Differences)
+
+	// The treatment request is planned to use the Delivery API, with its recorded fields.
+	replayAPI.AssertNumberOfCalls(t, "RunDelivery", 1)
+	replayed := replayAPI.Calls[0].Arguments.Get(0).(*DeliveryRequest)
+	assert.Equal(t, "treatment", replayed.Request.SearchQuery)
+	assert.Equal(t, event.CohortArm_TREATMENT, replayed.Experiment.Arm)
+	assert.Equal(t, 10, replayed.RetrievalInsertionOffset)
+}
+
+func TestReplayerReportsSDKFallback(t *testing.T) {
+	var buf bytes.Buffer
+	writeTestRecord(t, &buf, "c", &delivery.Response{RequestId: "r", Insertion: CreateTestResponseInsertions(3, 0)})
+
+	mockApiDelivery := new(MockDelivery)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return((*delivery.Response)(nil), errors.New("unavailable"))
+	mockMetrics := new(MockMetrics)
+	mockMetrics.On("RunMetricsLogging", mock.Anything).Return(nil)
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{deliveryAPI: mockApiDelivery, metricsAPI: mockMetrics, sdkDelivery: NewSDKDelivery()}).
+		Build()
+	assert.NoError(t, err)
+
+	report, err := NewReplayer(client).Replay(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matched)
+	assert.Equal(t, "executionServer", report.Differences[0].Field)
+	assert.Equal(t, "SDK", report.Differences[0].Replayed)
+}
+
+func TestReplayerReadError(t *testing.T) {
+	_, err := NewReplayer(createReplayClient(t, new(MockDelivery))).Replay(bytes.NewReader([]byte{0x05, 0x01}))
+	assert.ErrorContains(t, err, "error reading recorded delivery")
+}
@@ -0,0 +1,305 @@
+package delivery
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// InsertionOption sets optional fields on a request insertion added with RequestBuilder.AddInsertion. An option
+// that returns an *InsertionOptionError is reported at its field and rule, and any other error under RuleCustom
+// at the insertion.
+type InsertionOption func(ins *delivery.Insertion) error
+
+// InsertionOptionError is an InsertionOption failure on one field of the insertion.
+type InsertionOptionError struct {
+	// Field is the path of the field within the insertion, e.g. "properties".
+	Field string
+
+	// Rule is the rule code reported on the issue.
+	Rule string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns the underlying error's message.
+func (e *InsertionOptionError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *InsertionOptionError) Unwrap() error {
+	return e.Err
+}
+
+// WithInsertionProperties sets custom properties on the insertion.
+func WithInsertionProperties(properties map[string]any) InsertionOption {
+	return func(ins *delivery.Insertion) error {
+		props, err := newProperties(properties)
+		if err != nil {
+			return &InsertionOptionError{Field: "properties", Rule: RuleInvalidProperties, Err: err}
+		}
+		ins.Properties = props
+		return nil
+	}
+}
+
+// WithInsertionPropertiesFrom sets custom properties on the insertion from a tagged struct, see MarshalProperties.
+func WithInsertionPropertiesFrom(v any) InsertionOption {
+	return func(ins *delivery.Insertion) error {
+		props, err := MarshalProperties(v)
+		if err != nil {
+			return &InsertionOptionError{Field: "properties", Rule: RuleInvalidProperties, Err: err}
+		}
+		ins.Properties = props
+		return nil
+	}
+}
+
+// WithRetrievalRank sets the original ranking of the content item.
+func WithRetrievalRank(retrievalRank uint64) InsertionOption {
+	return func(ins *delivery.Insertion) error {
+		ins.RetrievalRank = &retrievalRank
+		return nil
+	}
+}
+
+// WithRetrievalScore sets the original quality score of the content item.
+func WithRetrievalScore(retrievalScore float32) InsertionOption {
+	return func(ins *delivery.Insertion) error {
+		ins.RetrievalScore = &retrievalScore
+		return nil
+	}
+}
+
+// RequestBuilder builds a DeliveryRequest, checking each input as it is added. Problems are collected
+// and returned together from Build.
+type RequestBuilder struct {
+	request                  *delivery.Request
+	experiment               *event.CohortMembership
+	onlyLog                  bool
+	retrievalInsertionOffset int
+	validator                DeliveryRequestValidator
+	contentIDs               map[string]int
+	issues                   []ValidationIssue
+}
+
+// NewRequestBuilder creates a builder for a DeliveryRequest.
+func NewRequestBuilder() *RequestBuilder {
+	return &RequestBuilder{
+		request:    &delivery.Request{},
+		contentIDs: map[string]int{},
+	}
+}
+
+// WithUser sets the platform user ID and the anonymous user ID. The anonymous user ID is required.
+func (b *RequestBuilder) WithUser(userID, anonUserID string) *RequestBuilder {
+	if anonUserID == "" {
+		b.addIssue("request.userInfo.anonUserId", RuleAnonUserIDRequired, "Request.userInfo.anonUserID should be set")
+	}
+	if b.request.UserInfo == nil {
+		b.request.UserInfo = &common.UserInfo{}
+	}
+	b.request.UserInfo.UserId = userID
+	b.request.UserInfo.AnonUserId = anonUserID
+	return b
+}
+
+// WithInternalUser marks the user as an internal (test, support, employee) user.
+func (b *RequestBuilder) WithInternalUser(isInternalUser bool) *RequestBuilder {
+	if b.request.UserInfo == nil {
+		b.request.UserInfo = &common.UserInfo{}
+	}
+	b.request.UserInfo.IsInternalUser = isInternalUser
+	return b
+}
+
+// WithPlatformID sets the platform ID.
+func (b *RequestBuilder) WithPlatformID(platformID uint64) *RequestBuilder {
+	b.request.PlatformId = platformID
+	return b
+}
+
+// WithUseCase sets the use case, which must be a known UseCase value.
+func (b *RequestBuilder) WithUseCase(useCase delivery.UseCase) *RequestBuilder {
+	if _, ok := delivery.UseCase_name[int32(useCase)]; !ok {
+		b.addIssue("request.useCase", RuleUseCase, fmt.Sprintf("Request.useCase %d is not a known UseCase", int32(useCase)))
+	}
+	b.request.UseCase = useCase
+	return b
+}
+
+// WithPage sets the page size and the offset of the page in the list of ALL insertions.
+func (b *RequestBuilder) WithPage(size, offset int32) *RequestBuilder {
+	if size < 0 {
+		b.addIssue("request.paging.size", RulePagingSize, "Paging.size must be greater or equal to 0")
+	}
+	if offset < 0 {
+		b.addIssue("request.paging.offset", RulePagingOffset, "Paging.offset must be greater or equal to 0")
+	}
+	b.request.Paging = NewPaging(size, offset)
+	return b
+}
+
+// WithSearchQuery sets the search query.
+func (b *RequestBuilder) WithSearchQuery(searchQuery string) *RequestBuilder {
+	b.request.SearchQuery = searchQuery
+	return b
+}
+
+// WithSessionID sets the session ID.
+func (b *RequestBuilder) WithSessionID(sessionID string) *RequestBuilder {
+	b.request.SessionId = sessionID
+	return b
+}
+
+// WithViewID sets the view ID.
+func (b *RequestBuilder) WithViewID(viewID string) *RequestBuilder {
+	b.request.ViewId = viewID
+	return b
+}
+
+// WithClientRequestID sets the client request ID instead of having the client generate one.
+func (b *RequestBuilder) WithClientRequestID(clientRequestID string) *RequestBuilder {
+	b.request.ClientRequestId = clientRequestID
+	return b
+}
+
+// WithDevice sets the device information.
+func (b *RequestBuilder) WithDevice(device *common.Device) *RequestBuilder {
+	b.request.Device = device
+	return b
+}
+
+// WithClientInfo sets the client information. The client always sets ClientType to PLATFORM_SERVER, and
+// TrafficType to PRODUCTION unless it is REPLAY.
+func (b *RequestBuilder) WithClientInfo(clientInfo *common.ClientInfo) *RequestBuilder {
+	b.request.ClientInfo = clientInfo
+	return b
+}
+
+// WithDisablePersonalization disables personalized inputs into the Delivery algorithm.
+func (b *RequestBuilder) WithDisablePersonalization(disablePersonalization bool) *RequestBuilder {
+	b.request.DisablePersonalization = disablePersonalization
+	return b
+}
+
+// WithProperties sets custom request properties. Values must be convertible by structpb.NewValue.
+func (b *RequestBuilder) WithProperties(properties map[string]any) *RequestBuilder {
+	props, err := newProperties(properties)
+	if err != nil {
+		b.addIssue("request.properties", RuleInvalidProperties, err.Error())
+		return b
+	}
+	b.request.Properties = props
+	return b
+}
+
+// WithPropertiesFrom sets custom request properties from a tagged struct, see MarshalProperties.
+func (b *RequestBuilder) WithPropertiesFrom(v any) *RequestBuilder {
+	props, err := MarshalProperties(v)
+	if err != nil {
+		b.addIssue("request.properties", RuleInvalidProperties, err.Error())
+		return b
+	}
+	b.request.Properties = props
+	return b
+}
+
+// WithExperiment sets the experiment that the user is in.
+func (b *RequestBuilder) WithExperiment(experiment *event.CohortMembership) *RequestBuilder {
+	b.experiment = experiment
+	return b
+}
+
+// WithOnlyLog sets whether only logs should be sent to Metrics API.
+func (b *RequestBuilder) WithOnlyLog(onlyLog bool) *RequestBuilder {
+	b.onlyLog = onlyLog
+	return b
+}
+
+// WithRetrievalInsertionOffset sets the start index of the request insertions in the list of ALL insertions.
+func (b *RequestBuilder) WithRetrievalInsertionOffset(retrievalInsertionOffset int) *RequestBuilder {
+	if retrievalInsertionOffset < 0 {
+		b.addIssue("retrievalInsertionOffset", RuleNonNegativeOffset, "Insertion start must be greater or equal to 0")
+	}
+	b.retrievalInsertionOffset = retrievalInsertionOffset
+	return b
+}
+
+// WithValidator sets the validator run by Build and by the client. Defaults to DefaultDeliveryRequestValidator.
+func (b *RequestBuilder) WithValidator(validator DeliveryRequestValidator) *RequestBuilder {
+	b.validator = validator
+	return b
+}
+
+// AddInsertion adds a request insertion for the content ID. Content IDs must be non-empty and unique.
+func (b *RequestBuilder) AddInsertion(contentID string, opts ...InsertionOption) *RequestBuilder {
+	index := len(b.request.Insertion)
+	field := fmt.Sprintf("request.insertion[%d]", index)
+	if contentID == "" {
+		b.addIssue(field+".contentId", RuleContentIDRequired, "Insertion.contentID should be set")
+	} else if first, ok := b.contentIDs[contentID]; ok {
+		b.addIssue(field+".contentId", RuleDuplicateContentID,
+			fmt.Sprintf("Insertion.contentID %q duplicates request.insertion[%d]", contentID, first))
+	} else {
+		b.contentIDs[contentID] = index
+	}
+
+	ins := &delivery.Insertion{ContentId: contentID}
+	for _, opt := range opts {
+		if err := opt(ins); err != nil {
+			var optErr *InsertionOptionError
+			if errors.As(err, &optErr) {
+				b.addIssue(field+"."+optErr.Field, optErr.Rule, optErr.Error())
+			} else {
+				b.addIssue(field, RuleCustom, err.Error())
+			}
+		}
+	}
+	b.request.Insertion = append(b.request.Insertion, ins)
+	return b
+}
+
+// Build returns the DeliveryRequest, or a *ValidationError with every problem found while building
+// and by the validator. The request is a copy, so the builder can be changed and built again without affecting
+// requests it already returned.
+func (b *RequestBuilder) Build() (*DeliveryRequest, error) {
+	request := proto.Clone(b.request).(*delivery.Request)
+	deliveryRequest := NewDeliveryRequest(request, b.experiment, b.onlyLog, b.retrievalInsertionOffset, b.validator)
+
+	issues := append([]ValidationIssue(nil), b.issues...)
+	reported := make(map[ValidationIssue]bool, len(issues))
+	for _, issue := range issues {
+		reported[ValidationIssue{Field: issue.Field, Rule: issue.Rule}] = true
+	}
+	for _, issue := range deliveryRequest.ValidateIssues() {
+		if !reported[ValidationIssue{Field: issue.Field, Rule: issue.Rule}] {
+			issues = append(issues, issue)
+		}
+	}
+	if len(issues) > 0 {
+		return nil, &ValidationError{Issues: issues}
+	}
+	return deliveryRequest, nil
+}
+
+// addIssue records a problem found while building.
+func (b *RequestBuilder) addIssue(field, rule, message string) {
+	b.issues = append(b.issues, ValidationIssue{Field: field, Rule: rule, Message: message})
+}
+
+// newProperties converts a map into a Properties struct.
+func newProperties(properties map[string]any) (*common.Properties, error) {
+	s, err := structpb.NewStruct(properties)
+	if err != nil {
+		return nil, fmt.Errorf("invalid properties: %v", err)
+	}
+	return &common.Properties{StructField: &common.Properties_Struct{Struct: s}}, nil
+}
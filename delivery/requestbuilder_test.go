@@ -0,0 +1,159 @@
+package delivery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBuilderBuildsRequest(t *testing.T) {
+	cm := &event.CohortMembership{Arm: event.CohortArm_TREATMENT, CohortId: "c"}
+	dreq, err := NewRequestBuilder().
+		WithUser("u", "anon").
+		WithPlatformID(3).
+		WithUseCase(delivery.UseCase_SEARCH).
+		WithSearchQuery("shoes").
+		WithPage(2, 1).
+		WithProperties(map[string]any{"page": "search"}).
+		WithExperiment(cm).
+		AddInsertion("a").
+		AddInsertion("b", WithRetrievalRank(1), WithRetrievalScore(0.5), WithInsertionProperties(map[string]any{"price": 10})).
+		AddInsertion("c").
+		Build()
+	assert.NoError(t, err)
+
+	req := dreq.Request
+	assert.Equal(t, "u", req.UserInfo.UserId)
+	assert.Equal(t, "anon", req.UserInfo.AnonUserId)
+	assert.Equal(t, uint64(3), req.PlatformId)
+	assert.Equal(t, delivery.UseCase_SEARCH, req.UseCase)
+	assert.Equal(t, "shoes", req.SearchQuery)
+	assert.Equal(t, int32(2), req.Paging.Size)
+	assert.Equal(t, int32(1), req.Paging.GetOffset())
+	assert.Equal(t, "search", req.Properties.GetStruct().Fields["page"].GetStringValue())
+	assert.Equal(t, cm, dreq.Experiment)
+	assert.False(t, dreq.OnlyLog)
+
+	assert.Equal(t, 3, len(req.Insertion))
+	assert.Equal(t, "b", req.Insertion[1].ContentId)
+	assert.Equal(t, uint64(1), req.Insertion[1].GetRetrievalRank())
+	assert.Equal(t, float32(0.5), req.Insertion[1].GetRetrievalScore())
+	assert.Equal(t, float64(10), req.Insertion[1].Properties.GetStruct().Fields["price"].GetNumberValue())
+	assert.Empty(t, dreq.Validate())
+}
+
+func TestRequestBuilderCollectsIssues(t *testing.T) {
+	_, err := NewRequestBuilder().
+		WithUser("u", "").
+		WithUseCase(delivery.UseCase(500)).
+		WithPage(-1, 0).
+		WithProperties(map[string]any{"bad": make(chan int)}).
+		AddInsertion("a").
+		AddInsertion("").
+		AddInsertion("a", WithInsertionProperties(map[string]any{"bad": struct{}{}})).
+		Build()
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+
+	var got [][2]string
+	for _, issue := range validationErr.Issues {
+		got = append(got, [2]string{issue.Field, issue.Rule})
+	}
+	assert.Equal(t, [][2]string{
+		{"request.userInfo.anonUserId", RuleAnonUserIDRequired},
+		{"request.useCase", RuleUseCase},
+		{"request.paging.size", RulePagingSize},
+		{"request.properties", RuleInvalidProperties},
+		{"request.insertion[1].contentId", RuleContentIDRequired},
+		{"request.insertion[2].contentId", RuleDuplicateContentID},
+		{"request.insertion[2].properties", RuleInvalidProperties},
+	}, got)
+}
+
+func TestRequestBuilderWithUserKeepsInternalUser(t *testing.T) {
+	dreq, err := NewRequestBuilder().
+		WithInternalUser(true).
+		WithUser("u", "anon").
+		WithClientInfo(&common.ClientInfo{TrafficType: common.ClientInfo_REPLAY}).
+		AddInsertion("a").
+		Build()
+	assert.NoError(t, err)
+	assert.True(t, dreq.Request.UserInfo.IsInternalUser)
+	assert.Equal(t, "u", dreq.Request.UserInfo.UserId)
+	assert.Equal(t, "anon", dreq.Request.UserInfo.AnonUserId)
+	assert.Equal(t, common.ClientInfo_REPLAY, dreq.Request.ClientInfo.TrafficType)
+}
+
+func TestRequestBuilderBuildsCopies(t *testing.T) {
+	b := NewRequestBuilder().WithUser("u", "anon").AddInsertion("a")
+	first, err := b.Build()
+	assert.NoError(t, err)
+
+	second, err := b.WithUser("u2", "anon2").WithSearchQuery("q").AddInsertion("b").Build()
+	assert.NoError(t, err)
+	assert.Equal(t, "u", first.Request.UserInfo.UserId)
+	assert.Empty(t, first.Request.SearchQuery)
+	assert.Equal(t, 1, len(first.Request.Insertion))
+	assert.Equal(t, "u2", second.Request.UserInfo.UserId)
+	assert.Equal(t, 2, len(second.Request.Insertion))
+
+	// Changing a built request doesn't change the builder.
+	second.Request.Insertion[0].ContentId = "changed"
+	third, err := b.Build()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", third.Request.Insertion[0].ContentId)
+}
+
+func TestRequestBuilderReportsInsertionOptionErrors(t *testing.T) {
+	withFeedback := func(ins *delivery.Insertion) error {
+		return &InsertionOptionError{Field: "feedback", Rule: "feedback_required", Err: errors.New("feedback is required")}
+	}
+	failing := func(ins *delivery.Insertion) error {
+		return errors.New("lookup failed")
+	}
+	_, err := NewRequestBuilder().
+		WithUser("u", "anon").
+		AddInsertion("a", withFeedback).
+		AddInsertion("b", failing).
+		Build()
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, []ValidationIssue{
+		{Field: "request.insertion[0].feedback", Rule: "feedback_required", Message: "feedback is required"},
+		{Field: "request.insertion[1]", Rule: RuleCustom, Message: "lookup failed"},
+	}, validationErr.Issues)
+}
+
+func TestRequestBuilderRunsValidatorOnBuild(t *testing.T) {
+	_, err := NewRequestBuilder().
+		WithUser("", "anon").
+		WithPage(2, 5).
+		AddInsertion("a").
+		AddInsertion("b").
+		Build()
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, 1, len(validationErr.Issues))
+	assert.Equal(t, RulePagingOffset, validationErr.Issues[0].Rule)
+}
+
+func TestRequestBuilderUsesCustomValidator(t *testing.T) {
+	dreq, err := NewRequestBuilder().
+		WithValidator(NewDefaultRuleValidator().Disable(RuleUserInfoRequired)).
+		WithOnlyLog(true).
+		WithRetrievalInsertionOffset(10).
+		WithDevice(&common.Device{IpAddress: "1.2.3.4"}).
+		AddInsertion("a").
+		Build()
+	assert.NoError(t, err)
+	assert.True(t, dreq.OnlyLog)
+	assert.Equal(t, 10, dreq.RetrievalInsertionOffset)
+	assert.Equal(t, "1.2.3.4", dreq.Request.Device.IpAddress)
+}
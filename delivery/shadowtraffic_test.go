@@ -191,7 +191,7 @@ func verifyShadowTrafficRequest(t *testing.T, mockApiDelivery *MockDelivery, exp
 }
 
 func createDefaultClient(apiFactory APIFactory, samplesIn bool, shadowTrafficRate float32) *PromotedDeliveryClient {
-	builder := NewPromotedDeliveryClientBuilder()
+	builder := newTestClientBuilder()
 	client, _ := builder.
 		WithBlockingShadowTraffic(true).
 		WithSampler(&FakeSampler{samplesIn: samplesIn}).
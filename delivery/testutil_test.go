@@ -1,6 +1,7 @@
 package delivery
 
 import (
+	"context"
 	"strconv"
 
 	"github.com/promotedai/schema/generated/go/proto/delivery"
@@ -59,3 +60,20 @@ func uint64Pointer(val int) *uint64 {
 	newVal := uint64(val)
 	return &newVal
 }
+
+// newTestClientBuilder returns a builder with the endpoints and keys that Build requires.
+func newTestClientBuilder() *PromotedDeliveryClientBuilder {
+	return NewPromotedDeliveryClientBuilder().
+		WithDeliveryEndpoint("https://delivery.example.com").
+		WithDeliveryAPIKey("delivery-key").
+		WithMetricsEndpoint("https://metrics.example.com").
+		WithMetricsAPIKey("metrics-key")
+}
+
+// healthCheckFunc is a HealthChecker that calls the function and succeeds.
+type healthCheckFunc func()
+
+func (f healthCheckFunc) HealthCheck(ctx context.Context) error {
+	f()
+	return nil
+}
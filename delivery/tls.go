@@ -0,0 +1,196 @@
+package delivery
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSOptions configures the TLS connections of one API, for example to present a client certificate to an egress
+// gateway that requires mutual TLS and uses a private CA. The files are read when the client is built and again
+// when they change on disk, so rotated certificates are used by new connections without a restart.
+type TLSOptions struct {
+	// CertFile and KeyFile are a PEM client certificate and its key, presented when the server asks for one.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is a PEM bundle of the CAs that server certificates are verified against, instead of the system
+	// roots.
+	CAFile string
+
+	// ServerName overrides the host name that the server certificate is verified against and that is sent in SNI.
+	// It is required with CAFile when the endpoint is an IP address.
+	ServerName string
+}
+
+// validate returns every problem with the options, which are called name in the errors.
+func (o *TLSOptions) validate(name string) []error {
+	var errs []error
+	if (o.CertFile == "") != (o.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("%s: CertFile and KeyFile must be set together", name))
+	}
+	if *o == (TLSOptions{}) {
+		errs = append(errs, fmt.Errorf("%s: TLS options must set a client certificate, CA file or server name", name))
+	}
+	return errs
+}
+
+// newConfig returns a copy of base, which may be nil, configured with the options.
+func (o *TLSOptions) newConfig(base *tls.Config) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if base != nil {
+		config = base.Clone()
+	}
+	if o.ServerName != "" {
+		config.ServerName = o.ServerName
+	}
+
+	files := &tlsFiles{options: *o}
+	if o.CertFile != "" {
+		if _, err := files.clientCertificate(); err != nil {
+			return nil, err
+		}
+		config.Certificates = nil
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return files.clientCertificate()
+		}
+	}
+	if o.CAFile != "" {
+		if _, err := files.rootCAs(); err != nil {
+			return nil, err
+		}
+		// crypto/tls can't reload RootCAs, so the standard verification is replaced by VerifyConnection, which
+		// checks the chain and server name against the current CA bundle.
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = files.verifyConnection
+	}
+	return config, nil
+}
+
+// fileVersion identifies the contents of a file by its modification time and size.
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileVersion, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileVersion{}, err
+	}
+	return fileVersion{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// tlsFiles caches the certificates read from the TLSOptions files, reading them again when they change.
+type tlsFiles struct {
+	options TLSOptions
+
+	mu           sync.Mutex
+	cert         *tls.Certificate
+	certVersion  [2]fileVersion
+	roots        *x509.CertPool
+	rootsVersion fileVersion
+}
+
+// clientCertificate returns the client certificate, reloading it if the files changed. If a changed certificate
+// can't be loaded, for example while it is being rewritten, the previous one is used.
+func (f *tlsFiles) clientCertificate() (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	certVersion, certErr := statFile(f.options.CertFile)
+	keyVersion, keyErr := statFile(f.options.KeyFile)
+	version := [2]fileVersion{certVersion, keyVersion}
+	if f.cert != nil && certErr == nil && keyErr == nil && version == f.certVersion {
+		return f.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.options.CertFile, f.options.KeyFile)
+	if err == nil {
+		err = errors.Join(certErr, keyErr)
+	}
+	if err != nil {
+		if f.cert != nil {
+			log.Printf("Error reloading TLS client certificate, using the previous one: %v\n", err)
+			return f.cert, nil
+		}
+		return nil, fmt.Errorf("error loading TLS client certificate: %v", err)
+	}
+	f.cert = &cert
+	f.certVersion = version
+	return f.cert, nil
+}
+
+// rootCAs returns the CA bundle, reloading it if the file changed. If a changed bundle can't be loaded, the
+// previous one is used.
+func (f *tlsFiles) rootCAs() (*x509.CertPool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	version, err := statFile(f.options.CAFile)
+	if f.roots != nil && err == nil && version == f.rootsVersion {
+		return f.roots, nil
+	}
+
+	var roots *x509.CertPool
+	if err == nil {
+		roots, err = loadCertPool(f.options.CAFile)
+	}
+	if err != nil {
+		if f.roots != nil {
+			log.Printf("Error reloading TLS CA file, using the previous one: %v\n", err)
+			return f.roots, nil
+		}
+		return nil, fmt.Errorf("error loading TLS CA file: %v", err)
+	}
+	f.roots = roots
+	f.rootsVersion = version
+	return f.roots, nil
+}
+
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// verifyConnection verifies the server's certificate chain and name against the current CA bundle.
+func (f *tlsFiles) verifyConnection(state tls.ConnectionState) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("tls: server sent no certificates")
+	}
+	// The connection state only has the server name sent in SNI, which is empty for IP addresses. Those are
+	// rejected rather than accepting any name.
+	serverName := f.options.ServerName
+	if serverName == "" {
+		serverName = state.ServerName
+	}
+	if serverName == "" {
+		return errors.New("tls: TLSOptions.ServerName must be set to verify a server addressed by IP with a CAFile")
+	}
+	roots, err := f.rootCAs()
+	if err != nil {
+		return err
+	}
+	options := x509.VerifyOptions{
+		DNSName:       serverName,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range state.PeerCertificates[1:] {
+		options.Intermediates.AddCert(cert)
+	}
+	_, err = state.PeerCertificates[0].Verify(options)
+	return err
+}
@@ -0,0 +1,267 @@
+package delivery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a generated certificate and key.
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA when parent is nil.
+func newTestCert(t *testing.T, name string, parent *testCert, hosts ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	cert, err := tls.X509KeyPair(c.certPEM(), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+	assert.NoError(t, err)
+	return cert
+}
+
+// writeFiles writes the certificate and key to dir as name.crt and name.key, returning their paths. The
+// modification time is set to modTime so rewrites are seen as changes.
+func (c *testCert) writeFiles(t *testing.T, dir, name string, modTime time.Time) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	assert.NoError(t, err)
+	certFile := writeTLSTestFile(t, dir, name+".crt", c.certPEM(), modTime)
+	keyFile := writeTLSTestFile(t, dir, name+".key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), modTime)
+	return certFile, keyFile
+}
+
+func writeTLSTestFile(t *testing.T, dir, name string, data []byte, modTime time.Time) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	return path
+}
+
+// newMTLSServer starts a TLS server for hosts that requires client certificates signed by ca and responds with
+// the client certificate's common name.
+func newMTLSServer(t *testing.T, ca *testCert, hosts ...string) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-client-cn", r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.cert)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{newTestCert(t, "server", ca, hosts...).tlsCertificate(t)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// clientCommonName sends a request with client and returns the client certificate name the server saw.
+func clientCommonName(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("x-client-cn"), nil
+}
+
+func TestTLSOptionsMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	server := newMTLSServer(t, ca, "gateway.internal")
+	caFile := writeTLSTestFile(t, dir, "ca.crt", ca.certPEM(), time.Now())
+	certFile, keyFile := newTestCert(t, "client", ca).writeFiles(t, dir, "client", time.Now())
+
+	client, err := HTTPOptions{TLS: &TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "gateway.internal"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	name, err := clientCommonName(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "client", name)
+
+	// Without a client certificate the server rejects the handshake.
+	client, err = HTTPOptions{TLS: &TLSOptions{CAFile: caFile, ServerName: "gateway.internal"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	_, err = clientCommonName(client, server.URL)
+	assert.Error(t, err)
+}
+
+func TestTLSOptionsCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	dir := t.TempDir()
+	caFile := writeTLSTestFile(t, dir, "ca.crt", pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), time.Now())
+
+	// The system roots don't trust the test server.
+	client, err := HTTPOptions{}.NewClient(time.Second)
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.ErrorContains(t, err, "certificate")
+
+	client, err = HTTPOptions{TLS: &TLSOptions{CAFile: caFile, ServerName: "example.com"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+
+	// A different CA is not trusted.
+	otherCAFile := writeTLSTestFile(t, dir, "other.crt", newTestCert(t, "other", nil).certPEM(), time.Now())
+	client, err = HTTPOptions{TLS: &TLSOptions{CAFile: otherCAFile, ServerName: "example.com"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	_, err = client.Get(server.URL)
+	assert.ErrorContains(t, err, "certificate signed by unknown authority")
+
+	// A rotated CA bundle is used by new connections.
+	client, err = HTTPOptions{TLS: &TLSOptions{CAFile: caFile, ServerName: "example.com"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	writeTLSTestFile(t, dir, "ca.crt", newTestCert(t, "rotated", nil).certPEM(), time.Now().Add(time.Minute))
+	_, err = client.Get(server.URL)
+	assert.ErrorContains(t, err, "certificate signed by unknown authority")
+}
+
+func TestTLSOptionsServerName(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	server := newMTLSServer(t, ca, "gateway.internal")
+	caFile := writeTLSTestFile(t, dir, "ca.crt", ca.certPEM(), time.Now())
+	certFile, keyFile := newTestCert(t, "client", ca).writeFiles(t, dir, "client", time.Now())
+	options := TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: caFile}
+
+	// The URL has an IP address, so the server name must be given.
+	client, err := HTTPOptions{TLS: &options}.NewClient(time.Second)
+	assert.NoError(t, err)
+	_, err = clientCommonName(client, server.URL)
+	assert.ErrorContains(t, err, "TLSOptions.ServerName must be set to verify a server addressed by IP with a CAFile")
+
+	options.ServerName = "other.internal"
+	client, err = HTTPOptions{TLS: &options}.NewClient(time.Second)
+	assert.NoError(t, err)
+	_, err = clientCommonName(client, server.URL)
+	assert.ErrorContains(t, err, "certificate is valid for gateway.internal, not other.internal")
+
+	options.ServerName = "gateway.internal"
+	client, err = HTTPOptions{TLS: &options}.NewClient(time.Second)
+	assert.NoError(t, err)
+	name, err := clientCommonName(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "client", name)
+}
+
+func TestTLSOptionsReloadsRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	server := newMTLSServer(t, ca, "gateway.internal")
+	caFile := writeTLSTestFile(t, dir, "ca.crt", ca.certPEM(), time.Now())
+	modTime := time.Now().Add(-time.Minute)
+	certFile, keyFile := newTestCert(t, "client-1", ca).writeFiles(t, dir, "client", modTime)
+
+	client, err := HTTPOptions{TLS: &TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "gateway.internal"}}.NewClient(time.Second)
+	assert.NoError(t, err)
+	name, err := clientCommonName(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-1", name)
+
+	// New connections use the rotated certificate.
+	newTestCert(t, "client-2", ca).writeFiles(t, dir, "client", modTime.Add(time.Second))
+	client.CloseIdleConnections()
+	name, err = clientCommonName(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-2", name)
+
+	// A half-written rotation keeps the previous certificate.
+	writeTLSTestFile(t, dir, "client.key", []byte("partial"), modTime.Add(2*time.Second))
+	client.CloseIdleConnections()
+	name, err = clientCommonName(client, server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-2", name)
+}
+
+func TestTLSOptionsErrors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := HTTPOptions{TLS: &TLSOptions{CertFile: "client.crt"}}.NewClient(time.Second)
+	assert.EqualError(t, err, "HTTPOptions: CertFile and KeyFile must be set together")
+
+	_, err = HTTPOptions{TLS: &TLSOptions{}}.NewClient(time.Second)
+	assert.EqualError(t, err, "HTTPOptions: TLS options must set a client certificate, CA file or server name")
+
+	_, err = HTTPOptions{TLS: &TLSOptions{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")}}.NewClient(time.Second)
+	assert.ErrorContains(t, err, "error loading TLS client certificate: open ")
+
+	notPEM := writeTLSTestFile(t, dir, "ca.crt", []byte("not a certificate"), time.Now())
+	_, err = HTTPOptions{TLS: &TLSOptions{CAFile: notPEM}}.NewClient(time.Second)
+	assert.EqualError(t, err, "error loading TLS CA file: "+notPEM+" contains no PEM certificates")
+}
+
+func TestBuildTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "ca", nil)
+	server := newMTLSServer(t, ca, "gateway.internal")
+	caFile := writeTLSTestFile(t, dir, "ca.crt", ca.certPEM(), time.Now())
+	certFile, keyFile := newTestCert(t, "client", ca).writeFiles(t, dir, "client", time.Now())
+
+	client, err := newTestClientBuilder().
+		WithDeliveryEndpoint(server.URL).
+		WithDeliveryTLS(TLSOptions{CertFile: certFile, KeyFile: keyFile, CAFile: caFile, ServerName: "gateway.internal"}).
+		Build()
+	assert.NoError(t, err)
+	assert.NoError(t, client.deliveryAPI.(HealthChecker).HealthCheck(context.Background()))
+
+	_, err = newTestClientBuilder().WithMetricsTLS(TLSOptions{CAFile: filepath.Join(dir, "missing.crt")}).Build()
+	assert.ErrorContains(t, err, "error loading TLS CA file")
+}
@@ -0,0 +1,528 @@
+package delivery
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"google.golang.org/protobuf/proto"
+)
+
+// UserAgent is the device, operating system and browser parsed from a User-Agent string or client hints.
+// Fields that could not be determined are left empty.
+type UserAgent struct {
+	// DeviceType is DESKTOP, MOBILE or TABLET, or UNKNOWN_DEVICE_TYPE for bots and unrecognized agents.
+	DeviceType common.DeviceType
+
+	// Brand is the device brand, e.g. "Apple", "Samsung" or "Google".
+	Brand string
+
+	// Manufacturer is the device manufacturer. It is usually the same as Brand.
+	Manufacturer string
+
+	// Model is the device model, e.g. "SM-S908B", "Pixel 7" or "iPhone".
+	Model string
+
+	// OSName is the operating system, e.g. "iOS", "Android", "Windows", "macOS", "Chrome OS" or "Linux".
+	OSName string
+
+	// OSVersion is the operating system version, e.g. "17.1.2", "13" or "10".
+	OSVersion string
+
+	// BrowserName is the browser, e.g. "Chrome", "Safari", "Firefox", "Edge" or "Samsung Internet".
+	BrowserName string
+
+	// BrowserVersion is the browser version.
+	BrowserVersion string
+
+	// IsBot is set for crawlers and headless browsers.
+	IsBot bool
+}
+
+var (
+	botPattern             = regexp.MustCompile(`(?i)bot[/;)-]|crawler|spider|slurp|facebookexternalhit|headlesschrome`)
+	iosVersionPattern      = regexp.MustCompile(`OS (\d+(?:_\d+)*) like Mac OS X`)
+	macVersionPattern      = regexp.MustCompile(`Mac OS X (\d+(?:[_.]\d+)*)`)
+	androidVersionPattern  = regexp.MustCompile(`Android[ /]?(\d+(?:\.\d+)*)`)
+	windowsVersionPattern  = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	windowsPhonePattern    = regexp.MustCompile(`Windows Phone(?: OS)? (\d+(?:\.\d+)*)`)
+	chromeOSVersionPattern = regexp.MustCompile(`CrOS \S+ (\d+(?:\.\d+)*)`)
+)
+
+// browserPatterns are checked in order, so browsers built on Chrome or Safari come before them.
+var browserPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"Edge", regexp.MustCompile(`(?:Edg|EdgA|EdgiOS|Edge)/(\d[\d.]*)`)},
+	{"Opera", regexp.MustCompile(`(?:OPR|OPiOS|OPT)/(\d[\d.]*)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d[\d.]*)`)},
+	{"Yandex", regexp.MustCompile(`YaBrowser/(\d[\d.]*)`)},
+	{"UC Browser", regexp.MustCompile(`UCBrowser/(\d[\d.]*)`)},
+	{"Facebook", regexp.MustCompile(`FBAV/(\d[\d.]*)`)},
+	{"Instagram", regexp.MustCompile(`Instagram (\d[\d.]*)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d[\d.]*)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d[\d.]*)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d[\d.]*)(?: Mobile(?:/\S+)?)? Safari/`)},
+	{"Internet Explorer", regexp.MustCompile(`(?:MSIE |Trident/.*rv:)(\d[\d.]*)`)},
+}
+
+// androidManufacturers maps Android model prefixes to manufacturers. Prefixes are matched case-insensitively
+// in order.
+var androidManufacturers = []struct {
+	prefix       string
+	manufacturer string
+}{
+	{"SM-", "Samsung"}, {"GT-", "Samsung"}, {"SCH-", "Samsung"}, {"SGH-", "Samsung"}, {"SAMSUNG", "Samsung"},
+	{"Pixel", "Google"}, {"Nexus", "Google"},
+	{"moto", "Motorola"}, {"XT1", "Motorola"}, {"XT2", "Motorola"},
+	{"LG", "LG"}, {"LM-", "LG"},
+	{"HUAWEI", "Huawei"}, {"ELE-", "Huawei"}, {"VOG-", "Huawei"}, {"ANE-", "Huawei"}, {"MAR-", "Huawei"},
+	{"Redmi", "Xiaomi"}, {"Mi ", "Xiaomi"}, {"POCO", "Xiaomi"}, {"Xiaomi", "Xiaomi"},
+	{"ONEPLUS", "OnePlus"}, {"IN20", "OnePlus"}, {"KB20", "OnePlus"}, {"LE21", "OnePlus"}, {"GM19", "OnePlus"},
+	{"HD19", "OnePlus"},
+	{"CPH", "OPPO"}, {"OPPO", "OPPO"},
+	{"RMX", "realme"},
+	{"vivo", "vivo"}, {"V2", "vivo"},
+	{"Nokia", "Nokia"}, {"TA-", "Nokia"},
+	{"HTC", "HTC"},
+	{"KF", "Amazon"}, {"AFT", "Amazon"},
+	{"Lenovo", "Lenovo"},
+	{"ASUS", "ASUS"},
+	{"XQ-", "Sony"}, {"SO-", "Sony"},
+	{"Infinix", "Infinix"},
+	{"TECNO", "TECNO"},
+}
+
+// windowsVersions maps Windows NT versions to marketing versions.
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+// ParseUserAgent parses a User-Agent header. It recognizes common mobile, tablet and desktop browsers without
+// any external data files.
+func ParseUserAgent(userAgent string) UserAgent {
+	var ua UserAgent
+	if strings.TrimSpace(userAgent) == "" {
+		return ua
+	}
+	if botPattern.MatchString(userAgent) {
+		ua.IsBot = true
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Windows Phone"):
+		ua.DeviceType = common.DeviceType_MOBILE
+		ua.OSName = "Windows Phone"
+		ua.OSVersion = firstSubmatch(windowsPhonePattern, userAgent)
+	case strings.Contains(userAgent, "iPad"):
+		ua.DeviceType = common.DeviceType_TABLET
+		ua.setApple("iPad", "iOS", strings.ReplaceAll(firstSubmatch(iosVersionPattern, userAgent), "_", "."))
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPod"):
+		ua.DeviceType = common.DeviceType_MOBILE
+		model := "iPhone"
+		if strings.Contains(userAgent, "iPod") {
+			model = "iPod"
+		}
+		ua.setApple(model, "iOS", strings.ReplaceAll(firstSubmatch(iosVersionPattern, userAgent), "_", "."))
+	case strings.Contains(userAgent, "Android"):
+		ua.parseAndroid(userAgent)
+	case strings.Contains(userAgent, "CrOS"):
+		ua.DeviceType = common.DeviceType_DESKTOP
+		ua.OSName = "Chrome OS"
+		ua.OSVersion = firstSubmatch(chromeOSVersionPattern, userAgent)
+	case strings.Contains(userAgent, "Macintosh"):
+		ua.DeviceType = common.DeviceType_DESKTOP
+		ua.setApple("Macintosh", "macOS", strings.ReplaceAll(firstSubmatch(macVersionPattern, userAgent), "_", "."))
+	case strings.Contains(userAgent, "Windows"):
+		ua.DeviceType = common.DeviceType_DESKTOP
+		ua.OSName = "Windows"
+		ua.OSVersion = windowsVersions[firstSubmatch(windowsVersionPattern, userAgent)]
+	case strings.Contains(userAgent, "X11"), strings.Contains(userAgent, "Linux"):
+		ua.DeviceType = common.DeviceType_DESKTOP
+		ua.OSName = "Linux"
+	}
+
+	for _, browser := range browserPatterns {
+		if version := firstSubmatch(browser.pattern, userAgent); version != "" {
+			ua.BrowserName = browser.name
+			ua.BrowserVersion = version
+			break
+		}
+	}
+	if ua.OSName == "Android" {
+		switch {
+		case ua.BrowserName == "Chrome" && strings.Contains(userAgent, "; wv)"):
+			ua.BrowserName = "Chrome WebView"
+		case ua.BrowserName == "Safari":
+			ua.BrowserName = "Android Browser"
+		}
+	}
+
+	if ua.IsBot {
+		ua.DeviceType = common.DeviceType_UNKNOWN_DEVICE_TYPE
+	}
+	return ua
+}
+
+// setApple sets the fields shared by Apple devices.
+func (ua *UserAgent) setApple(model, osName, osVersion string) {
+	ua.Brand = "Apple"
+	ua.Manufacturer = "Apple"
+	ua.Model = model
+	ua.OSName = osName
+	ua.OSVersion = osVersion
+}
+
+// parseAndroid reads the OS version, model and device type from an Android User-Agent, e.g.
+// "Mozilla/5.0 (Linux; Android 13; SM-S908B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36".
+func (ua *UserAgent) parseAndroid(userAgent string) {
+	ua.OSName = "Android"
+	ua.OSVersion = firstSubmatch(androidVersionPattern, userAgent)
+	ua.Model = androidModel(userAgent)
+	if ua.Model != "" {
+		ua.Manufacturer = androidManufacturer(ua.Model)
+		ua.Brand = ua.Manufacturer
+		if ua.Brand == "Samsung" {
+			ua.Model = strings.TrimSpace(strings.TrimPrefix(ua.Model, "SAMSUNG"))
+		}
+	}
+
+	switch {
+	case strings.Contains(userAgent, "Tablet"), ua.Manufacturer == "Amazon" && strings.HasPrefix(ua.Model, "KF"):
+		ua.DeviceType = common.DeviceType_TABLET
+	case strings.Contains(userAgent, "Mobile"):
+		ua.DeviceType = common.DeviceType_MOBILE
+	default:
+		// Android browsers leave "Mobile" out of the User-Agent on tablets.
+		ua.DeviceType = common.DeviceType_TABLET
+	}
+}
+
+// androidModel returns the model token that follows the Android version in the User-Agent's platform section.
+func androidModel(userAgent string) string {
+	start := strings.Index(userAgent, "(")
+	if start < 0 {
+		return ""
+	}
+	// Models such as "moto g(60)" contain parentheses, so find the matching one.
+	end, depth := -1, 0
+	for i := start; i < len(userAgent) && end < 0; i++ {
+		switch userAgent[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				end = i
+			}
+		}
+	}
+	if end < 0 {
+		return ""
+	}
+	tokens := strings.Split(userAgent[start+1:end], ";")
+	seenAndroid := false
+	for _, token := range tokens {
+		token = strings.TrimSpace(token)
+		if strings.HasPrefix(token, "Android") {
+			seenAndroid = true
+			continue
+		}
+		if !seenAndroid || isAndroidPlatformToken(token) {
+			continue
+		}
+		model, _, _ := strings.Cut(token, " Build/")
+		model = strings.TrimSuffix(model, " Build")
+		// Chrome's reduced User-Agent replaces the model with "K".
+		if model == "K" {
+			return ""
+		}
+		return strings.TrimSpace(model)
+	}
+	return ""
+}
+
+// isAndroidPlatformToken reports whether a platform section token is something other than the device model.
+func isAndroidPlatformToken(token string) bool {
+	switch {
+	case token == "", token == "U", token == "wv", token == "Mobile", token == "Tablet", token == "Linux":
+		return true
+	case strings.HasPrefix(token, "rv:"):
+		return true
+	case len(token) == 2 || len(token) == 5 && (token[2] == '-' || token[2] == '_'):
+		// Locales such as "en" or "ko-kr".
+		return true
+	}
+	return false
+}
+
+// androidManufacturer guesses the manufacturer from the Android model.
+func androidManufacturer(model string) string {
+	upper := strings.ToUpper(model)
+	for _, m := range androidManufacturers {
+		if strings.HasPrefix(upper, strings.ToUpper(m.prefix)) {
+			return m.manufacturer
+		}
+	}
+	return ""
+}
+
+// firstSubmatch returns the first capture group of the pattern in s, or "".
+func firstSubmatch(pattern *regexp.Regexp, s string) string {
+	match := pattern.FindStringSubmatch(s)
+	if len(match) < 2 {
+		return ""
+	}
+	return match[1]
+}
+
+// UserAgentFromClientHints reads the device, operating system and browser from client hints.
+func UserAgentFromClientHints(hints *common.ClientHints) UserAgent {
+	var ua UserAgent
+	if hints == nil {
+		return ua
+	}
+
+	ua.OSName, ua.OSVersion = clientHintPlatform(hints.Platform, hints.PlatformVersion)
+	ua.Model = hints.Model
+	if ua.Model != "" && ua.OSName == "Android" {
+		ua.Manufacturer = androidManufacturer(ua.Model)
+		ua.Brand = ua.Manufacturer
+	}
+
+	switch {
+	case hints.IsMobile:
+		ua.DeviceType = common.DeviceType_MOBILE
+	case ua.OSName == "Android":
+		ua.DeviceType = common.DeviceType_TABLET
+	case ua.OSName == "Windows", ua.OSName == "macOS", ua.OSName == "Linux", ua.OSName == "Chrome OS":
+		ua.DeviceType = common.DeviceType_DESKTOP
+	}
+
+	if brand := primaryClientHintBrand(hints.Brand); brand != nil {
+		ua.BrowserName = brand.Brand
+		ua.BrowserVersion = brand.Version
+		if hints.UaFullVersion != "" {
+			ua.BrowserVersion = hints.UaFullVersion
+		}
+	}
+	return ua
+}
+
+// clientHintPlatform maps Sec-CH-UA-Platform and Sec-CH-UA-Platform-Version to the names used by ParseUserAgent.
+func clientHintPlatform(platform, version string) (string, string) {
+	switch platform {
+	case "Chrome OS", "Chromium OS":
+		return "Chrome OS", version
+	case "Windows":
+		// Windows reports its UniversalApiContract version, see
+		// https://learn.microsoft.com/en-us/microsoft-edge/web-platform/how-to-detect-win11
+		major, err := strconv.Atoi(strings.Split(version, ".")[0])
+		switch {
+		case err != nil:
+			return platform, ""
+		case major >= 13:
+			return platform, "11"
+		case major > 0:
+			return platform, "10"
+		}
+		return platform, map[string]string{"0.3.0": "8.1", "0.2.0": "8", "0.1.0": "7"}[version]
+	}
+	return platform, version
+}
+
+// clientHintBrandNames maps Sec-CH-UA brands to the browser names used by ParseUserAgent.
+var clientHintBrandNames = map[string]string{
+	"Google Chrome":  "Chrome",
+	"Microsoft Edge": "Edge",
+	"Opera":          "Opera",
+	"Yandex":         "Yandex",
+}
+
+// primaryClientHintBrand returns the most specific brand, skipping GREASE brands and preferring any brand over
+// "Chromium".
+func primaryClientHintBrand(brands []*common.ClientHintBrand) *common.ClientHintBrand {
+	var chromium *common.ClientHintBrand
+	for _, brand := range brands {
+		name := strings.TrimSpace(brand.GetBrand())
+		switch {
+		case name == "" || isGreaseBrand(name):
+			continue
+		case name == "Chromium":
+			chromium = brand
+			continue
+		}
+		if mapped, ok := clientHintBrandNames[name]; ok {
+			name = mapped
+		}
+		return &common.ClientHintBrand{Brand: name, Version: brand.GetVersion()}
+	}
+	if chromium != nil {
+		return &common.ClientHintBrand{Brand: "Chromium", Version: chromium.GetVersion()}
+	}
+	return nil
+}
+
+// isGreaseBrand reports whether the brand is a made-up brand such as "Not_A Brand" that browsers add to keep
+// servers from depending on the brand list's shape.
+func isGreaseBrand(name string) bool {
+	return strings.HasPrefix(name, "Not") && strings.ContainsAny(name, " _=?;:()./")
+}
+
+// ParseUserAgentAndHints parses the User-Agent and overrides its fields with the ones the client hints provide.
+// Client hints are more precise than User-Agent strings, which browsers are freezing.
+func ParseUserAgentAndHints(userAgent string, hints *common.ClientHints) UserAgent {
+	ua := ParseUserAgent(userAgent)
+	if hints == nil {
+		return ua
+	}
+	h := UserAgentFromClientHints(hints)
+	if h.DeviceType != common.DeviceType_UNKNOWN_DEVICE_TYPE && !ua.IsBot {
+		ua.DeviceType = h.DeviceType
+	}
+	if h.OSName != "" {
+		if h.OSName != ua.OSName {
+			ua.OSVersion = ""
+		}
+		ua.OSName = h.OSName
+	}
+	overrideString(&ua.OSVersion, h.OSVersion)
+	overrideString(&ua.Model, h.Model)
+	overrideString(&ua.Brand, h.Brand)
+	overrideString(&ua.Manufacturer, h.Manufacturer)
+	if h.BrowserName != "" {
+		ua.BrowserName = h.BrowserName
+		ua.BrowserVersion = h.BrowserVersion
+	}
+	return ua
+}
+
+// overrideString sets dst to src when src is not empty.
+func overrideString(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
+// ApplyTo fills in the device type, brand, manufacturer, identifier and OS version that are not already set.
+func (ua UserAgent) ApplyTo(device *common.Device) {
+	if device == nil {
+		return
+	}
+	if device.DeviceType == common.DeviceType_UNKNOWN_DEVICE_TYPE {
+		device.DeviceType = ua.DeviceType
+	}
+	if device.Brand == "" {
+		device.Brand = ua.Brand
+	}
+	if device.Manufacturer == "" {
+		device.Manufacturer = ua.Manufacturer
+	}
+	if device.Identifier == "" {
+		device.Identifier = ua.Model
+	}
+	if device.OsVersion == "" {
+		device.OsVersion = ua.OSVersion
+	}
+}
+
+// NewDeviceFromUserAgent creates a Device with its Browser and the fields parsed from the User-Agent and client
+// hints. hints may be nil.
+func NewDeviceFromUserAgent(userAgent string, hints *common.ClientHints) *common.Device {
+	device := &common.Device{Browser: &common.Browser{UserAgent: userAgent, ClientHints: hints}}
+	ParseUserAgentAndHints(userAgent, hints).ApplyTo(device)
+	return device
+}
+
+// ParseClientHintHeaders reads the Sec-CH-UA client hint headers, returning nil when none are present.
+func ParseClientHintHeaders(header http.Header) *common.ClientHints {
+	hints := &common.ClientHints{
+		IsMobile:        header.Get("Sec-CH-UA-Mobile") == "?1",
+		Architecture:    unquoteSFString(header.Get("Sec-CH-UA-Arch")),
+		Model:           unquoteSFString(header.Get("Sec-CH-UA-Model")),
+		Platform:        unquoteSFString(header.Get("Sec-CH-UA-Platform")),
+		PlatformVersion: unquoteSFString(header.Get("Sec-CH-UA-Platform-Version")),
+		UaFullVersion:   unquoteSFString(header.Get("Sec-CH-UA-Full-Version")),
+	}
+	brands := header.Get("Sec-CH-UA-Full-Version-List")
+	if brands == "" {
+		brands = header.Get("Sec-CH-UA")
+	}
+	hints.Brand = ParseClientHintBrands(brands)
+	if proto.Equal(hints, &common.ClientHints{}) {
+		return nil
+	}
+	return hints
+}
+
+// ParseClientHintBrands parses a Sec-CH-UA or Sec-CH-UA-Full-Version-List header value such as
+// `"Chromium";v="118", "Google Chrome";v="118"` into brand hints.
+func ParseClientHintBrands(value string) []*common.ClientHintBrand {
+	var brands []*common.ClientHintBrand
+	for _, item := range splitSFList(value) {
+		params := strings.Split(item, ";")
+		brand := &common.ClientHintBrand{Brand: unquoteSFString(params[0])}
+		for _, param := range params[1:] {
+			key, val, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if ok && key == "v" {
+				brand.Version = unquoteSFString(val)
+			}
+		}
+		if brand.Brand != "" {
+			brands = append(brands, brand)
+		}
+	}
+	return brands
+}
+
+// splitSFList splits a structured field list on commas outside of quoted strings.
+func splitSFList(value string) []string {
+	var items []string
+	inQuotes := false
+	start := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuotes = !inQuotes
+		case ',':
+			if !inQuotes {
+				items = append(items, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if strings.TrimSpace(value[start:]) != "" {
+		items = append(items, value[start:])
+	}
+	return items
+}
+
+// unquoteSFString unquotes a structured field string such as `"Windows"`, returning other values trimmed.
+func unquoteSFString(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,382 @@
+package delivery
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	desktop = common.DeviceType_DESKTOP
+	mobile  = common.DeviceType_MOBILE
+	tablet  = common.DeviceType_TABLET
+	unknown = common.DeviceType_UNKNOWN_DEVICE_TYPE
+)
+
+// userAgentFixtures are real User-Agent strings seen in production traffic.
+var userAgentFixtures = []struct {
+	ua       string
+	expected UserAgent
+}{
+	// iOS.
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "17.1.2", "Safari", "17.1.2", false},
+	},
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/119.0.6045.109 Mobile/15E148 Safari/604.1",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "16.6", "Chrome", "119.0.6045.109", false},
+	},
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) FxiOS/119.0 Mobile/15E148 Safari/605.1.15",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "17.0", "Firefox", "119.0", false},
+	},
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 EdgiOS/119.2151.65 Mobile/15E148 Safari/605.1.15",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "17.1", "Edge", "119.2151.65", false},
+	},
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 16_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 [FBAN/FBIOS;FBDV/iPhone14,5;FBMD/iPhone;FBSN/iOS;FBSV/16.5;FBSS/3;FBID/phone;FBLC/en_US;FBOP/5;FBAV/425.0.0.38.108]",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "16.5", "Facebook", "425.0.0.38.108", false},
+	},
+	{
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148 Instagram 307.0.0.28.118 (iPhone15,2; iOS 17_1; en_US; en; scale=3.00; 1179x2556; 530364283)",
+		UserAgent{mobile, "Apple", "Apple", "iPhone", "iOS", "17.1", "Instagram", "307.0.0.28.118", false},
+	},
+	{
+		"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+		UserAgent{tablet, "Apple", "Apple", "iPad", "iOS", "16.6", "Safari", "16.6", false},
+	},
+	{
+		"Mozilla/5.0 (iPad; CPU OS 12_5_7 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/99.0.4844.59 Mobile/15E148 Safari/604.1",
+		UserAgent{tablet, "Apple", "Apple", "iPad", "iOS", "12.5.7", "Chrome", "99.0.4844.59", false},
+	},
+	{
+		"Mozilla/5.0 (iPod touch; CPU iPhone OS 12_5_7 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/12.1.2 Mobile/15E148 Safari/604.1",
+		UserAgent{mobile, "Apple", "Apple", "iPod", "iOS", "12.5.7", "Safari", "12.1.2", false},
+	},
+
+	// Android phones.
+	{
+		"Mozilla/5.0 (Linux; Android 13; SM-S908B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "Samsung", "Samsung", "SM-S908B", "Android", "13", "Chrome", "112.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 13; SAMSUNG SM-A536B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "Samsung", "Samsung", "SM-A536B", "Android", "13", "Samsung Internet", "23.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 14; Pixel 8 Pro) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.163 Mobile Safari/537.36",
+		UserAgent{mobile, "Google", "Google", "Pixel 8 Pro", "Android", "14", "Chrome", "119.0.6045.163", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "", "", "", "Android", "10", "Chrome", "119.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 12; moto g(60)) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "Motorola", "Motorola", "moto g(60)", "Android", "12", "Chrome", "118.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 11; Redmi Note 8 Pro) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.5735.196 Mobile Safari/537.36",
+		UserAgent{mobile, "Xiaomi", "Xiaomi", "Redmi Note 8 Pro", "Android", "11", "Chrome", "114.0.5735.196", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 13; CPH2449) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/116.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "OPPO", "OPPO", "CPH2449", "Android", "13", "Chrome", "116.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 12; RMX3085) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/107.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "realme", "realme", "RMX3085", "Android", "12", "Chrome", "107.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 11; ONEPLUS A6013) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "OnePlus", "OnePlus", "ONEPLUS A6013", "Android", "11", "Chrome", "106.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 10; HUAWEI VOG-L29) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/96.0.4664.104 Mobile Safari/537.36",
+		UserAgent{mobile, "Huawei", "Huawei", "HUAWEI VOG-L29", "Android", "10", "Chrome", "96.0.4664.104", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 9; LM-Q720) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/104.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "LG", "LG", "LM-Q720", "Android", "9", "Chrome", "104.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 12; SM-G991B Build/SP1A.210812.016; wv) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/119.0.6045.66 Mobile Safari/537.36",
+		UserAgent{mobile, "Samsung", "Samsung", "SM-G991B", "Android", "12", "Chrome WebView", "119.0.6045.66", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 13; Pixel 7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.66 Mobile Safari/537.36 EdgA/119.0.2151.58",
+		UserAgent{mobile, "Google", "Google", "Pixel 7", "Android", "13", "Edge", "119.0.2151.58", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 10; VOG-L29) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.131 Mobile Safari/537.36 OPR/66.3.3816.63652",
+		UserAgent{mobile, "Huawei", "Huawei", "VOG-L29", "Android", "10", "Opera", "66.3.3816.63652", false},
+	},
+	{
+		"Mozilla/5.0 (Android 13; Mobile; rv:109.0) Gecko/119.0 Firefox/119.0",
+		UserAgent{mobile, "", "", "", "Android", "13", "Firefox", "119.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30",
+		UserAgent{mobile, "LG", "LG", "LG-L160L", "Android", "4.0.3", "Android Browser", "4.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; U; Android 8.1.0; en-US; Nexus 6P Build/OPM7.181205.001) AppleWebKit/537.36 (KHTML, like Gecko) Version/4.0 Chrome/57.0.2987.108 UCBrowser/12.11.1.1197 Mobile Safari/537.36",
+		UserAgent{mobile, "Google", "Google", "Nexus 6P", "Android", "8.1.0", "UC Browser", "12.11.1.1197", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 12; 2201117TG) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 YaBrowser/23.3.3.86.00 SA/3 Mobile Safari/537.36",
+		UserAgent{mobile, "", "", "2201117TG", "Android", "12", "Yandex", "23.3.3.86.00", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 11; TA-1053) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/90.0.4430.210 Mobile Safari/537.36",
+		UserAgent{mobile, "Nokia", "Nokia", "TA-1053", "Android", "11", "Chrome", "90.0.4430.210", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 13; XQ-CT54) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Mobile Safari/537.36",
+		UserAgent{mobile, "Sony", "Sony", "XQ-CT54", "Android", "13", "Chrome", "117.0.0.0", false},
+	},
+
+	// Android tablets.
+	{
+		"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/117.0.0.0 Safari/537.36",
+		UserAgent{tablet, "Samsung", "Samsung", "SM-X700", "Android", "13", "Chrome", "117.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 9; KFMAWI) AppleWebKit/537.36 (KHTML, like Gecko) Silk/119.3.1 like Chrome/119.0.6045.193 Safari/537.36",
+		UserAgent{tablet, "Amazon", "Amazon", "KFMAWI", "Android", "9", "Chrome", "119.0.6045.193", false},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 12; Lenovo TB-J606F) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36",
+		UserAgent{tablet, "Lenovo", "Lenovo", "Lenovo TB-J606F", "Android", "12", "Chrome", "114.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Android 13; Tablet; rv:120.0) Gecko/120.0 Firefox/120.0",
+		UserAgent{tablet, "", "", "", "Android", "13", "Firefox", "120.0", false},
+	},
+
+	// Desktop.
+	{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+		UserAgent{desktop, "", "", "", "Windows", "10", "Chrome", "119.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 Edg/119.0.2151.72",
+		UserAgent{desktop, "", "", "", "Windows", "10", "Edge", "119.0.2151.72", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:120.0) Gecko/20100101 Firefox/120.0",
+		UserAgent{desktop, "", "", "", "Windows", "10", "Firefox", "120.0", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 OPR/104.0.0.0",
+		UserAgent{desktop, "", "", "", "Windows", "10", "Opera", "104.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/64.0.3282.140 Safari/537.36 Edge/18.17763",
+		UserAgent{desktop, "", "", "", "Windows", "10", "Edge", "18.17763", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko",
+		UserAgent{desktop, "", "", "", "Windows", "7", "Internet Explorer", "11.0", false},
+	},
+	{
+		"Mozilla/4.0 (compatible; MSIE 8.0; Windows NT 5.1; Trident/4.0)",
+		UserAgent{desktop, "", "", "", "Windows", "XP", "Internet Explorer", "8.0", false},
+	},
+	{
+		"Mozilla/5.0 (Windows NT 6.3; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/109.0.0.0 Safari/537.36",
+		UserAgent{desktop, "", "", "", "Windows", "8.1", "Chrome", "109.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+		UserAgent{desktop, "Apple", "Apple", "Macintosh", "macOS", "10.15.7", "Chrome", "119.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+		UserAgent{desktop, "Apple", "Apple", "Macintosh", "macOS", "10.15.7", "Safari", "17.1", false},
+	},
+	{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:109.0) Gecko/20100101 Firefox/119.0",
+		UserAgent{desktop, "Apple", "Apple", "Macintosh", "macOS", "10.15", "Firefox", "119.0", false},
+	},
+	{
+		"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+		UserAgent{desktop, "", "", "", "Chrome OS", "14541.0.0", "Chrome", "119.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+		UserAgent{desktop, "", "", "", "Linux", "", "Chrome", "119.0.0.0", false},
+	},
+	{
+		"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/119.0",
+		UserAgent{desktop, "", "", "", "Linux", "", "Firefox", "119.0", false},
+	},
+
+	// Windows Phone.
+	{
+		"Mozilla/5.0 (Windows Phone 10.0; Android 6.0.1; Microsoft; Lumia 950) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/52.0.2743.116 Mobile Safari/537.36 Edge/15.15063",
+		UserAgent{mobile, "", "", "", "Windows Phone", "10.0", "Edge", "15.15063", false},
+	},
+
+	// Bots.
+	{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		UserAgent{unknown, "", "", "", "", "", "", "", true},
+	},
+	{
+		"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.159 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		UserAgent{unknown, "Google", "Google", "Nexus 5X", "Android", "6.0.1", "Chrome", "119.0.6045.159", true},
+	},
+	{
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+		UserAgent{unknown, "", "", "", "", "", "", "", true},
+	},
+	{
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+		UserAgent{unknown, "", "", "", "", "", "", "", true},
+	},
+	{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/119.0.6045.105 Safari/537.36",
+		UserAgent{unknown, "", "", "", "Linux", "", "Chrome", "119.0.6045.105", true},
+	},
+
+	// Unrecognized.
+	{"", UserAgent{}},
+	{"curl/8.4.0", UserAgent{}},
+}
+
+func TestParseUserAgent(t *testing.T) {
+	for _, tc := range userAgentFixtures {
+		t.Run(tc.ua, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ParseUserAgent(tc.ua))
+		})
+	}
+}
+
+func TestUserAgentFromClientHints(t *testing.T) {
+	tests := []struct {
+		name     string
+		hints    *common.ClientHints
+		expected UserAgent
+	}{
+		{"nil", nil, UserAgent{}},
+		{
+			"windows 11 chrome",
+			&common.ClientHints{
+				Brand:           ParseClientHintBrands(`"Google Chrome";v="119.0.6045.160", "Chromium";v="119.0.6045.160", "Not?A_Brand";v="24.0.0.0"`),
+				Platform:        "Windows",
+				PlatformVersion: "15.0.0",
+			},
+			UserAgent{DeviceType: desktop, OSName: "Windows", OSVersion: "11", BrowserName: "Chrome", BrowserVersion: "119.0.6045.160"},
+		},
+		{
+			"windows 10 edge",
+			&common.ClientHints{
+				Brand:           ParseClientHintBrands(`"Microsoft Edge";v="119", "Chromium";v="119", "Not?A_Brand";v="24"`),
+				Platform:        "Windows",
+				PlatformVersion: "10.0.0",
+			},
+			UserAgent{DeviceType: desktop, OSName: "Windows", OSVersion: "10", BrowserName: "Edge", BrowserVersion: "119"},
+		},
+		{
+			"windows 8.1",
+			&common.ClientHints{Platform: "Windows", PlatformVersion: "0.3.0"},
+			UserAgent{DeviceType: desktop, OSName: "Windows", OSVersion: "8.1"},
+		},
+		{
+			"android phone",
+			&common.ClientHints{
+				IsMobile:        true,
+				Brand:           ParseClientHintBrands(`"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`),
+				Model:           "SM-S918B",
+				Platform:        "Android",
+				PlatformVersion: "14.0.0",
+				UaFullVersion:   "120.0.6099.43",
+			},
+			UserAgent{mobile, "Samsung", "Samsung", "SM-S918B", "Android", "14.0.0", "Chrome", "120.0.6099.43", false},
+		},
+		{
+			"android tablet",
+			&common.ClientHints{Platform: "Android", Model: "Pixel Tablet"},
+			UserAgent{DeviceType: tablet, Brand: "Google", Manufacturer: "Google", Model: "Pixel Tablet", OSName: "Android"},
+		},
+		{
+			"chromium only",
+			&common.ClientHints{Brand: ParseClientHintBrands(`"Chromium";v="118", "Not=A?Brand";v="99"`), Platform: "Linux"},
+			UserAgent{DeviceType: desktop, OSName: "Linux", BrowserName: "Chromium", BrowserVersion: "118"},
+		},
+		{
+			"chrome os",
+			&common.ClientHints{Platform: "Chrome OS", PlatformVersion: "15633.69.0"},
+			UserAgent{DeviceType: desktop, OSName: "Chrome OS", OSVersion: "15633.69.0"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, UserAgentFromClientHints(tc.hints))
+		})
+	}
+}
+
+func TestParseUserAgentAndHintsPrefersHints(t *testing.T) {
+	// Chrome's reduced User-Agent hides the model and Android and Windows versions.
+	ua := ParseUserAgentAndHints(
+		"Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+		&common.ClientHints{IsMobile: true, Model: "Pixel 7", Platform: "Android", PlatformVersion: "14.0.0"},
+	)
+	assert.Equal(t, UserAgent{mobile, "Google", "Google", "Pixel 7", "Android", "14.0.0", "Chrome", "120.0.0.0", false}, ua)
+
+	ua = ParseUserAgentAndHints(
+		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		&common.ClientHints{Platform: "Windows", PlatformVersion: "15.0.0"},
+	)
+	assert.Equal(t, "11", ua.OSVersion)
+
+	// Low entropy hints without a platform version keep the User-Agent's version.
+	ua = ParseUserAgentAndHints(
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+		&common.ClientHints{Platform: "macOS"},
+	)
+	assert.Equal(t, "10.15.7", ua.OSVersion)
+}
+
+func TestUserAgentApplyToKeepsSetFields(t *testing.T) {
+	device := &common.Device{Brand: "custom", OsVersion: "1"}
+	ParseUserAgent("Mozilla/5.0 (Linux; Android 13; SM-S908B) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/112.0.0.0 Mobile Safari/537.36").ApplyTo(device)
+	assert.Equal(t, mobile, device.DeviceType)
+	assert.Equal(t, "custom", device.Brand)
+	assert.Equal(t, "Samsung", device.Manufacturer)
+	assert.Equal(t, "SM-S908B", device.Identifier)
+	assert.Equal(t, "1", device.OsVersion)
+}
+
+func TestNewDeviceFromUserAgent(t *testing.T) {
+	userAgent := "Mozilla/5.0 (iPhone; CPU iPhone OS 17_1_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1.2 Mobile/15E148 Safari/604.1"
+	device := NewDeviceFromUserAgent(userAgent, nil)
+	assert.Equal(t, mobile, device.DeviceType)
+	assert.Equal(t, "Apple", device.Brand)
+	assert.Equal(t, "iPhone", device.Identifier)
+	assert.Equal(t, "17.1.2", device.OsVersion)
+	assert.Equal(t, userAgent, device.Browser.UserAgent)
+}
+
+func TestParseClientHintHeaders(t *testing.T) {
+	assert.Nil(t, ParseClientHintHeaders(http.Header{}))
+
+	header := http.Header{}
+	header.Set("Sec-CH-UA", `"Chromium";v="118", "Google Chrome";v="118", "Not=A?Brand";v="99"`)
+	header.Set("Sec-CH-UA-Mobile", "?0")
+	header.Set("Sec-CH-UA-Platform", `"Windows"`)
+	header.Set("Sec-CH-UA-Arch", `"x86"`)
+	hints := ParseClientHintHeaders(header)
+	assert.False(t, hints.IsMobile)
+	assert.Equal(t, "Windows", hints.Platform)
+	assert.Equal(t, "x86", hints.Architecture)
+	assert.Equal(t, 3, len(hints.Brand))
+	assert.Equal(t, "Not=A?Brand", hints.Brand[2].Brand)
+	assert.Equal(t, "99", hints.Brand[2].Version)
+}
@@ -0,0 +1,97 @@
+package delivery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationMode controls what the client does with validation issues found on a DeliveryRequest.
+type ValidationMode int
+
+const (
+	// ValidationModeOff skips request validation.
+	ValidationModeOff ValidationMode = iota
+
+	// ValidationModeLog logs validation issues and sends the request anyway.
+	ValidationModeLog
+
+	// ValidationModeStrict rejects invalid requests with a ValidationError before any network call.
+	ValidationModeStrict
+)
+
+// String returns the name of the validation mode.
+func (m ValidationMode) String() string {
+	switch m {
+	case ValidationModeOff:
+		return "off"
+	case ValidationModeLog:
+		return "log"
+	case ValidationModeStrict:
+		return "strict"
+	default:
+		return fmt.Sprintf("ValidationMode(%d)", int(m))
+	}
+}
+
+// ParseValidationMode parses the name returned by ValidationMode.String, ignoring case.
+func ParseValidationMode(name string) (ValidationMode, error) {
+	for _, m := range []ValidationMode{ValidationModeOff, ValidationModeLog, ValidationModeStrict} {
+		if strings.EqualFold(name, m.String()) {
+			return m, nil
+		}
+	}
+	return ValidationModeOff, fmt.Errorf("unknown validation mode %q, expected off, log or strict", name)
+}
+
+// ValidationIssue is a single problem found while validating a DeliveryRequest.
+type ValidationIssue struct {
+	// Field is the path of the offending field, e.g. "request.userInfo.anonUserId".
+	Field string
+
+	// Rule is the code of the rule that failed.
+	Rule string
+
+	// Message is the human-readable description of the problem.
+	Message string
+}
+
+// String returns the human-readable message of the issue.
+func (i ValidationIssue) String() string {
+	return i.Message
+}
+
+// ValidationError is returned by Deliver in strict validation mode when the request has validation issues.
+type ValidationError struct {
+	Issues []ValidationIssue
+}
+
+// Error formats every issue as "field: message [rule]".
+func (e *ValidationError) Error() string {
+	parts := make([]string, 0, len(e.Issues))
+	for _, issue := range e.Issues {
+		parts = append(parts, fmt.Sprintf("%s: %s [%s]", issue.Field, issue.Message, issue.Rule))
+	}
+	return "invalid delivery request: " + strings.Join(parts, "; ")
+}
+
+// HasRule reports whether any issue was produced by the given rule.
+func (e *ValidationError) HasRule(rule string) bool {
+	for _, issue := range e.Issues {
+		if issue.Rule == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// issueMessages returns the issue messages in order.
+func issueMessages(issues []ValidationIssue) []string {
+	if len(issues) == 0 {
+		return nil
+	}
+	messages := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		messages = append(messages, issue.Message)
+	}
+	return messages
+}
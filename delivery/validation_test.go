@@ -0,0 +1,186 @@
+package delivery
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestValidateIssuesReportsFieldAndRule(t *testing.T) {
+	req := NewDeliveryRequest(
+		&delivery.Request{
+			RequestId: "a",
+			UserInfo:  &common.UserInfo{},
+			Insertion: []*delivery.Insertion{{ContentId: "z"}, {ContentId: ""}},
+		},
+		nil,
+		false,
+		-1,
+		nil,
+	)
+	issues := req.ValidateIssues()
+	assert.Equal(t, []ValidationIssue{
+		{Field: "request.requestId", Rule: RuleRequestIDUnset, Message: "Request.requestID should not be set"},
+		{Field: "request.userInfo.anonUserId", Rule: RuleAnonUserIDRequired, Message: "Request.userInfo.anonUserID should be set"},
+		{Field: "request.insertion[1].contentId", Rule: RuleContentIDRequired, Message: "Insertion.contentID should be set"},
+		{Field: "retrievalInsertionOffset", Rule: RuleNonNegativeOffset, Message: "Insertion start must be greater or equal to 0"},
+	}, issues)
+}
+
+func TestValidateIssuesUsesDefaultValidatorForLiteralRequests(t *testing.T) {
+	req := &DeliveryRequest{Request: &delivery.Request{}}
+	issues := req.ValidateIssues()
+	assert.Equal(t, 1, len(issues))
+	assert.Equal(t, RuleUserInfoRequired, issues[0].Rule)
+}
+
+type stringOnlyValidator struct{}
+
+func (v *stringOnlyValidator) Validate(request *DeliveryRequest) []string {
+	return []string{"bad request"}
+}
+
+func TestValidateIssuesWrapsStringValidators(t *testing.T) {
+	req := NewDeliveryRequest(&delivery.Request{}, nil, false, 0, &stringOnlyValidator{})
+	assert.Equal(t, []ValidationIssue{{Rule: RuleCustom, Message: "bad request"}}, req.ValidateIssues())
+}
+
+func TestStrictValidationRejectsBeforeNetworkCall(t *testing.T) {
+	mockSdkDelivery := new(MockDelivery)
+	mockApiDelivery := new(MockDelivery)
+	mockMetrics := new(MockMetrics)
+	client := createValidationClient(mockSdkDelivery, mockApiDelivery, mockMetrics, ValidationModeStrict)
+
+	req := &delivery.Request{Insertion: CreateTestRequestInsertions(3)}
+	dreq := NewDeliveryRequest(req, nil, false, 0, nil)
+
+	resp, err := client.Deliver(dreq)
+	assert.Nil(t, resp)
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.True(t, validationErr.HasRule(RuleUserInfoRequired))
+	assert.Equal(t, "request.userInfo", validationErr.Issues[0].Field)
+	assert.Equal(t, "invalid delivery request: request.userInfo: Request.userInfo should be set [user_info_required]", err.Error())
+
+	mockApiDelivery.AssertNotCalled(t, "RunDelivery", mock.Anything)
+	mockSdkDelivery.AssertNotCalled(t, "RunDelivery", mock.Anything)
+	mockMetrics.AssertNotCalled(t, "RunMetricsLogging", mock.Anything)
+	assert.Equal(t, "", req.ClientRequestId)
+}
+
+func TestStrictValidationAllowsValidRequest(t *testing.T) {
+	mockSdkDelivery := new(MockDelivery)
+	mockApiDelivery := new(MockDelivery)
+	mockMetrics := new(MockMetrics)
+	client := createValidationClient(mockSdkDelivery, mockApiDelivery, mockMetrics, ValidationModeStrict)
+
+	req := &delivery.Request{
+		UserInfo:  &common.UserInfo{AnonUserId: "a"},
+		Insertion: CreateTestRequestInsertions(3),
+	}
+	dreq := NewDeliveryRequest(req, nil, false, 0, nil)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{
+		RequestId: "r",
+		Insertion: CreateTestResponseInsertions(3, 0),
+	}, nil)
+
+	resp, err := client.Deliver(dreq)
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	mockApiDelivery.AssertCalled(t, "RunDelivery", dreq)
+}
+
+func TestStrictValidationUsesClientMaxRequestInsertions(t *testing.T) {
+	mockApiDelivery := new(MockDelivery)
+	client, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{sdkDelivery: new(MockDelivery), deliveryAPI: mockApiDelivery, metricsAPI: new(MockMetrics)}).
+		WithValidationMode(ValidationModeStrict).
+		WithMaxRequestInsertions(2).
+		Build()
+	assert.NoError(t, err)
+
+	req := &delivery.Request{
+		UserInfo:  &common.UserInfo{AnonUserId: "a"},
+		Insertion: CreateTestRequestInsertions(3),
+	}
+	_, err = client.Deliver(NewDeliveryRequest(req, nil, false, 0, nil))
+
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.True(t, validationErr.HasRule(RuleMaxRequestInsertions))
+	mockApiDelivery.AssertNotCalled(t, "RunDelivery", mock.Anything)
+}
+
+func TestDeliverRejectsNilRequests(t *testing.T) {
+	for _, mode := range []ValidationMode{ValidationModeOff, ValidationModeLog, ValidationModeStrict} {
+		mockSdkDelivery := new(MockDelivery)
+		mockApiDelivery := new(MockDelivery)
+		mockMetrics := new(MockMetrics)
+		client := createValidationClient(mockSdkDelivery, mockApiDelivery, mockMetrics, mode)
+
+		for _, dreq := range []*DeliveryRequest{nil, {}} {
+			resp, err := client.Deliver(dreq)
+			assert.Nil(t, resp)
+			if mode == ValidationModeStrict {
+				var validationErr *ValidationError
+				assert.True(t, errors.As(err, &validationErr))
+				assert.True(t, validationErr.HasRule(RuleRequestRequired))
+			} else {
+				assert.EqualError(t, err, "delivery request must be set")
+			}
+		}
+		mockApiDelivery.AssertNotCalled(t, "RunDelivery", mock.Anything)
+		mockSdkDelivery.AssertNotCalled(t, "RunDelivery", mock.Anything)
+	}
+}
+
+func TestLogValidationSendsInvalidRequest(t *testing.T) {
+	mockSdkDelivery := new(MockDelivery)
+	mockApiDelivery := new(MockDelivery)
+	mockMetrics := new(MockMetrics)
+	client := createValidationClient(mockSdkDelivery, mockApiDelivery, mockMetrics, ValidationModeLog)
+
+	req := &delivery.Request{Insertion: CreateTestRequestInsertions(3)}
+	dreq := NewDeliveryRequest(req, nil, false, 0, nil)
+	mockApiDelivery.On("RunDelivery", mock.Anything).Return(&delivery.Response{
+		RequestId: "r",
+		Insertion: CreateTestResponseInsertions(3, 0),
+	}, nil)
+
+	resp, err := client.Deliver(dreq)
+	assert.NoError(t, err)
+	assert.NotNil(t, resp)
+	mockApiDelivery.AssertCalled(t, "RunDelivery", dreq)
+}
+
+func TestWithPerformChecksMapsToValidationMode(t *testing.T) {
+	builder := NewPromotedDeliveryClientBuilder().WithPerformChecks(true)
+	assert.Equal(t, ValidationModeLog, builder.validationMode)
+	builder.WithPerformChecks(false)
+	assert.Equal(t, ValidationModeOff, builder.validationMode)
+}
+
+func TestBuildRejectsUnknownValidationMode(t *testing.T) {
+	_, err := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{}).
+		WithValidationMode(ValidationMode(7)).
+		Build()
+	assert.EqualError(t, err, "unknown validationMode 7")
+}
+
+func createValidationClient(sdkDelivery, apiDelivery DeliveryAPI, metrics MetricsAPI, mode ValidationMode) *PromotedDeliveryClient {
+	client, _ := newTestClientBuilder().
+		WithAPIFactory(&TestApiFactory{
+			sdkDelivery: sdkDelivery,
+			deliveryAPI: apiDelivery,
+			metricsAPI:  metrics,
+		}).
+		WithValidationMode(mode).
+		Build()
+	return client
+}
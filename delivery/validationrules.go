@@ -0,0 +1,267 @@
+package delivery
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"google.golang.org/protobuf/proto"
+)
+
+// Rule codes reported on validation issues. Each default rule is named after the code it reports.
+const (
+	RuleRequestRequired        = "request_required"
+	RuleRequestIDUnset         = "request_id_unset"
+	RuleUserInfoRequired       = "user_info_required"
+	RuleAnonUserIDRequired     = "anon_user_id_required"
+	RuleContentIDRequired      = "content_id_required"
+	RuleNonNegativeOffset      = "non_negative_offset"
+	RuleDuplicateContentID     = "duplicate_content_id"
+	RulePagingSize             = "paging_size"
+	RulePagingOffset           = "paging_offset"
+	RuleMaxRequestInsertions   = "max_request_insertions"
+	RuleUseCase                = "use_case"
+	RulePlatformID             = "platform_id"
+	RulePropertiesSize         = "properties_size"
+	RuleInsertionIDUnset       = "insertion_id_unset"
+	RuleTimingTimestampInRange = "timing_timestamp_range"
+	RuleInvalidProperties      = "invalid_properties"
+	RuleCustom                 = "custom"
+)
+
+// defaultMaxPropertiesBytes is the default limit on the encoded size of a single Properties struct.
+const defaultMaxPropertiesBytes = 64 * 1024
+
+// minClientLogTimestamp is the earliest plausible client log timestamp (2015-01-01 UTC) in millis.
+const minClientLogTimestamp = 1420070400000
+
+// maxClientLogTimestampSkew is how far in the future a client log timestamp may be.
+const maxClientLogTimestampSkew = 24 * time.Hour
+
+// DefaultValidationRules returns the rules run by DefaultDeliveryRequestValidator, in order.
+func DefaultValidationRules() []ValidationRule {
+	return DefaultValidationRulesFor(defaultMaxRequestInsertions)
+}
+
+// DefaultValidationRulesFor returns the default rules with the max request insertions rule set to
+// maxRequestInsertions, as used by clients built with WithMaxRequestInsertions.
+func DefaultValidationRulesFor(maxRequestInsertions int) []ValidationRule {
+	return []ValidationRule{
+		NewValidationRule(RuleRequestIDUnset, checkRequestIDUnset),
+		NewValidationRule(RuleUserInfoRequired, checkUserInfoRequired),
+		NewValidationRule(RuleAnonUserIDRequired, checkAnonUserIDRequired),
+		NewValidationRule(RuleContentIDRequired, checkContentIDRequired),
+		NewValidationRule(RuleNonNegativeOffset, checkNonNegativeOffset),
+		NewValidationRule(RuleDuplicateContentID, checkDuplicateContentID),
+		NewValidationRule(RulePagingSize, checkPagingSize),
+		NewValidationRule(RulePagingOffset, checkPagingOffset),
+		NewMaxRequestInsertionsRule(maxRequestInsertions),
+		NewValidationRule(RuleUseCase, checkUseCase),
+		NewValidationRule(RulePlatformID, checkPlatformID),
+		NewPropertiesSizeRule(defaultMaxPropertiesBytes),
+		NewValidationRule(RuleInsertionIDUnset, checkInsertionIDUnset),
+		NewTimingTimestampRangeRule(time.Now),
+	}
+}
+
+// NewMaxRequestInsertionsRule creates a rule that flags requests with more than maxRequestInsertions insertions,
+// which would otherwise be truncated before calling Delivery API.
+func NewMaxRequestInsertionsRule(maxRequestInsertions int) ValidationRule {
+	return NewValidationRule(RuleMaxRequestInsertions, func(request *DeliveryRequest) []ValidationIssue {
+		if len(request.Request.Insertion) <= maxRequestInsertions {
+			return nil
+		}
+		return []ValidationIssue{{
+			Field:   "request.insertion",
+			Message: fmt.Sprintf("Request has %d insertions, more than the maximum of %d", len(request.Request.Insertion), maxRequestInsertions),
+		}}
+	})
+}
+
+// NewPropertiesSizeRule creates a rule that flags request and insertion Properties larger than maxBytes when encoded.
+func NewPropertiesSizeRule(maxBytes int) ValidationRule {
+	return NewValidationRule(RulePropertiesSize, func(request *DeliveryRequest) []ValidationIssue {
+		var issues []ValidationIssue
+		check := func(field string, properties *common.Properties) {
+			if properties == nil {
+				return
+			}
+			if size := proto.Size(properties); size > maxBytes {
+				issues = append(issues, ValidationIssue{
+					Field:   field,
+					Message: fmt.Sprintf("Properties are %d bytes, more than the maximum of %d", size, maxBytes),
+				})
+			}
+		}
+		check("request.properties", request.Request.Properties)
+		for i, ins := range request.Request.Insertion {
+			check(fmt.Sprintf("request.insertion[%d].properties", i), ins.GetProperties())
+		}
+		return issues
+	})
+}
+
+// NewTimingTimestampRangeRule creates a rule that flags a client log timestamp before 2015 or more than a day
+// after now. An unset timestamp is filled in by the client and is not flagged.
+func NewTimingTimestampRangeRule(now func() time.Time) ValidationRule {
+	return NewValidationRule(RuleTimingTimestampInRange, func(request *DeliveryRequest) []ValidationIssue {
+		timestamp := request.Request.GetTiming().GetClientLogTimestamp()
+		if timestamp == 0 {
+			return nil
+		}
+		maxTimestamp := uint64(now().Add(maxClientLogTimestampSkew).UnixMilli())
+		if timestamp < minClientLogTimestamp || timestamp > maxTimestamp {
+			return []ValidationIssue{{
+				Field:   "request.timing.clientLogTimestamp",
+				Message: fmt.Sprintf("Request.timing.clientLogTimestamp %d is not a plausible time in millis", timestamp),
+			}}
+		}
+		return nil
+	})
+}
+
+func checkRequestIDUnset(request *DeliveryRequest) []ValidationIssue {
+	if request.Request.RequestId == "" {
+		return nil
+	}
+	return []ValidationIssue{{Field: "request.requestId", Message: "Request.requestID should not be set"}}
+}
+
+func checkUserInfoRequired(request *DeliveryRequest) []ValidationIssue {
+	if request.Request.UserInfo != nil {
+		return nil
+	}
+	return []ValidationIssue{{Field: "request.userInfo", Message: "Request.userInfo should be set"}}
+}
+
+func checkAnonUserIDRequired(request *DeliveryRequest) []ValidationIssue {
+	userInfo := request.Request.UserInfo
+	if userInfo == nil || userInfo.AnonUserId != "" {
+		return nil
+	}
+	return []ValidationIssue{{Field: "request.userInfo.anonUserId", Message: "Request.userInfo.anonUserID should be set"}}
+}
+
+func checkContentIDRequired(request *DeliveryRequest) []ValidationIssue {
+	var issues []ValidationIssue
+	for i, ins := range request.Request.Insertion {
+		if ins.GetContentId() == "" {
+			issues = append(issues, ValidationIssue{
+				Field:   fmt.Sprintf("request.insertion[%d].contentId", i),
+				Message: "Insertion.contentID should be set",
+			})
+		}
+	}
+	return issues
+}
+
+func checkNonNegativeOffset(request *DeliveryRequest) []ValidationIssue {
+	if request.RetrievalInsertionOffset >= 0 {
+		return nil
+	}
+	return []ValidationIssue{{Field: "retrievalInsertionOffset", Message: "Insertion start must be greater or equal to 0"}}
+}
+
+func checkDuplicateContentID(request *DeliveryRequest) []ValidationIssue {
+	var issues []ValidationIssue
+	seen := make(map[string]int, len(request.Request.Insertion))
+	for i, ins := range request.Request.Insertion {
+		contentID := ins.GetContentId()
+		if contentID == "" {
+			continue
+		}
+		if first, ok := seen[contentID]; ok {
+			issues = append(issues, ValidationIssue{
+				Field:   fmt.Sprintf("request.insertion[%d].contentId", i),
+				Message: fmt.Sprintf("Insertion.contentID %q duplicates request.insertion[%d]", contentID, first),
+			})
+			continue
+		}
+		seen[contentID] = i
+	}
+	return issues
+}
+
+func checkPagingSize(request *DeliveryRequest) []ValidationIssue {
+	paging := request.Request.Paging
+	if paging == nil {
+		return nil
+	}
+	if paging.Size < 0 {
+		return []ValidationIssue{{Field: "request.paging.size", Message: "Paging.size must be greater or equal to 0"}}
+	}
+	// Log-only requests log every insertion, and requests without insertions are retrieved by Delivery API.
+	numInsertions := len(request.Request.Insertion)
+	if request.OnlyLog || numInsertions == 0 || int(paging.Size) <= numInsertions {
+		return nil
+	}
+	return []ValidationIssue{{
+		Field:   "request.paging.size",
+		Message: fmt.Sprintf("Paging.size %d is more than the %d request insertions", paging.Size, numInsertions),
+	}}
+}
+
+func checkPagingOffset(request *DeliveryRequest) []ValidationIssue {
+	paging := request.Request.Paging
+	if paging == nil {
+		return nil
+	}
+	if _, ok := paging.Starting.(*delivery.Paging_Cursor); ok {
+		return nil
+	}
+	offset := int(paging.GetOffset())
+	if offset < 0 {
+		return []ValidationIssue{{Field: "request.paging.offset", Message: "Paging.offset must be greater or equal to 0"}}
+	}
+	if offset < request.RetrievalInsertionOffset {
+		return []ValidationIssue{{
+			Field:   "request.paging.offset",
+			Message: fmt.Sprintf("Paging.offset %d must be greater or equal to the insertion start %d", offset, request.RetrievalInsertionOffset),
+		}}
+	}
+	numInsertions := len(request.Request.Insertion)
+	if numInsertions > 0 && offset >= request.RetrievalInsertionOffset+numInsertions {
+		return []ValidationIssue{{
+			Field: "request.paging.offset",
+			Message: fmt.Sprintf("Paging.offset %d is past the last request insertion at %d",
+				offset, request.RetrievalInsertionOffset+numInsertions-1),
+		}}
+	}
+	return nil
+}
+
+func checkUseCase(request *DeliveryRequest) []ValidationIssue {
+	useCase := request.Request.UseCase
+	if _, ok := delivery.UseCase_name[int32(useCase)]; ok {
+		return nil
+	}
+	return []ValidationIssue{{Field: "request.useCase", Message: fmt.Sprintf("Request.useCase %d is not a known UseCase", int32(useCase))}}
+}
+
+func checkPlatformID(request *DeliveryRequest) []ValidationIssue {
+	var issues []ValidationIssue
+	platformID := request.Request.PlatformId
+	for i, ins := range request.Request.Insertion {
+		if ins.GetPlatformId() != 0 && ins.GetPlatformId() != platformID {
+			issues = append(issues, ValidationIssue{
+				Field:   fmt.Sprintf("request.insertion[%d].platformId", i),
+				Message: fmt.Sprintf("Insertion.platformID %d does not match Request.platformID %d", ins.GetPlatformId(), platformID),
+			})
+		}
+	}
+	return issues
+}
+
+func checkInsertionIDUnset(request *DeliveryRequest) []ValidationIssue {
+	var issues []ValidationIssue
+	for i, ins := range request.Request.Insertion {
+		if ins.GetInsertionId() != "" {
+			issues = append(issues, ValidationIssue{
+				Field:   fmt.Sprintf("request.insertion[%d].insertionId", i),
+				Message: "Insertion.insertionID should not be set on request insertions",
+			})
+		}
+	}
+	return issues
+}
@@ -1,15 +1,18 @@
 module github.com/promotedai/promoted-go-delivery-client
 
-go 1.21.4
+go 1.22
 
-require github.com/promotedai/schema v0.0.0-20240120215021-d8e3683056da
+require (
+	github.com/golang/mock v1.6.0
+	github.com/klauspost/compress v1.18.0
+	github.com/promotedai/schema v0.0.0-20240120215021-d8e3683056da
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/golang/mock v1.6.0 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	github.com/stretchr/objx v0.5.2 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
 
 require (
@@ -1,9 +1,91 @@
+// Command promoted-delivery calls Promoted.ai's Delivery and Metrics APIs from the command line.
+//
+// Usage:
+//
+//	promoted-delivery <command> [flags] [file]
+//
+// Commands:
+//
+//	deliver   send a JSON delivery.Request and print the ranked response
+//	validate  run the request validators on a JSON delivery.Request
+//	log       send a JSON event.LogRequest to the Metrics API
+//	health    call the Delivery API health check
+//	simulate  check the allocation of a two-arm experiment over a list of user IDs
+//	bench     load test the client against an in-process fake Delivery and Metrics API
+//
+// bench runs the promotedtest fake server, which links the testing package, so it is only built with
+// "go build -tags bench".
+//
+// Endpoints and API keys are read from flags or from the PROMOTED_DELIVERY_ENDPOINT, PROMOTED_DELIVERY_API_KEY,
+// PROMOTED_METRICS_ENDPOINT and PROMOTED_METRICS_API_KEY environment variables. Files are read from stdin when
+// the file is "-" or missing. User ID files for simulate have one ID per line.
 package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 )
 
+const usage = `usage: promoted-delivery <command> [flags] [file]
+
+Commands:
+  deliver   send a JSON delivery.Request and print the ranked response
+  validate  run the request validators on a JSON delivery.Request
+  log       send a JSON event.LogRequest to the Metrics API
+  health    call the Delivery API health check
+  simulate  check the allocation of a two-arm experiment over a list of user IDs
+  bench     load test the client against an in-process fake Delivery and Metrics API
+            (only in builds with -tags bench)
+
+Run "promoted-delivery <command> -h" for the command's flags.
+`
+
+// Exit codes.
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+)
+
+// command runs a subcommand with its arguments and returns the exit code.
+type command func(args []string, env *cliEnv) int
+
+var commands = map[string]command{
+	"deliver":  runDeliver,
+	"validate": runValidate,
+	"log":      runLog,
+	"health":   runHealth,
+	"simulate": runSimulate,
+	"bench":    runBench,
+}
+
+// cliEnv is the process environment that commands run in, so tests can replace it.
+type cliEnv struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	getenv func(string) string
+}
+
 func main() {
-	fmt.Println("Promoted.ai Go Delivery Client")
+	os.Exit(run(os.Args[1:], &cliEnv{stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr, getenv: os.Getenv}))
+}
+
+// run dispatches to the subcommand and returns the exit code.
+func run(args []string, env *cliEnv) int {
+	if len(args) == 0 {
+		fmt.Fprint(env.stderr, usage)
+		return exitUsage
+	}
+	if args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		fmt.Fprint(env.stdout, usage)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(env.stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+	return cmd(args[1:], env)
 }
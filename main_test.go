@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/promotedai/promoted-go-delivery-client/promotedtest"
+)
+
+const testRequestJSON = `{
+  "userInfo": {"anonUserId": "anon"},
+  "paging": {"size": 2, "offset": 0},
+  "insertion": [{"contentId": "a"}, {"contentId": "b"}, {"contentId": "c"}]
+}`
+
+// runCLI runs the CLI with the environment variables and stdin, returning the exit code, stdout and stderr.
+func runCLI(args []string, vars map[string]string, stdin string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	env := &cliEnv{
+		stdin:  strings.NewReader(stdin),
+		stdout: &stdout,
+		stderr: &stderr,
+		getenv: func(key string) string { return vars[key] },
+	}
+	code := run(args, env)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeTestFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestDeliverCommand(t *testing.T) {
+	s := promotedtest.NewServer(t).WithAPIKey("key").WithRanker(promotedtest.ReverseRanker)
+	file := writeTestFile(t, "request.json", testRequestJSON)
+
+	code, stdout, stderr := runCLI([]string{"deliver", file}, map[string]string{
+		"PROMOTED_DELIVERY_ENDPOINT": s.URL,
+		"PROMOTED_DELIVERY_API_KEY":  "key",
+	}, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "requestId: fake-request-1\n"+
+		"POSITION  CONTENT ID  INSERTION ID\n"+
+		"0         c           fake-request-1-0\n"+
+		"1         b           fake-request-1-1\n", stdout)
+	assert.Equal(t, "anon", s.DeliveryRequests()[0].UserInfo.AnonUserId)
+}
+
+func TestDeliverCommandFlagsOverrideEnvironment(t *testing.T) {
+	s := promotedtest.NewServer(t).WithAPIKey("flag-key")
+	code, stdout, stderr := runCLI([]string{"deliver", "-endpoint", s.URL, "-api-key", "flag-key", "-json", "-"}, map[string]string{
+		"PROMOTED_DELIVERY_ENDPOINT": "http://localhost:1",
+		"PROMOTED_DELIVERY_API_KEY":  "env-key",
+	}, testRequestJSON)
+	assert.Equal(t, exitOK, code, stderr)
+	var resp delivery.Response
+	assert.NoError(t, protojson.Unmarshal([]byte(stdout), &resp))
+	assert.Equal(t, "fake-request-1", resp.RequestId)
+	assert.Equal(t, "flag-key", s.Requests()[0].Header.Get("x-api-key"))
+}
+
+func TestDeliverCommandSDKOnly(t *testing.T) {
+	code, stdout, stderr := runCLI([]string{"deliver", "-sdk-only"}, nil, testRequestJSON)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "0         a")
+	assert.Contains(t, stdout, "1         b")
+	assert.NotContains(t, stdout, " c ")
+}
+
+func TestDeliverCommandErrors(t *testing.T) {
+	code, _, stderr := runCLI([]string{"deliver"}, nil, testRequestJSON)
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: endpoint must be set with -endpoint or the environment\n", stderr)
+
+	code, _, stderr = runCLI([]string{"deliver", "-sdk-only"}, nil, "{not json")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "error: error parsing stdin")
+
+	code, _, stderr = runCLI([]string{"deliver", "-sdk-only", filepath.Join(t.TempDir(), "missing.json")}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "missing.json")
+
+	s := promotedtest.NewServer(t).FailDeliver(http.StatusInternalServerError, 1)
+	code, _, stderr = runCLI([]string{"deliver", "-endpoint", s.URL}, nil, testRequestJSON)
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: failure calling Delivery API; statusCode=500\n", stderr)
+
+	code, _, stderr = runCLI([]string{"deliver", "-endpoint", "%zz"}, nil, testRequestJSON)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stderr, "error: endpoint is not a valid URL")
+}
+
+func TestValidateCommand(t *testing.T) {
+	code, stdout, _ := runCLI([]string{"validate"}, nil, testRequestJSON)
+	assert.Equal(t, exitOK, code)
+	assert.Equal(t, "ok\n", stdout)
+
+	code, stdout, stderr := runCLI([]string{"validate"}, nil, `{"requestId": "r", "insertion": [{"contentId": ""}]}`)
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, "request.requestId: Request.requestID should not be set [request_id_unset]")
+	assert.Contains(t, stdout, "Insertion.contentID should be set [content_id_required]")
+	assert.Contains(t, stderr, "validation issue(s)")
+}
+
+func TestLogCommand(t *testing.T) {
+	s := promotedtest.NewServer(t).WithAPIKey("metrics-key")
+	code, stdout, stderr := runCLI([]string{"log"}, map[string]string{
+		"PROMOTED_METRICS_ENDPOINT": s.MetricsEndpoint(),
+		"PROMOTED_METRICS_API_KEY":  "metrics-key",
+	}, `{"platformId": "7", "userInfo": {"anonUserId": "anon"}}`)
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Equal(t, "ok\n", stdout)
+	assert.Equal(t, uint64(7), s.LogRequests()[0].PlatformId)
+	assert.Equal(t, "anon", s.LogRequests()[0].UserInfo.AnonUserId)
+}
+
+func TestHealthCommand(t *testing.T) {
+	s := promotedtest.NewServer(t).WithAPIKey("key")
+	code, stdout, stderr := runCLI([]string{"health", "-endpoint", s.URL, "-api-key", "key"}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "ok ("), stdout)
+
+	code, _, stderr = runCLI([]string{"health", "-endpoint", s.URL, "-api-key", "wrong"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: failure calling Delivery API health check; statusCode=403\n", stderr)
+
+	code, _, stderr = runCLI([]string{"health", "-endpoint", "localhost:8080"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: endpoint \"localhost:8080\" must use http or https\n", stderr)
+}
+
+func TestUsage(t *testing.T) {
+	code, _, stderr := runCLI(nil, nil, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: promoted-delivery")
+
+	code, _, stderr = runCLI([]string{"rank"}, nil, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "rank"`)
+
+	code, stdout, _ := runCLI([]string{"help"}, nil, "")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stdout, "Commands:")
+
+	code, _, stderr = runCLI([]string{"deliver", "-h"}, nil, "")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stderr, "-sdk-only")
+
+	code, _, _ = runCLI([]string{"validate", "a.json", "b.json"}, nil, "")
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestSimulateCommand(t *testing.T) {
+	code, stdout, stderr := runCLI([]string{"simulate", "-cohort-id", "HOLD_OUT", "-active-control", "10", "-active-treatment", "20", "-synthetic", "5000"}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.True(t, strings.HasPrefix(stdout, "cohort HOLD_OUT: 5000 users\nARM        USERS  EXPECTED"), stdout)
+	assert.Contains(t, stdout, "control    ")
+	assert.Contains(t, stdout, "10.00%\n")
+	assert.Contains(t, stdout, "no sample ratio mismatch\n")
+	assert.NotContains(t, stdout, "overlap")
+
+	code, stdout, stderr = runCLI([]string{"simulate", "-cohort-id", "A", "-synthetic", "1000", "-other-cohort-id", "B", "-other-active-control", "25", "-other-active-treatment", "25"}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "overlap with cohort B (rows A, columns B)\nARM        CONTROL  TREATMENT  NONE\n")
+	assert.Contains(t, stdout, "ASSIGNMENTS ARE NOT INDEPENDENT")
+}
+
+func TestSimulateCommandReadsUserIDs(t *testing.T) {
+	// user2 is in control and user4 is in treatment when every bucket is active.
+	file := writeTestFile(t, "users.txt", "user2\nuser4\n\n  user4  \n")
+	code, stdout, stderr := runCLI([]string{"simulate", "-cohort-id", "HOLD_OUT", file}, nil, "")
+	assert.Equal(t, exitOK, code, stderr)
+	assert.Contains(t, stdout, "cohort HOLD_OUT: 3 users\n")
+	assert.Contains(t, stdout, "control    1      1.5")
+	assert.Contains(t, stdout, "treatment  2      1.5")
+
+	// A single repeated user lands in one arm, which is a sample ratio mismatch.
+	code, stdout, _ = runCLI([]string{"simulate", "-cohort-id", "HOLD_OUT"}, nil, strings.Repeat("user2\n", 100))
+	assert.Equal(t, exitFailure, code)
+	assert.Contains(t, stdout, "SAMPLE RATIO MISMATCH (p < 0.001)\n")
+}
+
+func TestSimulateCommandErrors(t *testing.T) {
+	code, _, stderr := runCLI([]string{"simulate", "-synthetic", "10"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: cohort ID must be non-empty\n", stderr)
+
+	code, _, stderr = runCLI([]string{"simulate", "-cohort-id", "A", "-synthetic", "10", "-other-cohort-id", "B", "-other-active-control", "60"}, nil, "")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: other experiment: active control buckets must be between 0 and the total number of control buckets\n", stderr)
+
+	code, _, stderr = runCLI([]string{"simulate", "-cohort-id", "A", "-synthetic", "10", "users.txt"}, nil, "")
+	assert.Equal(t, exitUsage, code)
+	assert.Equal(t, "-synthetic cannot be used with a user ID file\n", stderr)
+
+	code, _, stderr = runCLI([]string{"simulate", "-cohort-id", "A"}, nil, "\n")
+	assert.Equal(t, exitFailure, code)
+	assert.Equal(t, "error: at least one user ID is required\n", stderr)
+}
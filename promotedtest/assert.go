@@ -0,0 +1,129 @@
+package promotedtest
+
+import (
+	"testing"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+// The assertion helpers report failures with t.Errorf and return whether the assertion held, so they work with
+// any test framework.
+
+// AssertExecutionServer checks that the log request has at least one DeliveryLog and that every DeliveryLog was
+// executed by want.
+func AssertExecutionServer(t testing.TB, logRequest *event.LogRequest, want delivery.ExecutionServer) bool {
+	t.Helper()
+	if len(logRequest.GetDeliveryLog()) == 0 {
+		t.Errorf("log request has no DeliveryLog; want execution server %v", want)
+		return false
+	}
+	ok := true
+	for i, deliveryLog := range logRequest.GetDeliveryLog() {
+		if got := deliveryLog.GetExecution().GetExecutionServer(); got != want {
+			t.Errorf("DeliveryLog[%d] execution server = %v; want %v", i, got, want)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertNoDeliveryLog checks that the log request has no DeliveryLog, as when the Delivery API response was used.
+func AssertNoDeliveryLog(t testing.TB, logRequest *event.LogRequest) bool {
+	t.Helper()
+	if n := len(logRequest.GetDeliveryLog()); n != 0 {
+		t.Errorf("log request has %d DeliveryLog(s); want none", n)
+		return false
+	}
+	return true
+}
+
+// AssertCohortMembership checks that the log request has a cohort membership for cohortID in arm.
+func AssertCohortMembership(t testing.TB, logRequest *event.LogRequest, cohortID string, arm event.CohortArm) bool {
+	t.Helper()
+	for _, membership := range logRequest.GetCohortMembership() {
+		if membership.GetCohortId() != cohortID {
+			continue
+		}
+		if membership.GetArm() != arm {
+			t.Errorf("cohort %q arm = %v; want %v", cohortID, membership.GetArm(), arm)
+			return false
+		}
+		return true
+	}
+	var cohortIDs []string
+	for _, membership := range logRequest.GetCohortMembership() {
+		cohortIDs = append(cohortIDs, membership.GetCohortId())
+	}
+	t.Errorf("log request has no cohort membership for %q; got %v", cohortID, cohortIDs)
+	return false
+}
+
+// AssertNoCohortMembership checks that the log request has no cohort memberships.
+func AssertNoCohortMembership(t testing.TB, logRequest *event.LogRequest) bool {
+	t.Helper()
+	if n := len(logRequest.GetCohortMembership()); n != 0 {
+		t.Errorf("log request has %d cohort membership(s); want none", n)
+		return false
+	}
+	return true
+}
+
+// AssertTrafficType checks the traffic type of the log request's ClientInfo.
+func AssertTrafficType(t testing.TB, logRequest *event.LogRequest, want common.ClientInfo_TrafficType) bool {
+	t.Helper()
+	if got := logRequest.GetClientInfo().GetTrafficType(); got != want {
+		t.Errorf("traffic type = %v; want %v", got, want)
+		return false
+	}
+	return true
+}
+
+// AssertInsertionPositions checks that the response insertions have the content IDs in order, at consecutive
+// positions starting at startPosition, and with insertion IDs.
+func AssertInsertionPositions(t testing.TB, resp *delivery.Response, contentIDs []string, startPosition uint64) bool {
+	t.Helper()
+	insertions := resp.GetInsertion()
+	if len(insertions) != len(contentIDs) {
+		t.Errorf("response has %d insertions; want %d", len(insertions), len(contentIDs))
+		return false
+	}
+	ok := true
+	for i, ins := range insertions {
+		if ins.GetContentId() != contentIDs[i] {
+			t.Errorf("insertion[%d] content ID = %q; want %q", i, ins.GetContentId(), contentIDs[i])
+			ok = false
+		}
+		if ins.Position == nil {
+			t.Errorf("insertion[%d] position is not set; want %d", i, startPosition+uint64(i))
+			ok = false
+		} else if ins.GetPosition() != startPosition+uint64(i) {
+			t.Errorf("insertion[%d] position = %d; want %d", i, ins.GetPosition(), startPosition+uint64(i))
+			ok = false
+		}
+		if ins.GetInsertionId() == "" {
+			t.Errorf("insertion[%d] insertion ID is not set", i)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// AssertPlanReason checks why Deliver did or didn't plan to use the Delivery API response, and that it didn't fall
+// back to SDK delivery after a failed call.
+func AssertPlanReason(t testing.TB, resp *promoted.DeliveryResponse, want promoted.PlanReason) bool {
+	t.Helper()
+	ok := true
+	if resp.PlanReason != want {
+		t.Errorf("plan reason = %v; want %v", resp.PlanReason, want)
+		ok = false
+	}
+	if resp.FallbackReason != promoted.FallbackReasonNone {
+		t.Errorf("fell back to SDK delivery: %v", resp.FallbackReason)
+		ok = false
+	}
+	return ok
+}
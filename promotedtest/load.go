@@ -0,0 +1,349 @@
+package promotedtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+// defaultMetricsDrainTimeout is how long RunLoad waits for background Metrics API logs after the last request.
+const defaultMetricsDrainTimeout = 5 * time.Second
+
+// goroutineSampleInterval is how often RunLoad samples the goroutine count.
+const goroutineSampleInterval = 10 * time.Millisecond
+
+// LoadConfig configures RunLoad.
+type LoadConfig struct {
+	// QPS is the target rate of Deliver calls. Zero calls Deliver as fast as the workers can.
+	QPS float64
+
+	// Concurrency is the number of goroutines calling Deliver. Defaults to 1.
+	Concurrency int
+
+	// Duration is how long to send requests. Either Duration or Requests must be set.
+	Duration time.Duration
+
+	// Requests stops the run after this many Deliver calls when positive.
+	Requests int
+
+	// Insertions is the number of request insertions in each request. Defaults to 100.
+	Insertions int
+
+	// PageSize is the paging size of each request. Zero requests every insertion.
+	PageSize int
+
+	// Experiment is set on every request. Requests in an experiment are logged to the Metrics API, and requests in
+	// the CONTROL arm use SDK delivery.
+	Experiment *event.CohortMembership
+
+	// MetricsDrainTimeout is how long to wait for background Metrics API logs after the last request. Defaults to
+	// 5 seconds.
+	MetricsDrainTimeout time.Duration
+}
+
+// LatencyPercentiles summarizes Deliver latencies.
+type LatencyPercentiles struct {
+	Mean time.Duration
+	P50  time.Duration
+	P90  time.Duration
+	P99  time.Duration
+	Max  time.Duration
+}
+
+// LoadReport is the result of RunLoad. Allocations are counted for the whole process, so they include building the
+// requests and the in-process fake server.
+type LoadReport struct {
+	// Requests is the number of Deliver calls.
+	Requests int
+
+	// Errors is the number of Deliver calls that returned an error.
+	Errors int
+
+	// Fallbacks is the number of responses that came from SDK delivery when the Delivery API should have been used,
+	// because the call failed or timed out.
+	Fallbacks int
+
+	// Elapsed is the time from the first Deliver call until the last one returned.
+	Elapsed time.Duration
+
+	// Latency is the distribution of Deliver latencies.
+	Latency LatencyPercentiles
+
+	// AllocsPerRequest is the number of heap allocations per Deliver call.
+	AllocsPerRequest float64
+
+	// BytesPerRequest is the number of heap bytes allocated per Deliver call.
+	BytesPerRequest float64
+
+	// GoroutinesBefore, GoroutinesPeak and GoroutinesAfter are the goroutine counts before the run, at its highest
+	// sample and after the Metrics API logs drained.
+	GoroutinesBefore int
+	GoroutinesPeak   int
+	GoroutinesAfter  int
+
+	// MetricsLogs is the number of Metrics API requests the server received.
+	MetricsLogs int
+
+	// MetricsLogsPending is the number of expected Metrics API requests that had not arrived when the drain timed
+	// out.
+	MetricsLogsPending int
+
+	// MetricsDrain is how long the Metrics API logs took to arrive after the last Deliver call returned.
+	MetricsDrain time.Duration
+}
+
+// QPS is the achieved rate of Deliver calls.
+func (r *LoadReport) QPS() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// FallbackRate is the fraction of Deliver calls that fell back to SDK delivery.
+func (r *LoadReport) FallbackRate() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Fallbacks) / float64(r.Requests)
+}
+
+// MetricsLogsPerSecond is the rate the server received Metrics API requests over the run and the drain.
+func (r *LoadReport) MetricsLogsPerSecond() float64 {
+	elapsed := r.Elapsed + r.MetricsDrain
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(r.MetricsLogs) / elapsed.Seconds()
+}
+
+// Write prints the report as a table.
+func (r *LoadReport) Write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "requests\t%d\n", r.Requests)
+	fmt.Fprintf(tw, "elapsed\t%s\n", r.Elapsed.Round(time.Millisecond))
+	fmt.Fprintf(tw, "qps\t%.1f\n", r.QPS())
+	fmt.Fprintf(tw, "errors\t%d\n", r.Errors)
+	fmt.Fprintf(tw, "fallbacks\t%d (%.2f%%)\n", r.Fallbacks, 100*r.FallbackRate())
+	fmt.Fprintf(tw, "latency mean\t%s\n", r.Latency.Mean)
+	fmt.Fprintf(tw, "latency p50\t%s\n", r.Latency.P50)
+	fmt.Fprintf(tw, "latency p90\t%s\n", r.Latency.P90)
+	fmt.Fprintf(tw, "latency p99\t%s\n", r.Latency.P99)
+	fmt.Fprintf(tw, "latency max\t%s\n", r.Latency.Max)
+	fmt.Fprintf(tw, "allocs/request\t%.0f\n", r.AllocsPerRequest)
+	fmt.Fprintf(tw, "bytes/request\t%.0f\n", r.BytesPerRequest)
+	fmt.Fprintf(tw, "goroutines\t%d before, %d peak, %d after\n", r.GoroutinesBefore, r.GoroutinesPeak, r.GoroutinesAfter)
+	fmt.Fprintf(tw, "metrics logs\t%d (%.1f/s, %d pending, drained in %s)\n", r.MetricsLogs, r.MetricsLogsPerSecond(), r.MetricsLogsPending, r.MetricsDrain.Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// NewLoadRequest returns a request with n insertions, as sent by RunLoad. The user varies with seq.
+func NewLoadRequest(seq, n, pageSize int) *delivery.Request {
+	if pageSize <= 0 {
+		pageSize = n
+	}
+	insertions := make([]*delivery.Insertion, n)
+	for i := range insertions {
+		insertions[i] = &delivery.Insertion{ContentId: "content-" + strconv.Itoa(i)}
+	}
+	return &delivery.Request{
+		UserInfo:  &common.UserInfo{AnonUserId: "load-user-" + strconv.Itoa(seq%1000)},
+		Paging:    &delivery.Paging{Size: int32(pageSize), Starting: &delivery.Paging_Offset{Offset: 0}},
+		Insertion: insertions,
+	}
+}
+
+// RunLoad calls client.Deliver at the configured rate and concurrency and reports latency, allocations, goroutines,
+// fallbacks and Metrics API throughput. The client should be configured against the server, for example with
+// Server.ConfigureBuilder. Requests and logs are counted with Server.RequestCount, so recording can be turned off.
+// Canceling ctx stops the run early.
+func RunLoad(ctx context.Context, client *promoted.PromotedDeliveryClient, server *Server, config LoadConfig) (*LoadReport, error) {
+	if client == nil || server == nil {
+		return nil, errors.New("client and server must be set")
+	}
+	if config.Duration <= 0 && config.Requests <= 0 {
+		return nil, errors.New("duration or requests must be set")
+	}
+	if config.QPS < 0 {
+		return nil, errors.New("qps must not be negative")
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 1
+	}
+	if config.Insertions <= 0 {
+		config.Insertions = 100
+	}
+	if config.MetricsDrainTimeout <= 0 {
+		config.MetricsDrainTimeout = defaultMetricsDrainTimeout
+	}
+	if config.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config.Duration)
+		defer cancel()
+	}
+
+	report := &LoadReport{GoroutinesBefore: runtime.NumGoroutine()}
+	metricsBefore := server.RequestCount(MetricsPath)
+	expectAPI := config.Experiment.GetArm() != event.CohortArm_CONTROL
+
+	sampler := newGoroutineSampler()
+	var memBefore, memAfter runtime.MemStats
+	runtime.ReadMemStats(&memBefore)
+
+	jobs := make(chan int)
+	go dispatch(ctx, jobs, config)
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+	expectedLogs := 0
+	var wg sync.WaitGroup
+	start := time.Now()
+	for w := 0; w < config.Concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			errs, fallbacks, logs := 0, 0, 0
+			for seq := range jobs {
+				req := promoted.NewDeliveryRequest(NewLoadRequest(seq, config.Insertions, config.PageSize), config.Experiment, false, 0, nil)
+				callStart := time.Now()
+				resp, err := client.Deliver(req)
+				local = append(local, time.Since(callStart))
+				if err != nil {
+					errs++
+					continue
+				}
+				if resp.ExecutionServer == delivery.ExecutionServer_SDK {
+					logs++
+					if expectAPI {
+						fallbacks++
+					}
+				} else if config.Experiment != nil {
+					logs++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, local...)
+			report.Errors += errs
+			report.Fallbacks += fallbacks
+			expectedLogs += logs
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	report.Elapsed = time.Since(start)
+
+	runtime.ReadMemStats(&memAfter)
+	report.Requests = len(latencies)
+	if report.Requests > 0 {
+		report.AllocsPerRequest = float64(memAfter.Mallocs-memBefore.Mallocs) / float64(report.Requests)
+		report.BytesPerRequest = float64(memAfter.TotalAlloc-memBefore.TotalAlloc) / float64(report.Requests)
+	}
+	report.Latency = percentiles(latencies)
+
+	drainStart := time.Now()
+	server.WaitForRequestCount(MetricsPath, metricsBefore+expectedLogs, config.MetricsDrainTimeout)
+	report.MetricsDrain = time.Since(drainStart)
+	report.MetricsLogs = server.RequestCount(MetricsPath) - metricsBefore
+	report.MetricsLogsPending = max(expectedLogs-report.MetricsLogs, 0)
+	report.GoroutinesPeak = sampler.stop()
+	report.GoroutinesAfter = runtime.NumGoroutine()
+	return report, nil
+}
+
+// dispatch sends request sequence numbers to the workers at the configured rate until the context is done or
+// enough requests were sent, then closes jobs.
+func dispatch(ctx context.Context, jobs chan<- int, config LoadConfig) {
+	defer close(jobs)
+	var interval time.Duration
+	if config.QPS > 0 {
+		interval = time.Duration(float64(time.Second) / config.QPS)
+	}
+	start := time.Now()
+	for seq := 0; config.Requests <= 0 || seq < config.Requests; seq++ {
+		if interval > 0 {
+			// Schedule from the start so slow sends do not lower the rate.
+			if wait := time.Until(start.Add(time.Duration(seq) * interval)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+					return
+				}
+			}
+		}
+		select {
+		case jobs <- seq:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// percentiles returns the latency percentiles using the nearest rank.
+func percentiles(latencies []time.Duration) LatencyPercentiles {
+	if len(latencies) == 0 {
+		return LatencyPercentiles{}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	var total time.Duration
+	for _, l := range latencies {
+		total += l
+	}
+	rank := func(p float64) time.Duration {
+		i := int(p*float64(len(latencies))+0.5) - 1
+		return latencies[min(max(i, 0), len(latencies)-1)]
+	}
+	return LatencyPercentiles{
+		Mean: total / time.Duration(len(latencies)),
+		P50:  rank(0.50),
+		P90:  rank(0.90),
+		P99:  rank(0.99),
+		Max:  latencies[len(latencies)-1],
+	}
+}
+
+// goroutineSampler tracks the highest goroutine count until stopped.
+type goroutineSampler struct {
+	done chan struct{}
+	peak chan int
+}
+
+func newGoroutineSampler() *goroutineSampler {
+	s := &goroutineSampler{done: make(chan struct{}), peak: make(chan int)}
+	go func() {
+		peak := runtime.NumGoroutine()
+		ticker := time.NewTicker(goroutineSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				peak = max(peak, runtime.NumGoroutine())
+			case <-s.done:
+				s.peak <- max(peak, runtime.NumGoroutine())
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// stop stops sampling and returns the peak.
+func (s *goroutineSampler) stop() int {
+	close(s.done)
+	return <-s.peak
+}
@@ -0,0 +1,121 @@
+package promotedtest
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunLoadCountsRequests(t *testing.T) {
+	s := NewServer(t).WithRecording(false)
+	client := newTestClient(t, s)
+
+	report, err := RunLoad(context.Background(), client, s, LoadConfig{Requests: 50, Concurrency: 4, Insertions: 20, PageSize: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 50, report.Requests)
+	assert.Equal(t, 0, report.Errors)
+	assert.Equal(t, 0, report.Fallbacks)
+	assert.Equal(t, 50, s.RequestCount(DeliverPath))
+	assert.Empty(t, s.Requests())
+	// Delivery API responses outside an experiment are not logged.
+	assert.Equal(t, 0, report.MetricsLogs)
+	assert.True(t, report.Latency.P50 > 0)
+	assert.True(t, report.Latency.P50 <= report.Latency.P99)
+	assert.True(t, report.Latency.P99 <= report.Latency.Max)
+	assert.True(t, report.AllocsPerRequest > 0)
+	assert.True(t, report.GoroutinesPeak >= report.GoroutinesBefore)
+}
+
+func TestRunLoadReportsFallbacksAndMetricsLogs(t *testing.T) {
+	s := NewServer(t).FailDeliver(500, 10)
+	client := newTestClient(t, s)
+
+	report, err := RunLoad(context.Background(), client, s, LoadConfig{Requests: 30, Concurrency: 3, Insertions: 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, report.Fallbacks)
+	assert.InDelta(t, 1.0/3, report.FallbackRate(), 1e-9)
+	// Each fallback is logged to the Metrics API in the background.
+	assert.Equal(t, 10, report.MetricsLogs)
+	assert.Equal(t, 0, report.MetricsLogsPending)
+	assert.Equal(t, 10, len(s.LogRequests()))
+}
+
+func TestRunLoadWithControlExperiment(t *testing.T) {
+	s := NewServer(t)
+	client := newTestClient(t, s)
+
+	experiment := &event.CohortMembership{CohortId: "HOLD_OUT", Arm: event.CohortArm_CONTROL}
+	report, err := RunLoad(context.Background(), client, s, LoadConfig{Requests: 20, Concurrency: 2, Insertions: 5, Experiment: experiment})
+	assert.NoError(t, err)
+	// The control arm uses SDK delivery by design, which is not a fallback.
+	assert.Equal(t, 0, report.Fallbacks)
+	assert.Equal(t, 0, s.RequestCount(DeliverPath))
+	assert.Equal(t, 20, report.MetricsLogs)
+}
+
+func TestRunLoadPacesRequests(t *testing.T) {
+	s := NewServer(t)
+	client := newTestClient(t, s)
+
+	report, err := RunLoad(context.Background(), client, s, LoadConfig{QPS: 100, Requests: 10, Concurrency: 2, Insertions: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, report.Requests)
+	// The tenth request is scheduled 90ms after the first.
+	assert.True(t, report.Elapsed >= 90*time.Millisecond, report.Elapsed)
+	assert.True(t, report.QPS() <= 115, report.QPS())
+}
+
+func TestRunLoadStopsAfterDuration(t *testing.T) {
+	s := NewServer(t)
+	client := newTestClient(t, s)
+
+	report, err := RunLoad(context.Background(), client, s, LoadConfig{QPS: 200, Duration: 100 * time.Millisecond, Insertions: 1})
+	assert.NoError(t, err)
+	assert.True(t, report.Requests > 0 && report.Requests <= 21, report.Requests)
+	assert.True(t, report.Elapsed < time.Second, report.Elapsed)
+}
+
+func TestRunLoadErrors(t *testing.T) {
+	s := NewServer(t)
+	client := newTestClient(t, s)
+
+	_, err := RunLoad(context.Background(), nil, s, LoadConfig{Requests: 1})
+	assert.EqualError(t, err, "client and server must be set")
+	_, err = RunLoad(context.Background(), client, s, LoadConfig{})
+	assert.EqualError(t, err, "duration or requests must be set")
+	_, err = RunLoad(context.Background(), client, s, LoadConfig{Requests: 1, QPS: -1})
+	assert.EqualError(t, err, "qps must not be negative")
+}
+
+func TestLoadReportWrite(t *testing.T) {
+	report := &LoadReport{
+		Requests:    200,
+		Fallbacks:   2,
+		Elapsed:     2 * time.Second,
+		Latency:     LatencyPercentiles{P50: time.Millisecond, P99: 5 * time.Millisecond},
+		MetricsLogs: 2,
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, report.Write(&buf))
+	assert.Contains(t, buf.String(), "qps             100.0\n")
+	assert.Contains(t, buf.String(), "fallbacks       2 (1.00%)\n")
+	assert.Contains(t, buf.String(), "latency p99     5ms\n")
+}
+
+func TestPercentiles(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i >= 1; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+	p := percentiles(latencies)
+	assert.Equal(t, 50*time.Millisecond, p.P50)
+	assert.Equal(t, 90*time.Millisecond, p.P90)
+	assert.Equal(t, 99*time.Millisecond, p.P99)
+	assert.Equal(t, 100*time.Millisecond, p.Max)
+	assert.Equal(t, 50500*time.Microsecond, p.Mean)
+	assert.Equal(t, LatencyPercentiles{}, percentiles(nil))
+}
@@ -0,0 +1,141 @@
+package promotedtest
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/proto"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+// RecordingMetricsAPI is an in-memory MetricsAPI that records every log request. It is safe for concurrent use.
+type RecordingMetricsAPI struct {
+	mu       sync.Mutex
+	requests []*event.LogRequest
+	err      error
+	// changed is closed and replaced whenever a request is recorded, waking WaitFor.
+	changed chan struct{}
+}
+
+// NewRecordingMetricsAPI creates an empty RecordingMetricsAPI.
+func NewRecordingMetricsAPI() *RecordingMetricsAPI {
+	return &RecordingMetricsAPI{changed: make(chan struct{})}
+}
+
+// RunMetricsLogging records a copy of the log request and returns the configured error, if any.
+func (m *RecordingMetricsAPI) RunMetricsLogging(logRequest *event.LogRequest) error {
+	recorded := proto.Clone(logRequest).(*event.LogRequest)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = append(m.requests, recorded)
+	close(m.changed)
+	m.changed = make(chan struct{})
+	return m.err
+}
+
+// WithError makes RunMetricsLogging return err after recording, to test how callers handle logging failures.
+func (m *RecordingMetricsAPI) WithError(err error) *RecordingMetricsAPI {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.err = err
+	return m
+}
+
+// LogRequests returns the log requests recorded so far, in the order they were logged.
+func (m *RecordingMetricsAPI) LogRequests() []*event.LogRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*event.LogRequest(nil), m.requests...)
+}
+
+// DeliveryLogs returns the delivery logs of every recorded log request.
+func (m *RecordingMetricsAPI) DeliveryLogs() []*delivery.DeliveryLog {
+	var logs []*delivery.DeliveryLog
+	for _, r := range m.LogRequests() {
+		logs = append(logs, r.DeliveryLog...)
+	}
+	return logs
+}
+
+// WaitFor waits until at least n log requests have been recorded, since the client logs in the background. It
+// returns the log requests recorded so far and whether there were n before the timeout.
+func (m *RecordingMetricsAPI) WaitFor(n int, timeout time.Duration) ([]*event.LogRequest, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		m.mu.Lock()
+		requests := append([]*event.LogRequest(nil), m.requests...)
+		changed := m.changed
+		m.mu.Unlock()
+		if len(requests) >= n {
+			return requests, true
+		}
+		select {
+		case <-changed:
+		case <-timer.C:
+			return m.LogRequests(), len(m.LogRequests()) >= n
+		}
+	}
+}
+
+// Reset clears the recorded log requests.
+func (m *RecordingMetricsAPI) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests = nil
+}
+
+// ConfigureBuilder makes the builder log to this RecordingMetricsAPI. It replaces the builder's APIFactory with a
+// MetricsAPIFactory over DefaultAPIFactory, so call it before WithAPIFactory to keep a different factory.
+func (m *RecordingMetricsAPI) ConfigureBuilder(b *promoted.PromotedDeliveryClientBuilder) *promoted.PromotedDeliveryClientBuilder {
+	return b.WithAPIFactory(&MetricsAPIFactory{Metrics: m})
+}
+
+// MetricsAPIFactory wraps an APIFactory to return a given MetricsAPI, such as a RecordingMetricsAPI.
+type MetricsAPIFactory struct {
+	// Base creates the SDK and Delivery API instances. Defaults to DefaultAPIFactory.
+	Base promoted.APIFactory
+
+	// Metrics is returned by CreateMetricsAPI.
+	Metrics promoted.MetricsAPI
+}
+
+// CreateSDKDelivery creates an SDK delivery instance with the base factory.
+func (f *MetricsAPIFactory) CreateSDKDelivery() promoted.DeliveryAPI {
+	return f.base().CreateSDKDelivery()
+}
+
+// CreateDeliveryAPI creates an API delivery instance with the base factory.
+func (f *MetricsAPIFactory) CreateDeliveryAPI(endpoint, apiKey string, timeoutMillis int64, maxRequestInsertions int, acceptGzip, warmup bool) promoted.DeliveryAPI {
+	return f.base().CreateDeliveryAPI(endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+}
+
+// CreateMetricsAPI returns the wrapped MetricsAPI.
+func (f *MetricsAPIFactory) CreateMetricsAPI(endpoint, apiKey string, timeoutMillis int64) promoted.MetricsAPI {
+	return f.Metrics
+}
+
+// CreateDeliveryAPIWithHTTPClient creates an API delivery instance with the base factory. httpClient is only used
+// when the base factory is a promoted.HTTPAPIFactory.
+func (f *MetricsAPIFactory) CreateDeliveryAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64, maxRequestInsertions int, acceptGzip, warmup bool) promoted.DeliveryAPI {
+	if base, ok := f.base().(promoted.HTTPAPIFactory); ok {
+		return base.CreateDeliveryAPIWithHTTPClient(httpClient, endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+	}
+	return f.base().CreateDeliveryAPI(endpoint, apiKey, timeoutMillis, maxRequestInsertions, acceptGzip, warmup)
+}
+
+// CreateMetricsAPIWithHTTPClient returns the wrapped MetricsAPI.
+func (f *MetricsAPIFactory) CreateMetricsAPIWithHTTPClient(httpClient *http.Client, endpoint, apiKey string, timeoutMillis int64) promoted.MetricsAPI {
+	return f.Metrics
+}
+
+func (f *MetricsAPIFactory) base() promoted.APIFactory {
+	if f.Base == nil {
+		return &promoted.DefaultAPIFactory{}
+	}
+	return f.Base
+}
@@ -0,0 +1,146 @@
+package promotedtest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+// fakeT records assertion failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (f *fakeT) Helper() {}
+
+func (f *fakeT) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+func newRecordingClient(t *testing.T, s *Server, metrics *RecordingMetricsAPI) *promoted.PromotedDeliveryClient {
+	client, err := metrics.ConfigureBuilder(s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder())).Build()
+	assert.NoError(t, err)
+	return client
+}
+
+func TestRecordingMetricsAPIRecordsSDKDeliveryLog(t *testing.T) {
+	metrics := NewRecordingMetricsAPI()
+	client := newRecordingClient(t, NewServer(t), metrics)
+
+	req := newTestRequest(3)
+	req.OnlyLog = true
+	resp, err := client.Deliver(req)
+	assert.NoError(t, err)
+
+	logs, ok := metrics.WaitFor(1, time.Second)
+	assert.True(t, ok)
+	AssertExecutionServer(t, logs[0], delivery.ExecutionServer_SDK)
+	AssertTrafficType(t, logs[0], common.ClientInfo_PRODUCTION)
+	AssertNoCohortMembership(t, logs[0])
+	AssertInsertionPositions(t, logs[0].DeliveryLog[0].Response, []string{"0", "1", "2"}, 0)
+	AssertInsertionPositions(t, resp.Response, []string{"0", "1", "2"}, 0)
+	assert.Equal(t, 1, len(metrics.DeliveryLogs()))
+}
+
+func TestRecordingMetricsAPIRecordsCohortMembership(t *testing.T) {
+	metrics := NewRecordingMetricsAPI()
+	s := NewServer(t).WithRanker(ReverseRanker)
+	client := newRecordingClient(t, s, metrics)
+
+	req := newTestRequest(3)
+	req.Experiment = &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_TREATMENT}
+	resp, err := client.Deliver(req)
+	assert.NoError(t, err)
+	AssertInsertionPositions(t, resp.Response, []string{"2", "1", "0"}, 0)
+
+	logs, ok := metrics.WaitFor(1, time.Second)
+	assert.True(t, ok)
+	AssertCohortMembership(t, logs[0], "exp", event.CohortArm_TREATMENT)
+	AssertNoDeliveryLog(t, logs[0])
+
+	metrics.Reset()
+	req = newTestRequest(3)
+	req.Experiment = &event.CohortMembership{CohortId: "exp", Arm: event.CohortArm_CONTROL}
+	_, err = client.Deliver(req)
+	assert.NoError(t, err)
+	logs, ok = metrics.WaitFor(1, time.Second)
+	assert.True(t, ok)
+	AssertCohortMembership(t, logs[0], "exp", event.CohortArm_CONTROL)
+	AssertExecutionServer(t, logs[0], delivery.ExecutionServer_SDK)
+}
+
+func TestRecordingMetricsAPIWaitForTimesOut(t *testing.T) {
+	metrics := NewRecordingMetricsAPI().WithError(errors.New("boom"))
+	assert.EqualError(t, metrics.RunMetricsLogging(&event.LogRequest{PlatformId: 1}), "boom")
+
+	logs, ok := metrics.WaitFor(2, 20*time.Millisecond)
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(logs))
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_ = metrics.RunMetricsLogging(&event.LogRequest{PlatformId: 2})
+	}()
+	logs, ok = metrics.WaitFor(2, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2), logs[1].PlatformId)
+}
+
+func TestRecordingMetricsAPICopiesRequests(t *testing.T) {
+	metrics := NewRecordingMetricsAPI()
+	logRequest := &event.LogRequest{PlatformId: 1}
+	assert.NoError(t, metrics.RunMetricsLogging(logRequest))
+	logRequest.PlatformId = 2
+	assert.Equal(t, uint64(1), metrics.LogRequests()[0].PlatformId)
+}
+
+func TestAssertionFailures(t *testing.T) {
+	position := uint64(5)
+	logRequest := &event.LogRequest{
+		ClientInfo:       &common.ClientInfo{TrafficType: common.ClientInfo_SHADOW},
+		DeliveryLog:      []*delivery.DeliveryLog{{Execution: &delivery.DeliveryExecution{ExecutionServer: delivery.ExecutionServer_API}}},
+		CohortMembership: []*event.CohortMembership{{CohortId: "exp", Arm: event.CohortArm_CONTROL}},
+	}
+	resp := &delivery.Response{Insertion: []*delivery.Insertion{{ContentId: "a", Position: &position}, {ContentId: "b"}}}
+
+	ft := &fakeT{}
+	assert.False(t, AssertExecutionServer(ft, logRequest, delivery.ExecutionServer_SDK))
+	assert.False(t, AssertExecutionServer(ft, &event.LogRequest{}, delivery.ExecutionServer_SDK))
+	assert.False(t, AssertNoDeliveryLog(ft, logRequest))
+	assert.False(t, AssertCohortMembership(ft, logRequest, "exp", event.CohortArm_TREATMENT))
+	assert.False(t, AssertCohortMembership(ft, logRequest, "other", event.CohortArm_CONTROL))
+	assert.False(t, AssertNoCohortMembership(ft, logRequest))
+	assert.False(t, AssertTrafficType(ft, logRequest, common.ClientInfo_PRODUCTION))
+	assert.False(t, AssertInsertionPositions(ft, resp, []string{"a"}, 0))
+	assert.False(t, AssertInsertionPositions(ft, resp, []string{"a", "c"}, 0))
+	assert.False(t, AssertPlanReason(ft, &promoted.DeliveryResponse{
+		PlanReason:     promoted.PlanReasonNoExperiment,
+		FallbackReason: promoted.FallbackReasonTimeout,
+	}, promoted.PlanReasonControlArm))
+	assert.Equal(t, []string{
+		"DeliveryLog[0] execution server = API; want SDK",
+		"log request has no DeliveryLog; want execution server SDK",
+		"log request has 1 DeliveryLog(s); want none",
+		"cohort \"exp\" arm = CONTROL; want TREATMENT",
+		"log request has no cohort membership for \"other\"; got [exp]",
+		"log request has 1 cohort membership(s); want none",
+		"traffic type = SHADOW; want PRODUCTION",
+		"response has 2 insertions; want 1",
+		"insertion[0] position = 5; want 0",
+		"insertion[0] insertion ID is not set",
+		"insertion[1] content ID = \"b\"; want \"c\"",
+		"insertion[1] position is not set; want 1",
+		"insertion[1] insertion ID is not set",
+		"plan reason = no_experiment; want control_arm",
+		"fell back to SDK delivery: timeout",
+	}, ft.errors)
+}
@@ -0,0 +1,68 @@
+package promotedtest
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+)
+
+// Ranker orders the request insertions for a fake /deliver call. It returns the insertions in ranked order and
+// must not modify the request.
+type Ranker func(req *delivery.Request) []*delivery.Insertion
+
+// IdentityRanker keeps the request order. It is the default.
+func IdentityRanker(req *delivery.Request) []*delivery.Insertion {
+	return append([]*delivery.Insertion(nil), req.GetInsertion()...)
+}
+
+// ReverseRanker reverses the request order.
+func ReverseRanker(req *delivery.Request) []*delivery.Insertion {
+	insertions := req.GetInsertion()
+	ranked := make([]*delivery.Insertion, len(insertions))
+	for i, ins := range insertions {
+		ranked[len(insertions)-1-i] = ins
+	}
+	return ranked
+}
+
+// ShuffleRanker shuffles the request order with a seeded source, so runs are repeatable.
+func ShuffleRanker(seed int64) Ranker {
+	var mu sync.Mutex
+	rng := rand.New(rand.NewSource(seed))
+	return func(req *delivery.Request) []*delivery.Insertion {
+		ranked := IdentityRanker(req)
+		mu.Lock()
+		defer mu.Unlock()
+		rng.Shuffle(len(ranked), func(i, j int) {
+			ranked[i], ranked[j] = ranked[j], ranked[i]
+		})
+		return ranked
+	}
+}
+
+// ScriptedRanker puts the listed content IDs first, in the listed order. Listed IDs that are not in the request are
+// skipped, and request insertions that are not listed follow in request order.
+func ScriptedRanker(contentIDs ...string) Ranker {
+	return func(req *delivery.Request) []*delivery.Insertion {
+		byContentID := make(map[string][]*delivery.Insertion, len(req.GetInsertion()))
+		for _, ins := range req.GetInsertion() {
+			byContentID[ins.GetContentId()] = append(byContentID[ins.GetContentId()], ins)
+		}
+		ranked := make([]*delivery.Insertion, 0, len(req.GetInsertion()))
+		used := make(map[*delivery.Insertion]bool, len(req.GetInsertion()))
+		for _, id := range contentIDs {
+			if matches := byContentID[id]; len(matches) > 0 {
+				ranked = append(ranked, matches[0])
+				used[matches[0]] = true
+				byContentID[id] = matches[1:]
+			}
+		}
+		for _, ins := range req.GetInsertion() {
+			if !used[ins] {
+				ranked = append(ranked, ins)
+			}
+		}
+		return ranked
+	}
+}
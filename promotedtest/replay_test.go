@@ -0,0 +1,44 @@
+package promotedtest
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+func TestRecordAndReplayAgainstServer(t *testing.T) {
+	production := NewServer(t).WithRanker(ReverseRanker)
+	var recording bytes.Buffer
+	client, err := production.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithAPIFactory(&promoted.RecordingAPIFactory{Writer: &recording, SampleRate: 1, Redactor: promoted.RedactUserInfo}).
+		Build()
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err := client.Deliver(newTestRequest(3))
+		assert.NoError(t, err)
+	}
+
+	// Replaying against the same ranking matches.
+	replay := NewServer(t).WithRanker(ReverseRanker)
+	replayClient, err := replay.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).Build()
+	assert.NoError(t, err)
+	report, err := promoted.NewReplayer(replayClient).Replay(bytes.NewReader(recording.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.Records)
+	assert.Equal(t, 3, report.Matched)
+	assert.Empty(t, report.Differences)
+	assert.Equal(t, "", replay.DeliveryRequests()[0].UserInfo.AnonUserId)
+
+	// A different ranking is reported.
+	replay.WithRanker(IdentityRanker)
+	report, err = promoted.NewReplayer(replayClient).Replay(bytes.NewReader(recording.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Matched)
+	assert.Equal(t, 6, len(report.Differences))
+	assert.Equal(t, "insertion[0].contentId", report.Differences[0].Field)
+	assert.Equal(t, "2", report.Differences[0].Recorded)
+	assert.Equal(t, "0", report.Differences[0].Replayed)
+}
@@ -0,0 +1,528 @@
+// Package promotedtest provides an in-process fake of Promoted.ai's Delivery and Metrics APIs for integration
+// tests. Unlike mocking DeliveryAPI, it exercises the client's HTTP, gzip, header and JSON handling.
+package promotedtest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+const (
+	// DeliverPath is the Delivery API path.
+	DeliverPath = "/deliver"
+
+	// HealthPath is the Delivery API health check path, also used for warmup.
+	HealthPath = "/healthz"
+
+	// MetricsPath is the Metrics API path.
+	MetricsPath = "/log"
+
+	// DefaultAPIKey is the key ConfigureBuilder sets when the server does not require one, since the builder
+	// requires API keys.
+	DefaultAPIKey = "promotedtest-key"
+)
+
+// RecordedRequest is a request received by the fake server.
+type RecordedRequest struct {
+	// Method is the HTTP method.
+	Method string
+
+	// Path is the URL path.
+	Path string
+
+	// Header is the request header.
+	Header http.Header
+
+	// Body is the request body, decompressed if it was sent with a gzip or zstd Content-Encoding.
+	Body []byte
+
+	// StatusCode is the status the server answered with.
+	StatusCode int
+
+	// DeliveryRequest is the decoded body of a /deliver request.
+	DeliveryRequest *delivery.Request
+
+	// LogRequest is the decoded body of a Metrics API request. Oneof fields, such as paging offsets, are not decoded
+	// inside the logged delivery requests.
+	LogRequest *event.LogRequest
+
+	// Received is when the server received the request.
+	Received time.Time
+}
+
+// fault is an injected failure for the next calls to an endpoint.
+type fault struct {
+	statusCode int
+	remaining  int
+}
+
+// Server is a fake Delivery and Metrics API. Configure it with the With... methods, which are safe to call while
+// the server is handling requests.
+type Server struct {
+	// Server is the underlying test server.
+	*httptest.Server
+
+	mu             sync.Mutex
+	apiKey         string
+	ranker         Ranker
+	latency        time.Duration
+	gzip           bool
+	deliverFaults  []fault
+	metricsFaults  []fault
+	record         bool
+	requests       []*RecordedRequest
+	counts         map[string]int
+	requestCounter int
+}
+
+// NewServer starts a fake server that is closed when the test finishes. It ranks with IdentityRanker, accepts
+// any API key and compresses responses when the client accepts gzip.
+func NewServer(t testing.TB) *Server {
+	s := NewUnstartedServer()
+	s.Start()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// NewUnstartedServer returns a fake server that is not started, for use outside of tests or with StartTLS.
+// Callers must call Start or StartTLS and then Close.
+func NewUnstartedServer() *Server {
+	s := &Server{ranker: IdentityRanker, gzip: true, record: true, counts: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc(DeliverPath, s.handleDeliver)
+	mux.HandleFunc(HealthPath, s.handleHealth)
+	mux.HandleFunc(MetricsPath, s.handleMetrics)
+	s.Server = httptest.NewUnstartedServer(mux)
+	return s
+}
+
+// DeliveryEndpoint is the endpoint to configure the client's Delivery API with.
+func (s *Server) DeliveryEndpoint() string {
+	return s.URL + DeliverPath
+}
+
+// MetricsEndpoint is the endpoint to configure the client's Metrics API with.
+func (s *Server) MetricsEndpoint() string {
+	return s.URL + MetricsPath
+}
+
+// ConfigureBuilder points the builder's Delivery and Metrics APIs at the fake server, using its API key, or
+// DefaultAPIKey when none is required, and the default HTTP API clients.
+func (s *Server) ConfigureBuilder(b *promoted.PromotedDeliveryClientBuilder) *promoted.PromotedDeliveryClientBuilder {
+	s.mu.Lock()
+	apiKey := s.apiKey
+	s.mu.Unlock()
+	if apiKey == "" {
+		apiKey = DefaultAPIKey
+	}
+	return b.
+		WithAPIFactory(&promoted.DefaultAPIFactory{}).
+		WithDeliveryEndpoint(s.DeliveryEndpoint()).
+		WithDeliveryAPIKey(apiKey).
+		WithMetricsEndpoint(s.MetricsEndpoint()).
+		WithMetricsAPIKey(apiKey)
+}
+
+// WithAPIKey requires the x-api-key header to match. Requests with a missing or wrong key get a 403.
+func (s *Server) WithAPIKey(apiKey string) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = apiKey
+	return s
+}
+
+// WithRanker sets the ranker used by /deliver.
+func (s *Server) WithRanker(ranker Ranker) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ranker = ranker
+	return s
+}
+
+// WithLatency delays every response. The delay ends early if the client gives up.
+func (s *Server) WithLatency(latency time.Duration) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = latency
+	return s
+}
+
+// WithGzip sets whether responses are compressed when the client accepts gzip.
+func (s *Server) WithGzip(gzip bool) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gzip = gzip
+	return s
+}
+
+// FailDeliver answers the next times calls to /deliver with statusCode. A negative times fails every call.
+// Faults queue up in the order they are added.
+func (s *Server) FailDeliver(statusCode, times int) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deliverFaults = append(s.deliverFaults, fault{statusCode: statusCode, remaining: times})
+	return s
+}
+
+// FailMetrics answers the next times calls to the Metrics API with statusCode. A negative times fails every call.
+func (s *Server) FailMetrics(statusCode, times int) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metricsFaults = append(s.metricsFaults, fault{statusCode: statusCode, remaining: times})
+	return s
+}
+
+// WithRecording sets whether requests are kept for Requests, DeliveryRequests and LogRequests. Load tests turn
+// recording off so memory does not grow with the number of requests. RequestCount counts requests either way.
+func (s *Server) WithRecording(record bool) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record = record
+	return s
+}
+
+// RequestCount returns the number of requests received for the path, such as DeliverPath or MetricsPath.
+func (s *Server) RequestCount(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[path]
+}
+
+// WaitForRequestCount waits until n requests have been received for the path and returns whether they were before
+// the timeout. Unlike WaitForLogRequests, it works with recording turned off.
+func (s *Server) WaitForRequestCount(path string, n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for s.RequestCount(path) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return true
+}
+
+// Requests returns every request received so far, in arrival order.
+func (s *Server) Requests() []*RecordedRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*RecordedRequest(nil), s.requests...)
+}
+
+// DeliveryRequests returns the decoded bodies of the /deliver requests received so far.
+func (s *Server) DeliveryRequests() []*delivery.Request {
+	var reqs []*delivery.Request
+	for _, r := range s.Requests() {
+		if r.DeliveryRequest != nil {
+			reqs = append(reqs, r.DeliveryRequest)
+		}
+	}
+	return reqs
+}
+
+// LogRequests returns the decoded bodies of the Metrics API requests received so far.
+func (s *Server) LogRequests() []*event.LogRequest {
+	var reqs []*event.LogRequest
+	for _, r := range s.Requests() {
+		if r.LogRequest != nil {
+			reqs = append(reqs, r.LogRequest)
+		}
+	}
+	return reqs
+}
+
+// WaitForLogRequests waits until n Metrics API requests have been received, since the client logs in the
+// background. It returns the log requests received so far and whether there were n before the timeout.
+func (s *Server) WaitForLogRequests(n int, timeout time.Duration) ([]*event.LogRequest, bool) {
+	deadline := time.Now().Add(timeout)
+	for {
+		reqs := s.LogRequests()
+		if len(reqs) >= n {
+			return reqs, true
+		}
+		if time.Now().After(deadline) {
+			return reqs, false
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Reset clears the recorded requests, request counts and injected faults.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	s.counts = map[string]int{}
+	s.deliverFaults = nil
+	s.metricsFaults = nil
+}
+
+func (s *Server) handleDeliver(w http.ResponseWriter, r *http.Request) {
+	rec, ok := s.begin(w, r, http.MethodPost, &s.deliverFaults)
+	if !ok {
+		return
+	}
+
+	req, err := decodeDeliveryRequest(rec.Body)
+	if err != nil {
+		s.finish(w, rec, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	rec.DeliveryRequest = req
+
+	s.mu.Lock()
+	ranker := s.ranker
+	s.requestCounter++
+	requestID := "fake-request-" + strconv.Itoa(s.requestCounter)
+	s.mu.Unlock()
+
+	resp := &delivery.Response{RequestId: requestID, Insertion: page(ranker(req), req.GetPaging(), requestID)}
+	body, err := protojson.Marshal(resp)
+	if err != nil {
+		s.finish(w, rec, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	s.finish(w, rec, http.StatusOK, body)
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if rec, ok := s.begin(w, r, http.MethodGet, nil); ok {
+		s.finish(w, rec, http.StatusOK, []byte("ok"))
+	}
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	rec, ok := s.begin(w, r, http.MethodPost, &s.metricsFaults)
+	if !ok {
+		return
+	}
+	var logRequest event.LogRequest
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(rec.Body, &logRequest); err != nil {
+		s.finish(w, rec, http.StatusBadRequest, []byte(err.Error()))
+		return
+	}
+	rec.LogRequest = &logRequest
+	s.finish(w, rec, http.StatusOK, []byte("{}"))
+}
+
+// begin records the request, waits out the latency and checks the method, API key and injected faults. It
+// returns false when the request has already been answered.
+func (s *Server) begin(w http.ResponseWriter, r *http.Request, method string, faults *[]fault) (*RecordedRequest, bool) {
+	body, err := readRequestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	rec := &RecordedRequest{
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Header:   r.Header.Clone(),
+		Body:     body,
+		Received: time.Now(),
+	}
+
+	s.mu.Lock()
+	if s.record {
+		s.requests = append(s.requests, rec)
+	}
+	s.counts[rec.Path]++
+	latency := s.latency
+	apiKey := s.apiKey
+	statusCode := 0
+	if faults != nil {
+		statusCode = nextFault(faults)
+	}
+	s.mu.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+		}
+	}
+
+	switch {
+	case r.Method != method:
+		s.finish(w, rec, http.StatusMethodNotAllowed, nil)
+		return nil, false
+	case apiKey != "" && r.Header.Get("x-api-key") != apiKey:
+		s.finish(w, rec, http.StatusForbidden, []byte(`{"message":"Forbidden"}`))
+		return nil, false
+	case statusCode != 0:
+		s.finish(w, rec, statusCode, []byte(http.StatusText(statusCode)))
+		return nil, false
+	}
+	return rec, true
+}
+
+// nextFault consumes the first pending fault and returns its status code, or 0.
+func nextFault(faults *[]fault) int {
+	for len(*faults) > 0 {
+		f := &(*faults)[0]
+		if f.remaining == 0 {
+			*faults = (*faults)[1:]
+			continue
+		}
+		if f.remaining > 0 {
+			f.remaining--
+		}
+		return f.statusCode
+	}
+	return 0
+}
+
+// finish writes the response, compressing it when enabled and accepted, and records the status.
+func (s *Server) finish(w http.ResponseWriter, rec *RecordedRequest, statusCode int, body []byte) {
+	s.mu.Lock()
+	rec.StatusCode = statusCode
+	useGzip := s.gzip
+	s.mu.Unlock()
+
+	if useGzip && len(body) > 0 && rec.Header.Get("Accept-Encoding") == "gzip" {
+		var buf bytes.Buffer
+		zw := gzipWriters.Get().(*gzip.Writer)
+		zw.Reset(&buf)
+		_, _ = zw.Write(body)
+		_ = zw.Close()
+		gzipWriters.Put(zw)
+		body = buf.Bytes()
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+// readRequestBody reads the request body, decompressing it according to its Content-Encoding.
+func readRequestBody(r *http.Request) ([]byte, error) {
+	switch encoding := r.Header.Get("Content-Encoding"); encoding {
+	case "":
+		return io.ReadAll(r.Body)
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip request body: %v", err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case "zstd":
+		zr, err := zstd.NewReader(r.Body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd request body: %v", err)
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+}
+
+// gzipWriters reuses gzip writers, which are large enough to dominate allocations in load tests.
+var gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+
+// page assigns insertion IDs and positions to the requested page of the ranked insertions.
+func page(ranked []*delivery.Insertion, paging *delivery.Paging, requestID string) []*delivery.Insertion {
+	offset := int(paging.GetOffset())
+	if offset < 0 {
+		offset = 0
+	}
+	size := int(paging.GetSize())
+	if size <= 0 || size > len(ranked) {
+		size = len(ranked)
+	}
+
+	insertions := make([]*delivery.Insertion, 0, size)
+	for i := 0; i < size; i++ {
+		position := uint64(offset + i)
+		insertions = append(insertions, &delivery.Insertion{
+			ContentId:   ranked[i].GetContentId(),
+			InsertionId: fmt.Sprintf("%s-%d", requestID, position),
+			Position:    &position,
+		})
+	}
+	return insertions
+}
+
+// wireProperties, wirePaging and wireRequest capture the oneof fields that the client's encoding/json request
+// body writes in Go field names, which protojson does not read.
+type wireProperties struct {
+	StructField struct {
+		Struct json.RawMessage
+	}
+}
+
+type wirePaging struct {
+	Starting struct {
+		Offset *int32
+		Cursor *string
+	}
+}
+
+type wireRequest struct {
+	Paging     *wirePaging     `json:"paging"`
+	Properties *wireProperties `json:"properties"`
+	Insertion  []struct {
+		Properties *wireProperties `json:"properties"`
+	} `json:"insertion"`
+}
+
+// decodeDeliveryRequest decodes a request body written by the client.
+func decodeDeliveryRequest(body []byte) (*delivery.Request, error) {
+	var req delivery.Request
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid delivery request: %v", err)
+	}
+	var wire wireRequest
+	if err := json.Unmarshal(body, &wire); err != nil {
+		return nil, fmt.Errorf("invalid delivery request: %v", err)
+	}
+
+	if wire.Paging != nil && req.Paging != nil {
+		if wire.Paging.Starting.Offset != nil {
+			req.Paging.Starting = &delivery.Paging_Offset{Offset: *wire.Paging.Starting.Offset}
+		} else if wire.Paging.Starting.Cursor != nil {
+			req.Paging.Starting = &delivery.Paging_Cursor{Cursor: *wire.Paging.Starting.Cursor}
+		}
+	}
+	var err error
+	if req.Properties, err = decodeProperties(wire.Properties); err != nil {
+		return nil, err
+	}
+	for i, ins := range wire.Insertion {
+		if i < len(req.Insertion) {
+			if req.Insertion[i].Properties, err = decodeProperties(ins.Properties); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &req, nil
+}
+
+// decodeProperties decodes struct properties, returning nil when there are none.
+func decodeProperties(wire *wireProperties) (*common.Properties, error) {
+	if wire == nil || len(wire.StructField.Struct) == 0 {
+		return nil, nil
+	}
+	s := &structpb.Struct{}
+	if err := protojson.Unmarshal(wire.StructField.Struct, s); err != nil {
+		return nil, fmt.Errorf("invalid properties: %v", err)
+	}
+	return &common.Properties{StructField: &common.Properties_Struct{Struct: s}}, nil
+}
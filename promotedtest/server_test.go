@@ -0,0 +1,226 @@
+package promotedtest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/promotedai/schema/generated/go/proto/common"
+	"github.com/promotedai/schema/generated/go/proto/delivery"
+	"github.com/promotedai/schema/generated/go/proto/event"
+	"github.com/stretchr/testify/assert"
+
+	promoted "github.com/promotedai/promoted-go-delivery-client/delivery"
+)
+
+func newTestClient(t *testing.T, s *Server) *promoted.PromotedDeliveryClient {
+	client, err := s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithAcceptsGzip(true).
+		Build()
+	assert.NoError(t, err)
+	return client
+}
+
+func newTestRequest(numInsertions int) *promoted.DeliveryRequest {
+	req := &delivery.Request{
+		UserInfo: &common.UserInfo{AnonUserId: "anon"},
+		Paging:   promoted.NewPaging(int32(numInsertions), 0),
+	}
+	for i := 0; i < numInsertions; i++ {
+		req.Insertion = append(req.Insertion, &delivery.Insertion{ContentId: strconv.Itoa(i)})
+	}
+	return &promoted.DeliveryRequest{Request: req}
+}
+
+func contentIDs(insertions []*delivery.Insertion) []string {
+	var ids []string
+	for _, ins := range insertions {
+		ids = append(ids, ins.GetContentId())
+	}
+	return ids
+}
+
+func TestServerDeliversThroughClient(t *testing.T) {
+	s := NewServer(t).WithAPIKey("secret").WithRanker(ReverseRanker)
+	client := newTestClient(t, s)
+
+	resp, err := client.Deliver(newTestRequest(3))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+	assert.Equal(t, "fake-request-1", resp.Response.RequestId)
+	assert.Equal(t, []string{"2", "1", "0"}, contentIDs(resp.Response.Insertion))
+	assert.Equal(t, uint64(1), resp.Response.Insertion[1].GetPosition())
+	assert.Equal(t, "fake-request-1-1", resp.Response.Insertion[1].InsertionId)
+	AssertPlanReason(t, resp, promoted.PlanReasonNoExperiment)
+
+	reqs := s.Requests()
+	assert.Equal(t, 1, len(reqs))
+	assert.Equal(t, DeliverPath, reqs[0].Path)
+	assert.Equal(t, "secret", reqs[0].Header.Get("x-api-key"))
+	assert.Equal(t, "gzip", reqs[0].Header.Get("Accept-Encoding"))
+	assert.Equal(t, http.StatusOK, reqs[0].StatusCode)
+
+	sent := s.DeliveryRequests()[0]
+	assert.Equal(t, "anon", sent.UserInfo.AnonUserId)
+	assert.Equal(t, common.ClientInfo_PLATFORM_SERVER, sent.ClientInfo.ClientType)
+	assert.NotEmpty(t, sent.ClientRequestId)
+}
+
+func TestServerDecodesOneofFields(t *testing.T) {
+	s := NewServer(t)
+	client := newTestClient(t, s)
+
+	req := newTestRequest(5)
+	req.Request.Paging = promoted.NewPaging(2, 3)
+	req.RetrievalInsertionOffset = 0
+	props, err := promoted.MarshalProperties(map[string]any{"query": "shoes"})
+	assert.NoError(t, err)
+	req.Request.Properties = props
+	req.Request.Insertion[0].Properties = props
+
+	resp, err := client.Deliver(req)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0", "1"}, contentIDs(resp.Response.Insertion))
+	assert.Equal(t, uint64(3), resp.Response.Insertion[0].GetPosition())
+
+	sent := s.DeliveryRequests()[0]
+	assert.Equal(t, int32(3), sent.Paging.GetOffset())
+	assert.Equal(t, "shoes", sent.Properties.GetStruct().Fields["query"].GetStringValue())
+	assert.Equal(t, "shoes", sent.Insertion[0].Properties.GetStruct().Fields["query"].GetStringValue())
+}
+
+func TestServerRejectsWrongAPIKey(t *testing.T) {
+	s := NewServer(t).WithAPIKey("secret")
+	client, err := s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithDeliveryAPIKey("wrong").
+		Build()
+	assert.NoError(t, err)
+
+	resp, err := client.Deliver(newTestRequest(2))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Equal(t, http.StatusForbidden, s.Requests()[0].StatusCode)
+
+	// The SDK fallback is logged to the Metrics API.
+	logs, ok := s.WaitForLogRequests(1, time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, delivery.ExecutionServer_SDK, logs[0].DeliveryLog[0].Execution.ExecutionServer)
+}
+
+func TestServerInjectedFaults(t *testing.T) {
+	s := NewServer(t).FailDeliver(http.StatusServiceUnavailable, 1)
+	client := newTestClient(t, s)
+
+	resp, err := client.Deliver(newTestRequest(2))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+
+	resp, err = client.Deliver(newTestRequest(2))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+
+	deliverStatuses := []int{}
+	for _, r := range s.Requests() {
+		if r.Path == DeliverPath {
+			deliverStatuses = append(deliverStatuses, r.StatusCode)
+		}
+	}
+	assert.Equal(t, []int{http.StatusServiceUnavailable, http.StatusOK}, deliverStatuses)
+}
+
+func TestServerFailsMetrics(t *testing.T) {
+	s := NewServer(t).FailMetrics(http.StatusInternalServerError, -1)
+	metrics := promoted.NewPromotedMetricsAPI(s.MetricsEndpoint(), "", 1000)
+	err := metrics.RunMetricsLogging(&event.LogRequest{PlatformId: 1})
+	assert.EqualError(t, err, "failure calling Metrics API; statusCode=500")
+	err = metrics.RunMetricsLogging(&event.LogRequest{PlatformId: 1})
+	assert.Error(t, err)
+}
+
+func TestServerLatencyTimesOut(t *testing.T) {
+	s := NewServer(t).WithLatency(time.Second)
+	client, err := s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+		WithDeliveryTimeoutMillis(20).
+		Build()
+	assert.NoError(t, err)
+
+	start := time.Now()
+	resp, err := client.Deliver(newTestRequest(2))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_SDK, resp.ExecutionServer)
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestServerWithoutGzip(t *testing.T) {
+	s := NewServer(t).WithGzip(false)
+	client := newTestClient(t, s)
+	resp, err := client.Deliver(newTestRequest(2))
+	assert.NoError(t, err)
+	assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+}
+
+func TestServerDecompressesRequests(t *testing.T) {
+	for _, encoding := range []promoted.Compression{promoted.CompressionGzip, promoted.CompressionZstd} {
+		s := NewServer(t)
+		client, err := s.ConfigureBuilder(promoted.NewPromotedDeliveryClientBuilder()).
+			WithRequestCompression(promoted.RequestCompression{Encoding: encoding, MinBytes: 1}).
+			Build()
+		assert.NoError(t, err)
+
+		resp, err := client.Deliver(newTestRequest(3))
+		assert.NoError(t, err)
+		assert.Equal(t, delivery.ExecutionServer_API, resp.ExecutionServer)
+		reqs := s.Requests()
+		assert.Equal(t, encoding.String(), reqs[0].Header.Get("Content-Encoding"))
+		assert.Equal(t, "anon", s.DeliveryRequests()[0].UserInfo.AnonUserId)
+	}
+
+	s := NewServer(t)
+	req, err := http.NewRequest(http.MethodPost, s.URL+DeliverPath, strings.NewReader("{}"))
+	assert.NoError(t, err)
+	req.Header.Set("Content-Encoding", "br")
+	resp, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestServerHealthAndMethods(t *testing.T) {
+	s := NewServer(t).WithAPIKey("secret")
+
+	resp, err := http.Get(s.URL + HealthPath)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+HealthPath, nil)
+	req.Header.Set("x-api-key", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = http.Get(s.DeliveryEndpoint())
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+
+	s.Reset()
+	assert.Empty(t, s.Requests())
+}
+
+func TestRankers(t *testing.T) {
+	req := newTestRequest(5).Request
+
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, contentIDs(IdentityRanker(req)))
+	assert.Equal(t, []string{"4", "3", "2", "1", "0"}, contentIDs(ReverseRanker(req)))
+	assert.Equal(t, []string{"3", "1", "0", "2", "4"}, contentIDs(ScriptedRanker("3", "missing", "1")(req)))
+
+	first := contentIDs(ShuffleRanker(7)(req))
+	assert.Equal(t, first, contentIDs(ShuffleRanker(7)(req)))
+	assert.ElementsMatch(t, []string{"0", "1", "2", "3", "4"}, first)
+	// Rankers do not modify the request.
+	assert.Equal(t, []string{"0", "1", "2", "3", "4"}, contentIDs(req.Insertion))
+}